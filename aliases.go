@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// aliasReview is the alias target that marks an OCR result as too ambiguous
+// to use as a title, so the photo is flagged for manual review instead.
+const aliasReview = "review"
+
+// AliasMap maps OCR results (normalized with normalizeTitle) to the titles
+// that should be used in their place.
+type AliasMap map[string]string
+
+// loadAliases reads a JSON object mapping OCR results to titles from path.
+// An empty path yields an empty map.
+func loadAliases(path string) (AliasMap, error) {
+	aliases := make(AliasMap)
+	if path == "" {
+		return aliases, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading aliases file: %v", err)
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error decoding aliases file: %v", err)
+	}
+	for from, to := range raw {
+		aliases[normalizeTitle(from)] = to
+	}
+	return aliases, nil
+}
+
+// resolve returns the title to use for the given OCR text, and whether the
+// text is mapped to aliasReview. Text without an alias is returned unchanged.
+func (m AliasMap) resolve(text string) (string, bool) {
+	to, ok := m[normalizeTitle(text)]
+	if !ok {
+		return text, false
+	}
+	if to == aliasReview {
+		return text, true
+	}
+	return to, false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TitleBackup is the file written to backup_dir before a run changes any
+// photos, recording the titles they had.
+type TitleBackup struct {
+	RunID   string              `json:"run_id"`
+	Created time.Time           `json:"created"`
+	Photos  []TitleBackupRecord `json:"photos"`
+}
+
+type TitleBackupRecord struct {
+	ID      string `json:"id"`
+	AlbumID string `json:"album_id"`
+	Title   string `json:"title"`
+}
+
+// writeTitleBackup writes the photos' current titles to a file named for
+// the run in dir, returning its path.
+func writeTitleBackup(dir, runID string, photos []Photo) (string, error) {
+	backup := TitleBackup{RunID: runID, Created: time.Now(), Photos: []TitleBackupRecord{}}
+	for _, photo := range photos {
+		backup.Photos = append(backup.Photos, TitleBackupRecord{ID: photo.ID, AlbumID: photo.AlbumID, Title: photo.Title})
+	}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error encoding title backup: %v", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("error creating backup directory: %v", err)
+	}
+	path := filepath.Join(dir, "titles-"+runID+".json")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("error creating title backup: %v", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return "", fmt.Errorf("error writing title backup: %v", err)
+	}
+	// Make sure the backup is on disk before any title is changed
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return "", fmt.Errorf("error writing title backup: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("error writing title backup: %v", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchResult summarizes the OCR requests made at one concurrency level.
+type benchResult struct {
+	Concurrency int
+	Requests    int
+	Elapsed     time.Duration
+	Latencies   []time.Duration // sorted
+	NoText      int
+	Errors      int
+}
+
+// percentile returns the qth percentile (0 < q <= 1) of the latencies.
+func (r benchResult) percentile(q float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(r.Latencies)))) - 1
+	return r.Latencies[max(i, 0)]
+}
+
+// runBench implements the "bench" command, which OCRs a set of cropped
+// images at several concurrency levels and reports how the OCR provider
+// performs.
+func runBench(ctx context.Context, config *Config, ocr OCRProvider, downloader *Downloader, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	requests := fs.Int("requests", 20, "Number of OCR requests to make at each concurrency level")
+	levelsFlag := fs.String("concurrency", "1,2,4,8", "Comma-separated concurrency levels to test")
+	samples := fs.Int("samples", 10, "Number of distinct images to use, reused as needed")
+	synthetic := fs.Bool("synthetic", false, "Use generated images instead of photos sampled from the configured albums")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 || *requests < 1 || *samples < 1 {
+		return fmt.Errorf("usage: lychee-birb-title [flags] bench [-requests N] [-concurrency 1,2,4] [-samples N] [-synthetic]")
+	}
+	var levels []int
+	for _, s := range strings.Split(*levelsFlag, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid concurrency level %q", s)
+		}
+		levels = append(levels, n)
+	}
+
+	dir, err := os.MkdirTemp("", "lychee-birb-title-bench-")
+	if err != nil {
+		return fmt.Errorf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	prepStart := time.Now()
+	var images []string
+	if *synthetic {
+		images, err = syntheticBenchImages(dir, *samples, config)
+	} else {
+		images, err = sampleBenchImages(ctx, dir, *samples, config, downloader)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Prepared images", "count", len(images), "elapsed", time.Since(prepStart).Round(time.Millisecond))
+	slog.Info("Benchmarking OCR", "requests", *requests, "concurrency_levels", len(levels))
+
+	var results []benchResult
+	for _, level := range levels {
+		if ctx.Err() != nil {
+			break
+		}
+		result := benchLevel(ctx, ocr, images, *requests, level)
+		slog.Info("Finished concurrency level", "concurrency", level, "requests", result.Requests, "elapsed", result.Elapsed.Round(time.Millisecond))
+		results = append(results, result)
+	}
+	printBenchReport(results)
+	return nil
+}
+
+// benchLevel makes n OCR requests, cycling through images, with the given
+// number running at once.
+func benchLevel(ctx context.Context, ocr OCRProvider, images []string, n, concurrency int) benchResult {
+	result := benchResult{Concurrency: concurrency, Requests: n}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			requestStart := time.Now()
+			_, err := ocr.DetectText(ctx, images[i%len(images)])
+			latency := time.Since(requestStart)
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.Latencies = append(result.Latencies, latency)
+			switch {
+			case err == nil:
+			case strings.Contains(err.Error(), "no text detected"):
+				result.NoText++
+			default:
+				result.Errors++
+			}
+		}()
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+
+	sort.Slice(result.Latencies, func(i, j int) bool { return result.Latencies[i] < result.Latencies[j] })
+	return result
+}
+
+// sampleBenchImages downloads and crops up to n still photos from the
+// configured albums.
+func sampleBenchImages(ctx context.Context, dir string, n int, config *Config, downloader *Downloader) ([]string, error) {
+	imageVariants, err := sizeVariantPreference(config.SizeVariants, defaultSizeVariants)
+	if err != nil {
+		return nil, fmt.Errorf("error in size_variants config: %v", err)
+	}
+	library, err := openLibrary(config, downloader)
+	if err != nil {
+		return nil, err
+	}
+	defer library.Close()
+	albumIDs, err := selectAlbums(config, library)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, albumID := range albumIDs {
+		photos, err := library.Photos(albumID, imageVariants, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error querying photos in album %s: %v", albumID, err)
+		}
+		for _, photo := range photos {
+			if len(images) == n {
+				return images, nil
+			}
+			photo = locatePhoto(config, photo)
+			if photo.IsVideo() || isGIFFile(photo.ImageURL) {
+				continue
+			}
+			path, err := benchImage(ctx, dir, config, downloader, photo)
+			if err != nil {
+				slog.Warn("Skipping photo", "photo_id", photo.ID, "error", err)
+				continue
+			}
+			images = append(images, path)
+		}
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no photos found to sample; try -synthetic")
+	}
+	return images, nil
+}
+
+// benchImage downloads a photo and crops it as it would be for OCR.
+func benchImage(ctx context.Context, dir string, config *Config, downloader *Downloader, photo Photo) (string, error) {
+	filePath, err := fetchPhotoFile(ctx, downloader, photo, photo.ImageURL, photo.ImageFile)
+	if err != nil {
+		return "", fmt.Errorf("error downloading file: %v", err)
+	}
+	defer func() { _ = os.Remove(filePath) }()
+
+	croppedPath, err := cropImage(filePath, config.Crop, config.Image)
+	if err != nil {
+		return "", fmt.Errorf("error cropping image: %v", err)
+	}
+	path := filepath.Join(dir, photo.ID+".jpg")
+	if err := os.Rename(croppedPath, path); err != nil {
+		_ = os.Remove(croppedPath)
+		return "", fmt.Errorf("error moving cropped image: %v", err)
+	}
+	return path, nil
+}
+
+// syntheticBenchImages generates n noisy images the size of a typical camera
+// overlay crop. They contain no text, so requests test the provider's
+// throughput rather than its accuracy.
+func syntheticBenchImages(dir string, n int, config *Config) ([]string, error) {
+	var images []string
+	for i := 0; i < n; i++ {
+		rng := rand.New(rand.NewPCG(1, uint64(i)))
+		img := image.NewGray(image.Rect(0, 0, 1440, 216))
+		for j := range img.Pix {
+			img.Pix[j] = uint8(rng.IntN(256))
+		}
+		// Run the image through the configured processing, as a real
+		// crop would be
+		path := filepath.Join(dir, fmt.Sprintf("synthetic-%d.jpg", i))
+		if err := writeJPEG(path, preprocessImage(img, config.Image)); err != nil {
+			return nil, err
+		}
+		images = append(images, path)
+	}
+	return images, nil
+}
+
+func printBenchReport(results []benchResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nCONCURRENCY\tREQUESTS\tREQ/S\tP50\tP90\tP99\tMAX\tNO TEXT\tERRORS")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%d\t%d\t%.2f\t%s\t%s\t%s\t%s\t%d\t%.1f%%\n",
+			r.Concurrency, r.Requests, float64(r.Requests)/r.Elapsed.Seconds(),
+			r.percentile(0.5).Round(time.Millisecond), r.percentile(0.9).Round(time.Millisecond),
+			r.percentile(0.99).Round(time.Millisecond), r.percentile(1).Round(time.Millisecond),
+			r.NoText, 100*float64(r.Errors)/float64(r.Requests))
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BirdNETConfig configures identifying birds in videos by their calls, using
+// a BirdNET analyzer, when OCR finds no overlay text.
+type BirdNETConfig struct {
+	// Command and Args run a local analyzer. The placeholders {input} and
+	// {output} in Args are replaced with the path of the extracted WAV file
+	// and the path where the analyzer should write its CSV results.
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+
+	// URL is an HTTP analyzer endpoint. The WAV file is POSTed as the request
+	// body, and the response must be a JSON array of detections like
+	// [{"common_name": "Blue Jay", "confidence": 0.91}].
+	URL string `json:"url"`
+
+	// MinConfidence is the lowest confidence (0-1) accepted as an
+	// identification (default 0.7).
+	MinConfidence float64 `json:"min_confidence"`
+}
+
+const defaultBirdNETMinConfidence = 0.7
+
+// birdnetTimeout bounds each request to a BirdNET analyzer server, which may
+// take a while on long clips.
+const birdnetTimeout = 2 * time.Minute
+
+func (c BirdNETConfig) enabled() bool {
+	return c.Command != "" || c.URL != ""
+}
+
+func (c BirdNETConfig) minConfidence() float64 {
+	if c.MinConfidence > 0 {
+		return c.MinConfidence
+	}
+	return defaultBirdNETMinConfidence
+}
+
+// BirdNETDetection is a species identified in an audio clip.
+type BirdNETDetection struct {
+	CommonName string  `json:"common_name"`
+	Confidence float64 `json:"confidence"`
+}
+
+// identifyByAudio extracts the audio track from the video and runs it
+// through the configured BirdNET analyzer, returning the most confident
+// detection at or above the minimum confidence, or nil if there is none.
+func identifyByAudio(videoPath string, config BirdNETConfig, videoConfig VideoConfig, client *http.Client) (*BirdNETDetection, error) {
+	audioPath, err := extractAudio(videoPath, videoConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(audioPath) }()
+
+	var detections []BirdNETDetection
+	if config.URL != "" {
+		detections, err = analyzeAudioHTTP(client, audioPath, config.URL)
+	} else {
+		detections, err = analyzeAudioCommand(audioPath, config, videoConfig.timeout())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var best *BirdNETDetection
+	for i, d := range detections {
+		if d.Confidence < config.minConfidence() || d.CommonName == "" {
+			continue
+		}
+		if best == nil || d.Confidence > best.Confidence {
+			best = &detections[i]
+		}
+	}
+	return best, nil
+}
+
+// extractAudio writes the video's audio track to a temporary mono 48kHz WAV
+// file (BirdNET's native sample rate) and returns its path.
+func extractAudio(videoPath string, config VideoConfig) (string, error) {
+	tmpFile, err := os.CreateTemp("", "audio-*.wav")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, config.ffmpegPath(),
+		"-i", videoPath, // Input video
+		"-vn",      // Drop the video stream
+		"-ac", "1", // Mono
+		"-ar", "48000", // 48kHz
+		"-y", // Overwrite output file if it exists
+		tmpFile.Name())
+
+	output, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("ffmpeg timed out after %s", config.timeout())
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("error extracting audio: %v (ffmpeg output: %s)", err, string(output))
+	}
+
+	return tmpFile.Name(), nil
+}
+
+func analyzeAudioCommand(audioPath string, config BirdNETConfig, timeout time.Duration) ([]BirdNETDetection, error) {
+	outDir, err := os.MkdirTemp("", "birdnet-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(outDir) }()
+	outPath := filepath.Join(outDir, "results.csv")
+
+	args := make([]string, len(config.Args))
+	for i, arg := range config.Args {
+		arg = strings.ReplaceAll(arg, "{input}", audioPath)
+		args[i] = strings.ReplaceAll(arg, "{output}", outPath)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, config.Command, args...).CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("analyzer timed out after %s", timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error running analyzer: %v (output: %s)", err, string(output))
+	}
+
+	file, err := os.Open(outPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening analyzer results: %v", err)
+	}
+	defer file.Close()
+
+	return parseBirdNETResults(file)
+}
+
+// parseBirdNETResults parses BirdNET-Analyzer's CSV or selection-table (TSV)
+// output, using the "Common name" and "Confidence" columns.
+func parseBirdNETResults(r io.Reader) ([]BirdNETDetection, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading analyzer results: %v", err)
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	if firstLine, _, _ := strings.Cut(string(data), "\n"); strings.Contains(firstLine, "\t") {
+		reader.Comma = '\t'
+	}
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing analyzer results: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	nameCol, confCol := -1, -1
+	for i, h := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "common name":
+			nameCol = i
+		case "confidence":
+			confCol = i
+		}
+	}
+	if nameCol < 0 || confCol < 0 {
+		return nil, fmt.Errorf("analyzer results lack Common name and Confidence columns")
+	}
+
+	var detections []BirdNETDetection
+	for _, record := range records[1:] {
+		if len(record) <= max(nameCol, confCol) {
+			continue
+		}
+		confidence, err := strconv.ParseFloat(strings.TrimSpace(record[confCol]), 64)
+		if err != nil {
+			continue
+		}
+		detections = append(detections, BirdNETDetection{
+			CommonName: strings.TrimSpace(record[nameCol]),
+			Confidence: confidence,
+		})
+	}
+	return detections, nil
+}
+
+func analyzeAudioHTTP(client *http.Client, audioPath string, url string) ([]BirdNETDetection, error) {
+	audio, err := os.ReadFile(audioPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading audio: %v", err)
+	}
+
+	resp, err := client.Post(url, "audio/wav", bytes.NewReader(audio))
+	if err != nil {
+		return nil, fmt.Errorf("error calling analyzer: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status from analyzer: %s", resp.Status)
+	}
+
+	var detections []BirdNETDetection
+	if err := json.NewDecoder(resp.Body).Decode(&detections); err != nil {
+		return nil, fmt.Errorf("error decoding analyzer response: %v", err)
+	}
+	return detections, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// BurstConfig enables reusing a title across a burst of photos taken within
+// a few seconds of each other, so only the first photo of the burst is OCRed.
+type BurstConfig struct {
+	// WindowSeconds is the largest gap between consecutive photos in a burst
+	// (0 disables burst detection).
+	WindowSeconds float64 `json:"window_seconds"`
+}
+
+// groupBursts splits photos into bursts: runs of photos in the same album,
+// each taken within the configured window of the one before it. Albums are
+// grouped in the order they first appear, and each album's photos are sorted
+// by the time they were taken. With burst detection disabled, every photo is
+// in a group of its own, in the original order.
+func groupBursts(photos []Photo, config BurstConfig) [][]Photo {
+	window := time.Duration(config.WindowSeconds * float64(time.Second))
+
+	var groups [][]Photo
+	if window <= 0 {
+		for _, photo := range photos {
+			groups = append(groups, []Photo{photo})
+		}
+		return groups
+	}
+
+	var albums []string
+	byAlbum := make(map[string][]Photo)
+	for _, photo := range photos {
+		if _, ok := byAlbum[photo.AlbumID]; !ok {
+			albums = append(albums, photo.AlbumID)
+		}
+		byAlbum[photo.AlbumID] = append(byAlbum[photo.AlbumID], photo)
+	}
+
+	for _, album := range albums {
+		sorted := byAlbum[album]
+		slices.SortStableFunc(sorted, func(a, b Photo) int { return a.TakenAt.Compare(b.TakenAt) })
+		for i, photo := range sorted {
+			if i > 0 && !photo.TakenAt.IsZero() && !sorted[i-1].TakenAt.IsZero() &&
+				photo.TakenAt.Sub(sorted[i-1].TakenAt) <= window {
+				last := len(groups) - 1
+				groups[last] = append(groups[last], photo)
+				continue
+			}
+			groups = append(groups, []Photo{photo})
+		}
+	}
+	return groups
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroupBursts(t *testing.T) {
+	base := time.Date(2026, 5, 1, 7, 0, 0, 0, time.UTC)
+	photo := func(id, album string, seconds int) Photo {
+		return Photo{ID: id, AlbumID: album, TakenAt: base.Add(time.Duration(seconds) * time.Second)}
+	}
+
+	tests := []struct {
+		name   string
+		window float64
+		photos []Photo
+		want   [][]string
+	}{
+		{
+			name:   "disabled",
+			photos: []Photo{photo("a1", "a", 0), photo("a2", "a", 1)},
+			want:   [][]string{{"a1"}, {"a2"}},
+		},
+		{
+			name:   "one album",
+			window: 2,
+			photos: []Photo{photo("a1", "a", 0), photo("a2", "a", 1), photo("a3", "a", 3), photo("a4", "a", 10)},
+			want:   [][]string{{"a1", "a2", "a3"}, {"a4"}},
+		},
+		{
+			name:   "unsorted",
+			window: 2,
+			photos: []Photo{photo("a3", "a", 3), photo("a1", "a", 0), photo("a4", "a", 10), photo("a2", "a", 1)},
+			want:   [][]string{{"a1", "a2", "a3"}, {"a4"}},
+		},
+		{
+			name:   "interleaved albums",
+			window: 2,
+			photos: []Photo{
+				photo("a1", "a", 0), photo("b1", "b", 0),
+				photo("a2", "a", 1), photo("b2", "b", 1),
+				photo("a3", "a", 2), photo("b3", "b", 30),
+			},
+			want: [][]string{{"a1", "a2", "a3"}, {"b1", "b2"}, {"b3"}},
+		},
+		{
+			name:   "unknown times",
+			window: 2,
+			photos: []Photo{{ID: "a1", AlbumID: "a"}, {ID: "a2", AlbumID: "a"}, photo("a3", "a", 0)},
+			want:   [][]string{{"a1"}, {"a2"}, {"a3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]string
+			for _, group := range groupBursts(tt.photos, BurstConfig{WindowSeconds: tt.window}) {
+				var ids []string
+				for _, p := range group {
+					ids = append(ids, p.ID)
+				}
+				got = append(got, ids)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupBursts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
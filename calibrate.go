@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CalibrationSample is a photo whose correct title is already known.
+type CalibrationSample struct {
+	// Path is a local file path or an http(s) URL.
+	Path  string `json:"path"`
+	Title string `json:"title"`
+}
+
+type calibrationCandidate struct {
+	Crop    CropConfig
+	Image   ImageConfig
+	Exact   int
+	Score   float64
+	Results []string
+}
+
+var (
+	calibrationHeights = []float64{0.1, 0.15, 0.2, 0.25, 0.3}
+	calibrationOffsets = []float64{0, 0.05, 0.1}
+)
+
+func loadCalibrationSamples(path string) ([]CalibrationSample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening samples file: %v", err)
+	}
+	defer file.Close()
+
+	var samples []CalibrationSample
+	if err := json.NewDecoder(file).Decode(&samples); err != nil {
+		return nil, fmt.Errorf("error decoding samples file: %v", err)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("samples file contains no samples")
+	}
+	return samples, nil
+}
+
+// prepareSampleImage returns the path of a JPEG for the given sample, fetching
+// it and extracting a frame as needed, plus a function that removes any
+// temporary files created along the way.
+func prepareSampleImage(ctx context.Context, config *Config, downloader *Downloader, sample CalibrationSample) (string, func(), error) {
+	var tmpFiles []string
+	cleanup := func() {
+		for _, f := range tmpFiles {
+			_ = os.Remove(f)
+		}
+	}
+
+	path := sample.Path
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		downloaded, err := downloader.Download(ctx, path)
+		if err != nil {
+			return "", cleanup, err
+		}
+		tmpFiles = append(tmpFiles, downloaded)
+		path = downloaded
+	}
+
+	if isVideoFile(sample.Path) {
+		// Calibrate against the first frame that would be tried in a normal run
+		frames, err := extractFrames(path, config.Video)
+		if err != nil {
+			return "", cleanup, err
+		}
+		tmpFiles = append(tmpFiles, frames...)
+		return frames[0], cleanup, nil
+	}
+
+	if isGIFFile(sample.Path) {
+		framePath, err := extractGIFFrame(path, config.Image.maxPixels())
+		if err != nil {
+			return "", cleanup, err
+		}
+		tmpFiles = append(tmpFiles, framePath)
+		return framePath, cleanup, nil
+	}
+
+	return path, cleanup, nil
+}
+
+// normalizeTitle lowercases s and collapses runs of whitespace, for comparing
+// OCR output against known titles.
+func normalizeTitle(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
+
+// titleSimilarity returns a score between 0 (nothing in common) and 1
+// (identical after normalization).
+func titleSimilarity(got, want string) float64 {
+	got, want = normalizeTitle(got), normalizeTitle(want)
+	longest := max(len([]rune(got)), len([]rune(want)))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(got, want))/float64(longest)
+}
+
+func runCalibrate(ctx context.Context, config *Config, configFile string, samplesFile string, ocr OCRProvider, downloader *Downloader, dryRun bool) error {
+	samples, err := loadCalibrationSamples(samplesFile)
+	if err != nil {
+		return err
+	}
+
+	var candidates []*calibrationCandidate
+	for _, height := range calibrationHeights {
+		for _, offset := range calibrationOffsets {
+			for _, grayscale := range []bool{false, true} {
+				imageConfig := config.Image
+				imageConfig.Grayscale = grayscale
+				candidates = append(candidates, &calibrationCandidate{
+					Crop:  CropConfig{Height: height, Offset: offset},
+					Image: imageConfig,
+				})
+			}
+		}
+	}
+
+	slog.Info("Calibrating", "samples", len(samples), "candidate_settings", len(candidates),
+		"ocr_requests", len(samples)*len(candidates))
+
+	for _, sample := range samples {
+		imagePath, cleanup, err := prepareSampleImage(ctx, config, downloader, sample)
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("error preparing sample %s: %v", sample.Path, err)
+		}
+
+		img, err := decodeJPEG(imagePath, config.Image.maxPixels())
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("error preparing sample %s: %v", sample.Path, err)
+		}
+
+		for _, candidate := range candidates {
+			tmpFile, err := os.CreateTemp("", "calibrate-*.jpg")
+			if err != nil {
+				cleanup()
+				return fmt.Errorf("error creating temp file: %v", err)
+			}
+			tmpFile.Close()
+
+			text := ""
+			candidateImage := preprocessImage(cropRegion(img, candidate.Crop), candidate.Image)
+			if err := writeJPEG(tmpFile.Name(), candidateImage); err != nil {
+				slog.Error("Error writing candidate image", "path", sample.Path, "error", err)
+			} else {
+				text, err = ocr.DetectText(ctx, tmpFile.Name())
+				if err != nil && !strings.Contains(err.Error(), "no text detected") {
+					slog.Error("OCR error", "path", sample.Path, "error", err)
+				}
+			}
+			_ = os.Remove(tmpFile.Name())
+
+			if normalizeTitle(text) == normalizeTitle(sample.Title) {
+				candidate.Exact++
+			}
+			candidate.Score += titleSimilarity(text, sample.Title)
+			candidate.Results = append(candidate.Results, text)
+		}
+		cleanup()
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Exact != candidates[j].Exact {
+			return candidates[i].Exact > candidates[j].Exact
+		}
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	fmt.Printf("%-8s %-8s %-10s %-8s %s\n", "HEIGHT", "OFFSET", "GRAYSCALE", "EXACT", "SIMILARITY")
+	for _, c := range candidates {
+		fmt.Printf("%-8.2f %-8.2f %-10t %d/%-6d %.3f\n",
+			c.Crop.Height, c.Crop.Offset, c.Image.Grayscale, c.Exact, len(samples), c.Score/float64(len(samples)))
+	}
+
+	best := candidates[0]
+	fmt.Printf("\nBest settings: crop height %.2f, offset %.2f, grayscale %t (%d/%d exact matches)\n",
+		best.Crop.Height, best.Crop.Offset, best.Image.Grayscale, best.Exact, len(samples))
+	for i, sample := range samples {
+		fmt.Printf("\t%s: want %q, got %q\n", sample.Path, sample.Title, strings.TrimSpace(best.Results[i]))
+	}
+
+	if dryRun {
+		fmt.Printf("\nDry run: not writing settings to %s (run with -dry-run=false to save them)\n", configFile)
+		return nil
+	}
+
+	if err := writeCalibration(configFile, best.Crop, best.Image); err != nil {
+		return err
+	}
+	fmt.Printf("\nSaved settings to %s\n", configFile)
+	return nil
+}
+
+// writeCalibration stores the given crop and image settings in the config
+// file, leaving the rest of its contents intact.
+func writeCalibration(configFile string, crop CropConfig, imageConfig ImageConfig) error {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("error decoding config file: %v", err)
+	}
+
+	imageSection, _ := raw["image"].(map[string]any)
+	if imageSection == nil {
+		imageSection = make(map[string]any)
+	}
+	imageSection["grayscale"] = imageConfig.Grayscale
+	raw["image"] = imageSection
+	raw["crop"] = crop
+
+	out, err := json.MarshalIndent(raw, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error encoding config file: %v", err)
+	}
+	if err := os.WriteFile(configFile, append(out, '\n'), 0o600); err != nil {
+		return fmt.Errorf("error writing config file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Formats of the -report file, chosen by its extension.
+const (
+	reportHTML = "html"
+	reportCSV  = "csv"
+)
+
+// reportFormat returns the format of the -report file at path.
+func reportFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		return reportHTML, nil
+	case ".csv":
+		return reportCSV, nil
+	}
+	return "", fmt.Errorf("unknown report format for %s (expected .html or .csv)", path)
+}
+
+// changeRow is a photo in the -report file: one whose title was changed, or
+// would have been in a dry run, or that failed.
+type changeRow struct {
+	ID       string
+	Outcome  string
+	OldTitle string
+	Title    string
+	Error    string
+	ImageURL string
+	WebURL   string
+	IsVideo  bool
+}
+
+// changeRows picks the changed and failed photos out of a run's reports.
+// OldTitle is the photo's description when that's the target instead.
+func changeRows(reports []DetectionEvent, photos map[string]Photo, target string) []changeRow {
+	var rows []changeRow
+	for _, report := range reports {
+		switch report.Outcome {
+		case outcomeUpdated, outcomeIdentified, outcomeError:
+		default:
+			continue
+		}
+		photo := photos[report.PhotoID]
+		oldTitle := photo.Title
+		if target == targetDescription {
+			oldTitle = photo.Description
+		}
+		rows = append(rows, changeRow{
+			ID:       report.PhotoID,
+			Outcome:  report.Outcome,
+			OldTitle: oldTitle,
+			Title:    report.Title,
+			Error:    report.Error,
+			ImageURL: photo.ImageURL,
+			WebURL:   photo.WebLink,
+			IsVideo:  photo.IsVideo(),
+		})
+	}
+	return rows
+}
+
+var changeReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>lychee-birb-title run {{.RunID}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+img { max-width: 200px; max-height: 200px; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Run {{.RunID}}</h1>
+<p>{{.Summary}}</p>
+{{if .Rows}}<table>
+<tr><th>Photo</th><th>Outcome</th><th>Old title</th><th>New title</th><th>Error</th></tr>
+{{range .Rows}}<tr>
+<td><a href="{{.WebURL}}">{{if .IsVideo}}{{.ID}}{{else}}<img src="{{.ImageURL}}" alt="{{.ID}}" loading="lazy">{{end}}</a></td>
+<td>{{.Outcome}}</td>
+<td>{{.OldTitle}}</td>
+<td>{{.Title}}</td>
+<td class="error">{{.Error}}</td>
+</tr>
+{{end}}</table>{{else}}<p>No photos were changed.</p>{{end}}
+</body>
+</html>
+`))
+
+// writeChangeReport writes the run's changes and errors to the file at path,
+// replacing it, as HTML or CSV according to its extension.
+func writeChangeReport(path, runID string, summary RunSummary, rows []changeRow) error {
+	format, err := reportFormat(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating report file: %v", err)
+	}
+	defer file.Close()
+
+	switch format {
+	case reportHTML:
+		err = changeReportTemplate.Execute(file, struct {
+			RunID   string
+			Summary string
+			Rows    []changeRow
+		}{runID, summary.String(), rows})
+	case reportCSV:
+		w := csv.NewWriter(file)
+		w.Write([]string{"photo_id", "outcome", "old_title", "title", "error", "image_url", "web_url"})
+		for _, row := range rows {
+			w.Write([]string{row.ID, row.Outcome, row.OldTitle, row.Title, row.Error, row.ImageURL, row.WebURL})
+		}
+		w.Flush()
+		err = w.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("error writing report: %v", err)
+	}
+	return nil
+}
+
+const (
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorReset = "\x1b[0m"
+)
+
+// printDiffTable prints a before and after table of the titles a dry run
+// would set, with the old titles in red and the new in green if color is
+// set.
+func printDiffTable(w io.Writer, rows []changeRow, color bool) {
+	header := []string{"PHOTO ID", "OLD TITLE", "NEW TITLE", "WEB UI"}
+	var table [][]string
+	for _, row := range rows {
+		if row.Outcome == outcomeIdentified {
+			table = append(table, []string{row.ID, row.OldTitle, row.Title, row.WebURL})
+		}
+	}
+	if len(table) == 0 {
+		return
+	}
+
+	// Pad by hand rather than with a tabwriter, which would count the
+	// color codes as part of each column's width
+	widths := make([]int, len(header))
+	for _, cells := range append([][]string{header}, table...) {
+		for i, cell := range cells {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	printRow := func(cells []string, colors []string) {
+		var line strings.Builder
+		for i, cell := range cells {
+			if i > 0 {
+				line.WriteString("  ")
+			}
+			padding := ""
+			if i < len(cells)-1 {
+				padding = strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			}
+			if colors[i] != "" {
+				cell = colors[i] + cell + colorReset
+			}
+			line.WriteString(cell + padding)
+		}
+		fmt.Fprintln(w, line.String())
+	}
+
+	fmt.Fprintf(w, "\nProposed changes (%d):\n", len(table))
+	colors := make([]string, len(header))
+	printRow(header, colors)
+	if color {
+		colors[1], colors[2] = colorRed, colorGreen
+	}
+	for _, cells := range table {
+		printRow(cells, colors)
+	}
+}
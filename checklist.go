@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ChecklistConfig validates titles against a list of known species, to catch
+// OCR errors such as "Norther Cardinal".
+type ChecklistConfig struct {
+	// File is either a text file with one common name per line, or a CSV
+	// file, such as the eBird/Clements taxonomy, with a header row naming
+	// its common name and scientific name columns.
+	File string `json:"file"`
+	// Action is what's done with names that aren't on the checklist:
+	// "review" (the default) flags the photo for review, as an ambiguous
+	// alias does, and "reject" treats it as having no text.
+	Action string `json:"action"`
+	// MaxDistance corrects names within this many character edits of a
+	// single checklist name, or with the same words in a different order,
+	// to that name (0 to only accept exact matches).
+	MaxDistance int `json:"max_distance"`
+	// ScientificName adds the species' scientific name, from checklists
+	// that have them: "append" appends it to the title in parentheses, and
+	// "description" writes it to the photo's description.
+	ScientificName string `json:"scientific_name"`
+}
+
+const (
+	scientificNameAppend      = "append"
+	scientificNameDescription = "description"
+)
+
+func (c ChecklistConfig) validate() error {
+	switch c.ScientificName {
+	case "", scientificNameAppend, scientificNameDescription:
+	default:
+		return fmt.Errorf("unknown checklist scientific_name %q (expected append or description)", c.ScientificName)
+	}
+	if c.File == "" && (c.ScientificName != "" || c.MaxDistance > 0 || c.Action != "") {
+		return fmt.Errorf("checklist settings require a checklist file")
+	}
+	return nil
+}
+
+const (
+	checklistReview = "review"
+	checklistReject = "reject"
+)
+
+func (c ChecklistConfig) action() (string, error) {
+	switch c.Action {
+	case "", checklistReview:
+		return checklistReview, nil
+	case checklistReject:
+		return checklistReject, nil
+	default:
+		return "", fmt.Errorf("unknown checklist action %q (expected review or reject)", c.Action)
+	}
+}
+
+// Species is a checklist entry.
+type Species struct {
+	CommonName     string
+	ScientificName string // if the checklist has one
+}
+
+// Checklist maps normalized common names (see normalizeTitle) to species.
+type Checklist map[string]Species
+
+// Column names recognized in CSV checklists, lowercased. The first two are
+// the eBird taxonomy's and the next the Clements checklist's.
+var (
+	checklistCommonColumns     = []string{"primary_com_name", "english name", "common name", "common_name"}
+	checklistScientificColumns = []string{"sci_name", "scientific name", "scientific_name"}
+)
+
+// loadChecklist reads a checklist from path. An empty path yields a nil
+// checklist.
+func loadChecklist(path string) (Checklist, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checklist: %v", err)
+	}
+	defer file.Close()
+
+	checklist := make(Checklist)
+	add := func(common, scientific string) {
+		if common = strings.TrimSpace(common); common != "" {
+			checklist[normalizeTitle(common)] = Species{CommonName: common, ScientificName: strings.TrimSpace(scientific)}
+		}
+	}
+
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := scanner.Text(); !strings.HasPrefix(line, "#") {
+				add(line, "")
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading checklist: %v", err)
+		}
+		return checklist, nil
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading checklist header: %v", err)
+	}
+	commonCol, scientificCol := -1, -1
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if commonCol < 0 && slices.Contains(checklistCommonColumns, name) {
+			commonCol = i
+		}
+		if scientificCol < 0 && slices.Contains(checklistScientificColumns, name) {
+			scientificCol = i
+		}
+	}
+	if commonCol < 0 {
+		return nil, fmt.Errorf("checklist has no common name column (expected one of %s)", strings.Join(checklistCommonColumns, ", "))
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading checklist: %v", err)
+		}
+		if commonCol >= len(record) {
+			continue
+		}
+		scientific := ""
+		if scientificCol >= 0 && scientificCol < len(record) {
+			scientific = record[scientificCol]
+		}
+		add(record[commonCol], scientific)
+	}
+	return checklist, nil
+}
+
+// lookup returns the species with the given common name, ignoring case and
+// spacing.
+func (c Checklist) lookup(name string) (Species, bool) {
+	species, ok := c[normalizeTitle(name)]
+	return species, ok
+}
+
+// closest returns the species whose common name has the same words as name
+// in a different order, or failing that, is the only one within maxDistance
+// edits of it. Names no longer than maxDistance are never corrected.
+func (c Checklist) closest(name string, maxDistance int) (Species, bool) {
+	name = normalizeTitle(name)
+	if maxDistance <= 0 || len([]rune(name)) <= maxDistance {
+		return Species{}, false
+	}
+
+	words := sortedWords(name)
+	best, bestDistance, tied := Species{}, maxDistance+1, false
+	for key, species := range c {
+		if sortedWords(key) == words {
+			return species, true
+		}
+		distance := levenshtein(name, key)
+		switch {
+		case distance < bestDistance:
+			best, bestDistance, tied = species, distance, false
+		case distance == bestDistance:
+			tied = true
+		}
+	}
+	if bestDistance > maxDistance || tied {
+		return Species{}, false
+	}
+	return best, true
+}
+
+func sortedWords(s string) string {
+	words := strings.Fields(s)
+	slices.Sort(words)
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "jay", 3},
+		{"jay", "", 3},
+		{"blue jay", "blue jay", 0},
+		{"blue jay", "blue jav", 1},
+		{"kitten", "sitting", 3},
+		{"robin", "robn", 1},
+		{"wren", "owren", 1},
+		{"grosbeak", "grösbeak", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestChecklistClosest(t *testing.T) {
+	checklist := Checklist{}
+	for _, name := range []string{"Blue Jay", "Northern Cardinal", "House Finch", "House Wren", "Black-capped Chickadee", "Gray Jay", "Grey Jay"} {
+		checklist[normalizeTitle(name)] = Species{CommonName: name}
+	}
+
+	tests := []struct {
+		name        string
+		maxDistance int
+		want        string
+		wantOK      bool
+	}{
+		{"Blue Jav", 2, "Blue Jay", true},
+		{"northern  cardnal", 2, "Northern Cardinal", true},
+		{"Cardinal Northern", 2, "Northern Cardinal", true},
+		{"Black-caped Chickadee", 1, "Black-capped Chickadee", true},
+		// Too far from any name
+		{"Blue Jay Feeder", 2, "", false},
+		{"Blu Jy", 1, "", false},
+		// Equally close to two names
+		{"Grzy Jay", 2, "", false},
+		// Names no longer than max_distance are never corrected
+		{"Jy", 2, "", false},
+		// Correction is off without a max_distance
+		{"Blue Jav", 0, "", false},
+	}
+	for _, tt := range tests {
+		species, ok := checklist.closest(tt.name, tt.maxDistance)
+		if ok != tt.wantOK || species.CommonName != tt.want {
+			t.Errorf("closest(%q, %d) = %q, %v, want %q, %v", tt.name, tt.maxDistance, species.CommonName, ok, tt.want, tt.wantOK)
+		}
+	}
+}
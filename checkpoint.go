@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Photo outcomes recorded in checkpoints.
+const (
+	outcomeUpdated    = "updated"
+	outcomeIdentified = "identified" // but not written to the database
+	outcomeNoText     = "no_text"
+	outcomeReview     = "review"
+	outcomeError      = "error"
+)
+
+// checkpointEntry records the outcome of one photo in a run.
+type checkpointEntry struct {
+	RunID   string    `json:"run_id"`
+	Time    time.Time `json:"time"`
+	PhotoID string    `json:"photo_id"`
+	Outcome string    `json:"outcome"`
+}
+
+// Checkpoint records each photo's outcome as a run progresses, so that an
+// interrupted run can be resumed without handling the same photos again.
+type Checkpoint struct {
+	path string
+	file *os.File
+	// Done holds the IDs of photos handled by the run being resumed.
+	Done map[string]bool
+}
+
+func checkpointPath(config *Config) string {
+	return config.StateFile + ".checkpoint"
+}
+
+// openCheckpoint opens the checkpoint file at path. If resume is set, the
+// outcomes already recorded there are loaded into Done; otherwise, any
+// previous checkpoint is discarded.
+func openCheckpoint(path string, resume bool) (*Checkpoint, error) {
+	c := &Checkpoint{path: path, Done: make(map[string]bool)}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checkpoint file: %v", err)
+	}
+	c.file = file
+	return c, nil
+}
+
+func (c *Checkpoint) load() error {
+	file, err := os.Open(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error opening checkpoint file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry checkpointEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A crash can leave a partial last line; skip it
+			continue
+		}
+		c.Done[entry.PhotoID] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading checkpoint file: %v", err)
+	}
+	return nil
+}
+
+// Record appends a photo's outcome to the checkpoint.
+func (c *Checkpoint) Record(runID, photoID, outcome string) error {
+	data, err := json.Marshal(checkpointEntry{
+		RunID:   runID,
+		Time:    time.Now(),
+		PhotoID: photoID,
+		Outcome: outcome,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint entry: %v", err)
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing checkpoint entry: %v", err)
+	}
+	return nil
+}
+
+func (c *Checkpoint) Close() error {
+	return c.file.Close()
+}
+
+// Remove deletes the checkpoint once a run has completed.
+func (c *Checkpoint) Remove() error {
+	_ = c.file.Close()
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing checkpoint file: %v", err)
+	}
+	return nil
+}
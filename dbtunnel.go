@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// TunnelConfig describes how to reach a MySQL or PostgreSQL server that isn't
+// directly reachable, through either a SOCKS5 proxy or an SSH tunnel.
+type TunnelConfig struct {
+	// Proxy is a socks5:// or socks5h:// proxy URL.
+	Proxy string `json:"proxy"`
+
+	// SSHHost is the host (and optional port, default 22) to tunnel through;
+	// the database host and port are resolved from there.
+	SSHHost string `json:"ssh_host"`
+	SSHUser string `json:"ssh_user"`
+	// SSHKeyFile is a private key file, optionally encrypted with
+	// SSHKeyPassphrase.
+	SSHKeyFile       string `json:"ssh_key_file"`
+	SSHKeyPassphrase string `json:"ssh_key_passphrase"`
+	// SSHKnownHosts is the known_hosts file used to verify the SSH host's key
+	// (default ~/.ssh/known_hosts).
+	SSHKnownHosts string `json:"ssh_known_hosts"`
+}
+
+// tunnelNetwork is the network name under which the tunnel's dialer is
+// registered with the MySQL driver.
+const tunnelNetwork = "lychee-tunnel"
+
+func (c TunnelConfig) enabled() bool {
+	return c.Proxy != "" || c.SSHHost != ""
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dial, DialTimeout, and DialContext implement pq.Dialer and
+// pq.DialerContext.
+func (d dialFunc) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d dialFunc) DialTimeout(network, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d(ctx, network, addr)
+}
+
+func (d dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// tunnelDialer returns a function that connects to addresses through the
+// configured SOCKS proxy or SSH tunnel.
+func tunnelDialer(c TunnelConfig) (dialFunc, error) {
+	if c.Proxy != "" && c.SSHHost != "" {
+		return nil, fmt.Errorf("set either a proxy or an SSH host, not both")
+	}
+
+	if c.Proxy != "" {
+		proxyURL, err := parseProxyURL(c.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL.Scheme != "socks5" && proxyURL.Scheme != "socks5h" {
+			return nil, fmt.Errorf("database proxy must be a socks5:// URL")
+		}
+		dial, err := proxyDialer(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(ctx, addr)
+		}, nil
+	}
+
+	t, err := newSSHTunnel(c)
+	if err != nil {
+		return nil, err
+	}
+	return t.dial, nil
+}
+
+// sshTunnel forwards connections through an SSH server, connecting (and, if
+// the connection drops, reconnecting) as needed.
+type sshTunnel struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// sshTunnels holds the tunnels opened so far, keyed by user and host, so that
+// repeated runs in daemon mode share a connection.
+var (
+	sshTunnelsMu sync.Mutex
+	sshTunnels   = make(map[string]*sshTunnel)
+)
+
+func newSSHTunnel(c TunnelConfig) (*sshTunnel, error) {
+	addr := c.SSHHost
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	key := c.SSHUser + "@" + addr
+
+	sshTunnelsMu.Lock()
+	defer sshTunnelsMu.Unlock()
+	if t, ok := sshTunnels[key]; ok {
+		return t, nil
+	}
+
+	if c.SSHUser == "" || c.SSHKeyFile == "" {
+		return nil, fmt.Errorf("an SSH tunnel requires ssh_user and ssh_key_file")
+	}
+	keyData, err := os.ReadFile(c.SSHKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading SSH key: %v", err)
+	}
+	var signer ssh.Signer
+	if c.SSHKeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(c.SSHKeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SSH key: %v", err)
+	}
+
+	knownHostsPath := c.SSHKnownHosts
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("error finding known_hosts file: %v", err)
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known_hosts file: %v", err)
+	}
+
+	t := &sshTunnel{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:            c.SSHUser,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+	}
+	sshTunnels[key] = t
+	return t, nil
+}
+
+func (t *sshTunnel) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client != nil {
+		conn, err := t.client.DialContext(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		// The SSH connection may have dropped; reconnect and try once more
+		_ = t.client.Close()
+		t.client = nil
+	}
+
+	client, err := ssh.Dial("tcp", t.addr, t.config)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to SSH host %s: %v", t.addr, err)
+	}
+	t.client = client
+	return client.DialContext(ctx, network, addr)
+}
+
+// openTunneledDatabase opens a MySQL or PostgreSQL database whose
+// connections are made through the configured tunnel.
+func openTunneledDatabase(driver, dsn string, tunnel TunnelConfig) (*sql.DB, error) {
+	dial, err := tunnelDialer(tunnel)
+	if err != nil {
+		return nil, fmt.Errorf("error in database tunnel config: %v", err)
+	}
+
+	switch driver {
+	case "mysql":
+		mysql.RegisterDialContext(tunnelNetwork, func(ctx context.Context, addr string) (net.Conn, error) {
+			return dial(ctx, "tcp", addr)
+		})
+		return sql.Open(driver, dsn)
+	case "postgres":
+		connector, err := pq.NewConnector(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to database: %v", err)
+		}
+		connector.Dialer(dial)
+		return sql.OpenDB(postgresConnector{connector}), nil
+	default:
+		return nil, fmt.Errorf("database tunnels are only supported for MySQL and PostgreSQL")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Values of the disambiguate setting, which chooses what's added to a title
+// that another photo in the same album already has.
+const (
+	disambiguateNumber = "number" // "House Finch 2"
+	disambiguateDate   = "date"   // "House Finch 2024-05-01"
+	disambiguateTime   = "time"   // "House Finch 2024-05-01 07:32"
+)
+
+func checkDisambiguate(mode string) error {
+	switch mode {
+	case "", disambiguateNumber, disambiguateDate, disambiguateTime:
+		return nil
+	default:
+		return fmt.Errorf("unknown disambiguate %q (expected number, date, or time)", mode)
+	}
+}
+
+// albumTitles records the titles of the photos in each album, compared with
+// normalizeTitle.
+type albumTitles map[string]map[string]bool
+
+func (t albumTitles) add(albumID, title string) {
+	if t[albumID] == nil {
+		t[albumID] = make(map[string]bool)
+	}
+	t[albumID][normalizeTitle(title)] = true
+}
+
+func (t albumTitles) has(albumID, title string) bool {
+	return t[albumID][normalizeTitle(title)]
+}
+
+// disambiguate returns title, with the photo's date or time added if another
+// photo in its album has the title, and then a number if that's taken too.
+// A photo keeps a title it already has.
+func (t albumTitles) disambiguate(mode string, photo Photo, title string) string {
+	if mode == "" || normalizeTitle(title) == normalizeTitle(photo.Title) || !t.has(photo.AlbumID, title) {
+		return title
+	}
+
+	base := title
+	switch mode {
+	case disambiguateDate:
+		base = title + " " + photo.TakenAt.In(time.Local).Format(time.DateOnly)
+	case disambiguateTime:
+		base = title + " " + photo.TakenAt.In(time.Local).Format("2006-01-02 15:04")
+	}
+	candidate := base
+	for n := 2; t.has(photo.AlbumID, candidate); n++ {
+		candidate = fmt.Sprintf("%s %d", base, n)
+	}
+	return candidate
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DownloadConfig controls how photos are fetched from the Lychee server.
+type DownloadConfig struct {
+	// MaxBytes is the largest file that will be downloaded (0 for unlimited).
+	MaxBytes int64 `json:"max_bytes"`
+	// MaxVideoBytes, if set, is a lower limit that applies to videos.
+	MaxVideoBytes int64 `json:"max_video_bytes"`
+
+	// Cloudflare Access service token credentials
+	CFAccessClientID     string `json:"cf_access_client_id"`
+	CFAccessClientSecret string `json:"cf_access_client_secret"`
+
+	// Headers are extra headers sent with every download, and UserAgent
+	// replaces Go's default User-Agent header.
+	Headers   map[string]string `json:"headers"`
+	UserAgent string            `json:"user_agent"`
+
+	// HTTP Basic auth credentials, a bearer token, or a Cookie header value
+	// (such as an SSO session cookie) to send with every download
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	BearerToken string `json:"bearer_token"`
+	Cookie      string `json:"cookie"`
+
+	// PEM-encoded client certificate and key for mutual TLS
+	ClientCert string `json:"client_cert"`
+	ClientKey  string `json:"client_key"`
+
+	// CACert is a PEM file of CA certificates to trust in addition to the
+	// system roots, for servers with certificates from a private CA.
+	CACert string `json:"ca_cert"`
+	// InsecureSkipVerify disables TLS certificate verification entirely.
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+
+	// RequestsPerSecond limits how often downloads are started, and
+	// BytesPerSecond caps the combined download bandwidth across all workers
+	// (0 for unlimited).
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	BytesPerSecond    int64   `json:"bytes_per_second"`
+
+	// CacheDir, if set, is a directory where downloaded photos are kept so
+	// that later runs don't need to fetch them again.
+	CacheDir string `json:"cache_dir"`
+
+	Retry RetryConfig `json:"retry"`
+}
+
+// RetryConfig controls retrying failed downloads with exponential backoff.
+type RetryConfig struct {
+	// Attempts is the total number of attempts per download (default 1,
+	// meaning no retries).
+	Attempts int `json:"attempts"`
+	// InitialBackoffSeconds is the delay before the first retry (default 1);
+	// it doubles after each subsequent failure, up to MaxBackoffSeconds
+	// (default 30).
+	InitialBackoffSeconds float64 `json:"initial_backoff_seconds"`
+	MaxBackoffSeconds     float64 `json:"max_backoff_seconds"`
+	// RetryableStatusCodes lists the HTTP statuses that are worth retrying
+	// (default 408, 429, 500, 502, 503, 504). Network errors are always retried.
+	RetryableStatusCodes []int `json:"retryable_status_codes"`
+}
+
+var defaultRetryableStatusCodes = []int{408, 429, 500, 502, 503, 504}
+
+func (c RetryConfig) attempts() int {
+	return max(c.Attempts, 1)
+}
+
+// backoff returns how long to wait before the given retry (numbered from 1),
+// with up to 20% random jitter.
+func (c RetryConfig) backoff(retry int) time.Duration {
+	initial, limit := c.InitialBackoffSeconds, c.MaxBackoffSeconds
+	if initial <= 0 {
+		initial = 1
+	}
+	if limit <= 0 {
+		limit = 30
+	}
+
+	seconds := min(initial*float64(uint(1)<<min(retry-1, 30)), limit)
+	seconds *= 1 + 0.2*rand.Float64()
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func (c RetryConfig) retryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		codes := c.RetryableStatusCodes
+		if len(codes) == 0 {
+			codes = defaultRetryableStatusCodes
+		}
+		return slices.Contains(codes, statusErr.code)
+	}
+
+	var sizeErr *sizeLimitError
+	var authErr *authError
+	return !errors.As(err, &sizeErr) && !errors.As(err, &authErr)
+}
+
+// httpStatusError reports an unexpected HTTP response status.
+type httpStatusError struct {
+	code   int
+	status string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("bad status: %s", e.status)
+}
+
+// authError reports a download that returned a web page instead of a photo,
+// which usually means the request wasn't authenticated.
+type authError struct {
+	url string
+}
+
+func (e *authError) Error() string {
+	return fmt.Sprintf("got an HTML page from %s instead of a photo; check the download authentication settings", e.url)
+}
+
+// sizeLimitError reports a download that exceeded the configured size limit.
+type sizeLimitError struct {
+	msg string
+}
+
+func (e *sizeLimitError) Error() string {
+	return e.msg
+}
+
+// oversizeReason checks the file size and dimensions Lychee recorded for the
+// photo's selected size variant against the configured limits, returning why
+// the photo should be skipped, or "" if it's within the limits.
+func (c DownloadConfig) oversizeReason(photo Photo, imageConfig ImageConfig) string {
+	if photo.IsVideo() && c.MaxVideoBytes > 0 && photo.Filesize > c.MaxVideoBytes {
+		return fmt.Sprintf("video size %d bytes exceeds limit of %d bytes", photo.Filesize, c.MaxVideoBytes)
+	}
+	if c.MaxBytes > 0 && photo.Filesize > c.MaxBytes {
+		return fmt.Sprintf("file size %d bytes exceeds limit of %d bytes", photo.Filesize, c.MaxBytes)
+	}
+	if !photo.IsVideo() && int64(photo.Width)*int64(photo.Height) > imageConfig.maxPixels() {
+		return fmt.Sprintf("image dimensions %dx%d exceed limit of %d pixels", photo.Width, photo.Height, imageConfig.maxPixels())
+	}
+	return ""
+}
+
+// Downloader fetches files over HTTP according to a DownloadConfig.
+type Downloader struct {
+	client   *http.Client
+	maxBytes int64
+	retry    RetryConfig
+	cacheDir string
+
+	// requests and bytes are nil when the corresponding limit is disabled
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+}
+
+// newDownloader creates a Downloader. Credentials and custom headers are only
+// sent to the host in baseURL, so a redirect elsewhere doesn't leak them. If
+// proxyURL is nil, the standard proxy environment variables are honored.
+func newDownloader(config DownloadConfig, baseURL string, proxyURL *url.URL) (*Downloader, error) {
+	transport := proxyTransport(proxyURL)
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+
+	if config.ClientCert != "" || config.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.CACert != "" {
+		pem, err := os.ReadFile(config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA certificate: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CACert)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if config.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled for downloads")
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	headers := make(http.Header)
+	if config.UserAgent != "" {
+		headers.Set("User-Agent", config.UserAgent)
+	}
+	credentials := make(http.Header)
+	for k, v := range config.Headers {
+		credentials.Set(k, v)
+	}
+
+	if config.CFAccessClientID != "" || config.CFAccessClientSecret != "" {
+		if config.CFAccessClientID == "" || config.CFAccessClientSecret == "" {
+			return nil, fmt.Errorf("cf_access_client_id and cf_access_client_secret must be set together")
+		}
+		credentials.Set("CF-Access-Client-Id", config.CFAccessClientID)
+		credentials.Set("CF-Access-Client-Secret", config.CFAccessClientSecret)
+	}
+
+	if config.Username != "" && config.BearerToken != "" {
+		return nil, fmt.Errorf("username and bearer_token can't both be set")
+	}
+	if config.Username != "" {
+		basic := base64.StdEncoding.EncodeToString([]byte(config.Username + ":" + config.Password))
+		credentials.Set("Authorization", "Basic "+basic)
+	}
+	if config.BearerToken != "" {
+		credentials.Set("Authorization", "Bearer "+config.BearerToken)
+	}
+	if config.Cookie != "" {
+		credentials.Set("Cookie", config.Cookie)
+	}
+
+	var host string
+	if len(credentials) > 0 {
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("base_url is required to send download credentials or headers")
+		}
+		host = u.Host
+	}
+
+	d := &Downloader{
+		client: &http.Client{
+			Transport: &headerTransport{base: transport, headers: headers, credentials: credentials, host: host},
+		},
+		maxBytes: config.MaxBytes,
+		retry:    config.Retry,
+		cacheDir: config.CacheDir,
+	}
+	if d.cacheDir != "" {
+		if err := os.MkdirAll(d.cacheDir, 0o755); err != nil {
+			return nil, fmt.Errorf("error creating cache directory: %v", err)
+		}
+	}
+	if config.RequestsPerSecond > 0 {
+		d.requests = rate.NewLimiter(rate.Limit(config.RequestsPerSecond), 1)
+	}
+	if config.BytesPerSecond > 0 {
+		// Allow up to one second's worth of data per read
+		burst := int(min(config.BytesPerSecond, math.MaxInt32))
+		d.bytes = rate.NewLimiter(rate.Limit(config.BytesPerSecond), burst)
+	}
+	return d, nil
+}
+
+// throttledReader limits the rate at which data can be read from r.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.limiter.Burst() {
+		p = p[:t.limiter.Burst()]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// headerTransport adds a fixed set of headers to every request that doesn't
+// already set them, and credentials to those that are to host.
+type headerTransport struct {
+	base        http.RoundTripper
+	headers     http.Header
+	credentials http.Header
+	host        string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var credentials http.Header
+	if t.host != "" && strings.EqualFold(req.URL.Host, t.host) {
+		credentials = t.credentials
+	}
+	if len(t.headers) == 0 && len(credentials) == 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for _, headers := range []http.Header{t.headers, credentials} {
+		for k, v := range headers {
+			if _, ok := req.Header[k]; !ok {
+				req.Header[k] = v
+			}
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Download fetches url into a temporary file and returns the file's path,
+// retrying transient failures according to the retry policy.
+func (d *Downloader) Download(ctx context.Context, url string) (string, error) {
+	var err error
+	for attempt := 1; attempt <= d.retry.attempts(); attempt++ {
+		if attempt > 1 {
+			wait := d.retry.backoff(attempt - 1)
+			slog.Warn("Retrying download", "url", url, "wait", wait.Round(time.Millisecond),
+				"attempt", attempt, "attempts", d.retry.attempts(), "error", err)
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		var path string
+		path, err = d.download(ctx, url)
+		if err == nil {
+			return path, nil
+		}
+		if !d.retry.retryable(err) {
+			break
+		}
+	}
+	return "", err
+}
+
+// DownloadPhoto fetches url, one of photo's files, into a temporary file and
+// returns the file's path. If a cache directory is configured, the file is
+// copied from the cache when possible and stored there after downloading.
+func (d *Downloader) DownloadPhoto(ctx context.Context, photo Photo, url string) (string, error) {
+	if d.cacheDir == "" {
+		return d.Download(ctx, url)
+	}
+
+	// The checksum changes when the original is replaced, and the file name
+	// distinguishes between the photo's size variants
+	key := photo.ID
+	if photo.Checksum != "" {
+		key += "-" + photo.Checksum
+	}
+	cachePath := filepath.Join(d.cacheDir, key+"-"+path.Base(url))
+
+	if _, err := os.Stat(cachePath); err == nil {
+		return copyToTemp(cachePath, filepath.Ext(url))
+	}
+
+	tmpPath, err := d.Download(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	if err := copyFile(tmpPath, cachePath); err != nil {
+		slog.Error("Error caching download", "url", url, "error", err)
+	}
+	return tmpPath, nil
+}
+
+// copyToTemp copies the file at src to a new temporary file with the given
+// extension and returns the temporary file's path.
+func copyToTemp(src, ext string) (string, error) {
+	tmpFile, err := os.CreateTemp("", "file-*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	if err := copyFile(src, tmpFile.Name()); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", err
+	}
+	return tmpFile.Name(), nil
+}
+
+// copyFile copies src to dst, replacing dst atomically so that a partially
+// written file is never visible at dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening file: %v", err)
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %v", err)
+	}
+	defer func() { _ = os.Remove(out.Name()) }()
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("error copying file: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error copying file: %v", err)
+	}
+	if err := os.Rename(out.Name(), dst); err != nil {
+		return fmt.Errorf("error copying file: %v", err)
+	}
+	return nil
+}
+
+func (d *Downloader) download(ctx context.Context, url string) (string, error) {
+	if d.requests != nil {
+		if err := d.requests.Wait(ctx); err != nil {
+			return "", fmt.Errorf("error waiting for rate limiter: %v", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error downloading file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &httpStatusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	// An authentication proxy that doesn't accept our credentials typically
+	// redirects to its login page rather than returning an error status
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return "", &authError{url: resp.Request.URL.String()}
+	}
+
+	if d.maxBytes > 0 && resp.ContentLength > d.maxBytes {
+		return "", &sizeLimitError{fmt.Sprintf("file size %d bytes exceeds limit of %d bytes", resp.ContentLength, d.maxBytes)}
+	}
+
+	// Determine file extension from URL
+	ext := filepath.Ext(url)
+	if ext == "" {
+		ext = ".jpg" // Default to jpg if no extension found
+	}
+
+	// Create a temporary file with the appropriate extension
+	tmpFile, err := os.CreateTemp("", "file-*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file: %v", err)
+	}
+	defer tmpFile.Close()
+
+	// Copy the file data, reading at most one byte past the limit so an
+	// oversized response without a Content-Length can be detected
+	var body io.Reader = resp.Body
+	if d.maxBytes > 0 {
+		body = io.LimitReader(body, d.maxBytes+1)
+	}
+	if d.bytes != nil {
+		body = &throttledReader{ctx: ctx, r: body, limiter: d.bytes}
+	}
+	n, err := io.Copy(tmpFile, body)
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("error saving file: %v", err)
+	}
+	if d.maxBytes > 0 && n > d.maxBytes {
+		_ = os.Remove(tmpFile.Name())
+		return "", &sizeLimitError{fmt.Sprintf("file exceeds limit of %d bytes", d.maxBytes)}
+	}
+
+	return tmpFile.Name(), nil
+}
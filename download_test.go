@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestDownloadRedirectCredentials(t *testing.T) {
+	credentialHeaders := []string{"Authorization", "Cookie", "CF-Access-Client-Id", "CF-Access-Client-Secret", "X-Bypass-Token"}
+
+	var elsewhere http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		elsewhere = r.Header.Clone()
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("\xff\xd8\xff\xe0photo"))
+	}))
+	defer other.Close()
+
+	var lychee http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lychee = r.Header.Clone()
+		http.Redirect(w, r, other.URL+"/photo.jpg", http.StatusFound)
+	}))
+	defer server.Close()
+
+	d, err := newDownloader(DownloadConfig{
+		BearerToken:          "token",
+		Cookie:               "session=abc",
+		CFAccessClientID:     "id.access",
+		CFAccessClientSecret: "secret",
+		Headers:              map[string]string{"X-Bypass-Token": "shared"},
+		UserAgent:            "lychee-birb-title-test",
+	}, server.URL, nil)
+	if err != nil {
+		t.Fatalf("newDownloader: %v", err)
+	}
+	path, err := d.Download(context.Background(), server.URL+"/uploads/photo.jpg")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	os.Remove(path)
+
+	for _, header := range credentialHeaders {
+		if lychee.Get(header) == "" {
+			t.Errorf("request to base_url host is missing %s", header)
+		}
+		if elsewhere.Get(header) != "" {
+			t.Errorf("redirect to another host sent %s: %q", header, elsewhere.Get(header))
+		}
+	}
+	for _, h := range []http.Header{lychee, elsewhere} {
+		if got := h.Get("User-Agent"); got != "lychee-birb-title-test" {
+			t.Errorf("User-Agent = %q, want it sent to every host", got)
+		}
+	}
+}
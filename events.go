@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// detectionEventVersion is the schema version of DetectionEvent. Fields may
+// be added without changing it; bump it when a field is removed, renamed, or
+// changes meaning.
+const detectionEventVersion = 1
+
+// DetectionEvent describes a bird identified in a photo. It's the common
+// format for every integration that reports identifications, and for the
+// outcomes of the photos in a run, which leave out the species of photos
+// that weren't identified.
+type DetectionEvent struct {
+	SchemaVersion int    `json:"schema_version"`
+	RunID         string `json:"run_id"`
+	PhotoID       string `json:"photo_id"`
+	AlbumID       string `json:"album_id"`
+	Species       string `json:"species,omitempty"`
+	// Provider is how the species was identified: "ocr", "birdnet", "burst"
+	// (copied from an earlier photo in the same burst), or "duplicate"
+	// (copied from an earlier photo that looks the same).
+	Provider string `json:"provider,omitempty"`
+	// Inferred is set if the species was copied from another photo, rather
+	// than identified in this one.
+	Inferred bool `json:"inferred,omitempty"`
+	// Confidence is only reported by providers that measure it, and by
+	// cameras that show it in their overlays.
+	Confidence *float64 `json:"confidence,omitempty"`
+	// Temperature is shown in some cameras' overlays, such as "72°F".
+	Temperature string `json:"temperature,omitempty"`
+	// ObservedAt is when the photo was taken, and DetectedAt is when this
+	// program identified the species.
+	ObservedAt time.Time `json:"observed_at"`
+	DetectedAt time.Time `json:"detected_at"`
+	PhotoURL   string    `json:"photo_url"`
+	WebURL     string    `json:"web_url"`
+
+	// Outcome is the photo's outcome in a run: one of the checkpoint
+	// outcomes (updated, identified, no_text, review, or error). It's
+	// empty in the sightings log and the HTTP sink.
+	Outcome string `json:"outcome,omitempty"`
+	// Title is the title the photo was given, or would have been given in
+	// a dry run, and Error is why the photo failed.
+	Title string `json:"title,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// newPhotoEvent returns an event for a photo with no species.
+func newPhotoEvent(runID string, photo Photo) DetectionEvent {
+	return DetectionEvent{
+		SchemaVersion: detectionEventVersion,
+		RunID:         runID,
+		PhotoID:       photo.ID,
+		AlbumID:       photo.AlbumID,
+		ObservedAt:    photo.TakenAt,
+		DetectedAt:    time.Now(),
+		PhotoURL:      photo.ImageURL,
+		WebURL:        photo.WebLink,
+	}
+}
+
+func newDetectionEvent(runID, albumID string, photo Photo, species string, result PhotoResult) DetectionEvent {
+	event := newPhotoEvent(runID, photo)
+	event.AlbumID = albumID
+	event.Species = strings.TrimSpace(species)
+	event.Provider = result.Source
+	event.Inferred = result.Source == sourceBurst || result.Source == sourceDuplicate
+	event.Temperature = result.Temperature
+	if result.Source == sourceBirdNET || result.Confidence > 0 {
+		confidence := result.Confidence
+		event.Confidence = &confidence
+	}
+	return event
+}
+
+// appendSighting appends the event to the sightings log at path as a JSON
+// line.
+func appendSighting(path string, event DetectionEvent) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening sightings log: %v", err)
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(event); err != nil {
+		return fmt.Errorf("error writing sightings log: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilterConfig restricts processing to photos that have been curated in
+// Lychee. Photos that don't match are skipped without being downloaded.
+type FilterConfig struct {
+	StarredOnly bool `json:"starred_only"`
+	// Tags, if set, limits processing to photos with at least one of these
+	// tags (compared case-insensitively).
+	Tags []string `json:"tags"`
+	// Since and Until limit processing to photos taken (or, if that isn't
+	// known, uploaded) in a date range; see parseTimeBound.
+	Since string `json:"since"`
+	Until string `json:"until"`
+}
+
+// dateRange is a range of times when photos were taken. Either end may be
+// zero, for an open-ended range; Until is exclusive.
+type dateRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// dateRange returns the range set by Since and Until, with relative bounds
+// measured back from now.
+func (c FilterConfig) dateRange(now time.Time) (dateRange, error) {
+	var r dateRange
+	var err error
+	if r.Since, err = parseTimeBound(c.Since, now, false); err != nil {
+		return r, fmt.Errorf("error in filter since: %v", err)
+	}
+	if r.Until, err = parseTimeBound(c.Until, now, true); err != nil {
+		return r, fmt.Errorf("error in filter until: %v", err)
+	}
+	if !r.Since.IsZero() && !r.Until.IsZero() && !r.Since.Before(r.Until) {
+		return r, fmt.Errorf("filter since must be before until")
+	}
+	return r, nil
+}
+
+func (r dateRange) contains(t time.Time) bool {
+	return (r.Since.IsZero() || !t.Before(r.Since)) && (r.Until.IsZero() || t.Before(r.Until))
+}
+
+// parseTimeBound parses a date range bound: an RFC 3339 time, a local date
+// (YYYY-MM-DD), or an age such as "7d" or "36h". A date used as the end of a
+// range includes the whole day.
+func parseTimeBound(s string, now time.Time, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, time, or age", s)
+}
+
+// matches reports whether the photo passes the filter.
+func (c FilterConfig) matches(photo Photo) bool {
+	if c.StarredOnly && !photo.Starred {
+		return false
+	}
+	if len(c.Tags) == 0 {
+		return true
+	}
+	for _, want := range c.Tags {
+		for _, tag := range photo.Tags {
+			if strings.EqualFold(strings.TrimSpace(want), tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTags splits Lychee's comma-separated tags column.
+func parseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcMessages mirrors the messages in birbtitle.proto. Fields are numbered
+// in order from 1. Building the descriptors here, and using dynamic messages,
+// avoids needing protoc to build the program.
+var grpcMessages = []struct {
+	name   string
+	fields []grpcField
+}{
+	{"ProcessPhotoRequest", []grpcField{{"image", grpcBytes}}},
+	{"ProcessPhotoResponse", []grpcField{{"title", grpcString}, {"no_text", grpcBool}, {"needs_review", grpcBool}, {"text", grpcString}}},
+	{"OcrImageRequest", []grpcField{{"image", grpcBytes}}},
+	{"OcrImageResponse", []grpcField{{"text", grpcString}, {"no_text", grpcBool}}},
+	{"GetRunStatusRequest", nil},
+	{"GetRunStatusResponse", []grpcField{
+		{"last_run_id", grpcString}, {"last_run_started", grpcInt64}, {"last_run_finished", grpcInt64}, {"dry_run", grpcBool},
+		{"found", grpcInt32}, {"processed", grpcInt32}, {"updated", grpcInt32}, {"review_tasks", grpcInt32}, {"errors", grpcInt32},
+		{"next_run", grpcInt64},
+	}},
+}
+
+type grpcField struct {
+	name string
+	kind descriptorpb.FieldDescriptorProto_Type
+}
+
+const (
+	grpcBytes  = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+	grpcString = descriptorpb.FieldDescriptorProto_TYPE_STRING
+	grpcBool   = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	grpcInt32  = descriptorpb.FieldDescriptorProto_TYPE_INT32
+	grpcInt64  = descriptorpb.FieldDescriptorProto_TYPE_INT64
+
+	grpcPackage = "birbtitle.v1"
+	grpcService = "BirbTitle"
+
+	// defaultGRPCMaxMessage replaces gRPC's 4 MiB default limit on the size
+	// of a request, which many full-size photos exceed.
+	defaultGRPCMaxMessage = 32 << 20
+)
+
+// grpcFileDescriptor builds the descriptor of birbtitle.proto.
+func grpcFileDescriptor() (protoreflect.FileDescriptor, error) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("birbtitle.proto"),
+		Package: proto.String(grpcPackage),
+		Syntax:  proto.String("proto3"),
+	}
+	for _, m := range grpcMessages {
+		message := &descriptorpb.DescriptorProto{Name: proto.String(m.name)}
+		for i, f := range m.fields {
+			message.Field = append(message.Field, &descriptorpb.FieldDescriptorProto{
+				Name:   proto.String(f.name),
+				Number: proto.Int32(int32(i + 1)),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:   f.kind.Enum(),
+			})
+		}
+		file.MessageType = append(file.MessageType, message)
+	}
+
+	service := &descriptorpb.ServiceDescriptorProto{Name: proto.String(grpcService)}
+	for _, method := range []string{"ProcessPhoto", "OcrImage", "GetRunStatus"} {
+		service.Method = append(service.Method, &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(method),
+			InputType:  proto.String("." + grpcPackage + "." + method + "Request"),
+			OutputType: proto.String("." + grpcPackage + "." + method + "Response"),
+		})
+	}
+	file.Service = append(file.Service, service)
+	return protodesc.NewFile(file, nil)
+}
+
+// grpcServer implements the BirbTitle service.
+type grpcServer struct {
+	config  *Config
+	ocr     OCRProvider
+	nextRun *atomic.Value // time.Time
+	file    protoreflect.FileDescriptor
+}
+
+// serveGRPC serves the BirbTitle service on config.GRPCAddr until ctx is
+// done. The service is also registered for reflection, so tools such as
+// grpcurl can call it without the .proto file. Without a grpc_token, it
+// only listens on loopback addresses.
+func serveGRPC(ctx context.Context, config *Config, ocr OCRProvider, nextRun *atomic.Value) error {
+	if config.GRPCToken == "" && !isLoopbackAddr(config.GRPCAddr) {
+		return fmt.Errorf("grpc_addr %s isn't a loopback address; set grpc_token to serve gRPC on other interfaces", config.GRPCAddr)
+	}
+	file, err := grpcFileDescriptor()
+	if err != nil {
+		return fmt.Errorf("error building gRPC descriptors: %v", err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(file); err != nil {
+		return fmt.Errorf("error registering gRPC descriptors: %v", err)
+	}
+	listener, err := net.Listen("tcp", config.GRPCAddr)
+	if err != nil {
+		return fmt.Errorf("error listening for gRPC: %v", err)
+	}
+
+	maxMessage := config.GRPCMaxMessage
+	if maxMessage <= 0 {
+		maxMessage = defaultGRPCMaxMessage
+	}
+
+	s := &grpcServer{config: config, ocr: ocr, nextRun: nextRun, file: file}
+	serverOpts := []grpc.ServerOption{grpc.MaxRecvMsgSize(maxMessage)}
+	if config.GRPCToken != "" {
+		auth := grpcTokenAuth(config.GRPCToken)
+		serverOpts = append(serverOpts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				if err := auth(ctx); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := auth(ss.Context()); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}),
+		)
+	}
+	server := grpc.NewServer(serverOpts...)
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: grpcPackage + "." + grpcService,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			s.method("ProcessPhoto", s.processPhoto),
+			s.method("OcrImage", s.ocrImage),
+			s.method("GetRunStatus", s.getRunStatus),
+		},
+		Metadata: "birbtitle.proto",
+	}, s)
+	reflection.Register(server)
+
+	go func() {
+		slog.Info("Serving gRPC", "addr", config.GRPCAddr)
+		if err := server.Serve(listener); err != nil {
+			slog.Error("Error serving gRPC", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	return nil
+}
+
+// isLoopbackAddr reports whether a listen address only accepts connections
+// from this host. An address without a host listens on every interface.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// grpcTokenAuth returns a function that checks that a request's
+// authorization metadata is "Bearer <token>".
+func grpcTokenAuth(token string) func(context.Context) error {
+	want := []byte("Bearer " + token)
+	return func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, got := range md.Get("authorization") {
+			if subtle.ConstantTimeCompare([]byte(got), want) == 1 {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or invalid token")
+	}
+}
+
+func (s *grpcServer) newMessage(name string) *dynamicpb.Message {
+	return dynamicpb.NewMessage(s.file.Messages().ByName(protoreflect.Name(name)))
+}
+
+// method adapts a function handling one of the service's methods to gRPC.
+func (s *grpcServer) method(name string, handle func(context.Context, *dynamicpb.Message) (*dynamicpb.Message, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(_ any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := s.newMessage(name + "Request")
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return handle(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: s, FullMethod: "/" + grpcPackage + "." + grpcService + "/" + name}
+			return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+				return handle(ctx, req.(*dynamicpb.Message))
+			})
+		},
+	}
+}
+
+// set sets the named fields of a message, leaving zero values unset as
+// proto3 does.
+func set(msg *dynamicpb.Message, values map[string]any) *dynamicpb.Message {
+	fields := msg.Descriptor().Fields()
+	for name, v := range values {
+		value := protoreflect.ValueOf(v)
+		if !value.Equal(msg.NewField(fields.ByName(protoreflect.Name(name)))) {
+			msg.Set(fields.ByName(protoreflect.Name(name)), value)
+		}
+	}
+	return msg
+}
+
+// requestImage writes the request's image to a temporary file.
+func requestImage(req *dynamicpb.Message) (string, error) {
+	image := req.Get(req.Descriptor().Fields().ByName("image")).Bytes()
+	if len(image) == 0 {
+		return "", status.Error(codes.InvalidArgument, "image is required")
+	}
+	file, err := os.CreateTemp("", "lychee-birb-title-grpc-*.jpg")
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "error creating temp file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(image); err != nil {
+		_ = os.Remove(file.Name())
+		return "", status.Errorf(codes.Internal, "error writing temp file: %v", err)
+	}
+	return file.Name(), nil
+}
+
+// detectText OCRs an image, reporting whether it had no text.
+func (s *grpcServer) detectText(ctx context.Context, path string) (string, bool, error) {
+	text, err := s.ocr.DetectText(ctx, path)
+	if err != nil {
+		if strings.Contains(err.Error(), "no text detected") {
+			return "", true, nil
+		}
+		return "", false, status.Errorf(codes.Internal, "OCR error: %v", err)
+	}
+	return text, false, nil
+}
+
+func (s *grpcServer) processPhoto(ctx context.Context, req *dynamicpb.Message) (*dynamicpb.Message, error) {
+	path, err := requestImage(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(path) }()
+
+	croppedPath, err := cropImage(path, s.config.Crop, s.config.Image)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "error cropping image: %v", err)
+	}
+	defer func() { _ = os.Remove(croppedPath) }()
+
+	text, noText, err := s.detectText(ctx, croppedPath)
+	if err != nil {
+		return nil, err
+	}
+	resp := s.newMessage("ProcessPhotoResponse")
+	if noText {
+		return set(resp, map[string]any{"no_text": true}), nil
+	}
+
+	// Loaded for each request so that edits to the aliases file, checklist,
+	// and so on take effect, as they do between runs
+	pipeline, err := newTitlePipeline(s.config)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	result := PhotoResult{Text: text, Source: sourceOCR}
+	titled := pipeline.apply(Photo{}, &result, false)
+	if result.NoText {
+		return set(resp, map[string]any{"no_text": true, "text": strings.TrimSpace(text)}), nil
+	}
+	return set(resp, map[string]any{
+		"title":        strings.TrimSpace(titled.Title),
+		"needs_review": titled.NeedsReview,
+		"text":         strings.TrimSpace(text),
+	}), nil
+}
+
+func (s *grpcServer) ocrImage(ctx context.Context, req *dynamicpb.Message) (*dynamicpb.Message, error) {
+	path, err := requestImage(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(path) }()
+
+	text, noText, err := s.detectText(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return set(s.newMessage("OcrImageResponse"), map[string]any{
+		"text":    strings.TrimSpace(text),
+		"no_text": noText,
+	}), nil
+}
+
+func (s *grpcServer) getRunStatus(ctx context.Context, req *dynamicpb.Message) (*dynamicpb.Message, error) {
+	state, err := loadState(s.config.StateFile)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error loading state: %v", err)
+	}
+
+	resp := s.newMessage("GetRunStatusResponse")
+	if next, ok := s.nextRun.Load().(time.Time); ok {
+		set(resp, map[string]any{"next_run": unixOrZero(next)})
+	}
+	if last := state.LastRunSummary; last != nil {
+		set(resp, map[string]any{
+			"last_run_id":       last.RunID,
+			"last_run_started":  unixOrZero(last.StartedAt),
+			"last_run_finished": unixOrZero(last.FinishedAt),
+			"dry_run":           last.DryRun,
+			"found":             int32(last.Found),
+			"processed":         int32(last.Processed),
+			"updated":           int32(last.Updated),
+			"review_tasks":      int32(last.ReviewTasks),
+			"errors":            int32(last.Errors),
+		})
+	}
+	return resp, nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
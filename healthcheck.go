@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HealthcheckConfig configures pinging a cron monitor, such as
+// healthchecks.io, as each run starts and finishes.
+type HealthcheckConfig struct {
+	// URL is the check's ping URL, e.g. https://hc-ping.com/<uuid>. It's
+	// pinged when a run succeeds, and with /start or /fail appended when
+	// one starts or fails.
+	URL string `json:"url"`
+	// FailOnErrors reports runs in which any photo failed as failures.
+	FailOnErrors bool `json:"fail_on_errors"`
+}
+
+// healthcheckTimeout bounds each ping, so a slow monitor doesn't hold up runs.
+const healthcheckTimeout = 10 * time.Second
+
+// Signals appended to the healthcheck URL.
+const (
+	healthcheckStart   = "start"
+	healthcheckSuccess = ""
+	healthcheckFail    = "fail"
+)
+
+// pingHealthcheck pings the healthcheck URL with the given signal, sending
+// body (e.g. the run summary) for the monitor to show. Errors are logged
+// rather than returned, so a monitor outage doesn't fail the run.
+func pingHealthcheck(config HealthcheckConfig, client *http.Client, signal, body string) {
+	if config.URL == "" {
+		return
+	}
+	pingURL := strings.TrimRight(config.URL, "/")
+	if signal != healthcheckSuccess {
+		pingURL += "/" + signal
+	}
+
+	resp, err := client.Post(pingURL, "text/plain; charset=utf-8", strings.NewReader(body))
+	if err != nil {
+		slog.Error("Error pinging healthcheck", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Error("Error pinging healthcheck", "error", fmt.Errorf("healthcheck returned %s", resp.Status))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// IgnoreConfig lists photos that are never processed, regardless of title.
+type IgnoreConfig struct {
+	PhotoIDs []string `json:"photo_ids"`
+	// Paths are glob patterns matched against each photo's short_path, with
+	// or without the leading "uploads/". "**" matches any number of
+	// directories.
+	Paths []string `json:"paths"`
+}
+
+// ignores reports whether the photo is excluded by the ignore list.
+func (c IgnoreConfig) ignores(photo Photo) bool {
+	for _, id := range c.PhotoIDs {
+		if id == photo.ID {
+			return true
+		}
+	}
+
+	for _, pattern := range c.Paths {
+		for _, p := range []string{photo.ShortPath, photo.OriginalShortPath} {
+			if p == "" {
+				continue
+			}
+			p = strings.TrimLeft(p, "/")
+			if matchGlob(pattern, p) || matchGlob(pattern, "uploads/"+p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchGlob matches name against a slash-separated glob pattern, in which
+// "**" matches zero or more path segments and other segments are matched
+// with path.Match.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try consuming every possible number of name segments
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
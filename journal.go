@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JournalEntry records a single change made to a photo.
+type JournalEntry struct {
+	RunID    string    `json:"run_id"`
+	Time     time.Time `json:"time"`
+	PhotoID  string    `json:"photo_id"`
+	AlbumID  string    `json:"album_id"`
+	Field    string    `json:"field"`
+	OldValue string    `json:"old_value"`
+	NewValue string    `json:"new_value"`
+}
+
+// Journal is a sink for change records.
+type Journal interface {
+	Record(entry JournalEntry) error
+	Close() error
+}
+
+// JournalReader is implemented by journals whose records can be read back.
+type JournalReader interface {
+	Entries() ([]JournalEntry, error)
+}
+
+func newJournal(config *Config) (Journal, error) {
+	switch strings.ToLower(config.Journal.Type) {
+	case "", "none":
+		return nopJournal{}, nil
+	case "file":
+		if config.Journal.Path == "" {
+			return nil, fmt.Errorf("journal type file requires a path")
+		}
+		return &fileJournal{path: config.Journal.Path}, nil
+	case "sqlite", "sqlite3":
+		if config.Journal.Path == "" {
+			return nil, fmt.Errorf("journal type sqlite requires a path")
+		}
+		return openSQLiteJournal(config.Journal.Path)
+	case "database":
+		return openDatabaseJournal(config)
+	case "http":
+		if config.Journal.URL == "" {
+			return nil, fmt.Errorf("journal type http requires a url")
+		}
+		return &httpJournal{
+			url:     config.Journal.URL,
+			headers: config.Journal.Headers,
+			client:  config.httpClient(30 * time.Second),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported journal type: %s", config.Journal.Type)
+	}
+}
+
+type nopJournal struct{}
+
+func (nopJournal) Record(JournalEntry) error { return nil }
+func (nopJournal) Close() error              { return nil }
+
+// fileJournal appends entries to a file as JSON lines.
+type fileJournal struct {
+	path string
+}
+
+func (j *fileJournal) Record(entry JournalEntry) error {
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening journal file: %v", err)
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(entry); err != nil {
+		return fmt.Errorf("error writing journal entry: %v", err)
+	}
+	return nil
+}
+
+func (j *fileJournal) Entries() ([]JournalEntry, error) {
+	file, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error opening journal file: %v", err)
+	}
+	defer file.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("error decoding journal entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading journal file: %v", err)
+	}
+	return entries, nil
+}
+
+func (j *fileJournal) Close() error { return nil }
+
+// sqlJournal stores entries in a database table: the journal table of a
+// standalone SQLite database, or the audit table in Lychee's database.
+type sqlJournal struct {
+	db    *sql.DB
+	table string
+}
+
+func openSQLiteJournal(path string) (*sqlJournal, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal database: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS journal (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id TEXT NOT NULL,
+			time TEXT NOT NULL,
+			photo_id TEXT NOT NULL,
+			album_id TEXT NOT NULL,
+			field TEXT NOT NULL,
+			old_value TEXT NOT NULL,
+			new_value TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating journal table: %v", err)
+	}
+
+	return &sqlJournal{db: db, table: "journal"}, nil
+}
+
+// auditTable is the table in Lychee's database that the database journal
+// writes to.
+const auditTable = "lychee_birb_title_audit"
+
+// openDatabaseJournal connects to Lychee's database and creates the audit
+// table if it doesn't exist.
+func openDatabaseJournal(config *Config) (*sqlJournal, error) {
+	driver, _, err := buildConnectionString(config)
+	if err != nil {
+		return nil, fmt.Errorf("journal type database: %v", err)
+	}
+	db, err := openDatabase(config)
+	if err != nil {
+		return nil, err
+	}
+
+	id := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	switch driver {
+	case "mysql":
+		id = "BIGINT AUTO_INCREMENT PRIMARY KEY"
+	case "postgres":
+		id = "BIGSERIAL PRIMARY KEY"
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ` + auditTable + ` (
+		id ` + id + `,
+		run_id VARCHAR(32) NOT NULL,
+		time VARCHAR(32) NOT NULL,
+		photo_id VARCHAR(191) NOT NULL,
+		album_id VARCHAR(191) NOT NULL,
+		field VARCHAR(64) NOT NULL,
+		old_value TEXT NOT NULL,
+		new_value TEXT NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating audit table: %v", err)
+	}
+
+	return &sqlJournal{db: db, table: auditTable}, nil
+}
+
+func (j *sqlJournal) Record(entry JournalEntry) error {
+	_, err := j.db.Exec(
+		"INSERT INTO "+j.table+" (run_id, time, photo_id, album_id, field, old_value, new_value) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		entry.RunID, entry.Time.UTC().Format(time.RFC3339), entry.PhotoID, entry.AlbumID,
+		entry.Field, entry.OldValue, entry.NewValue,
+	)
+	if err != nil {
+		return fmt.Errorf("error writing journal entry: %v", err)
+	}
+	return nil
+}
+
+func (j *sqlJournal) Entries() ([]JournalEntry, error) {
+	rows, err := j.db.Query("SELECT run_id, time, photo_id, album_id, field, old_value, new_value FROM " + j.table + " ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying journal: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []JournalEntry
+	for rows.Next() {
+		var entry JournalEntry
+		var ts string
+		if err := rows.Scan(&entry.RunID, &ts, &entry.PhotoID, &entry.AlbumID,
+			&entry.Field, &entry.OldValue, &entry.NewValue); err != nil {
+			return nil, fmt.Errorf("error scanning journal entry: %v", err)
+		}
+		entry.Time, _ = time.Parse(time.RFC3339, ts)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (j *sqlJournal) Close() error { return j.db.Close() }
+
+// httpJournal POSTs each entry as JSON to a remote endpoint.
+type httpJournal struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (j *httpJournal) Record(entry JournalEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding journal entry: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, j.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating journal request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range j.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending journal entry: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bad status from journal endpoint: %s", resp.Status)
+	}
+	return nil
+}
+
+func (j *httpJournal) Close() error { return nil }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LeaseConfig enables per-album leases kept in Lychee's database, so that
+// when several replicas run on the same schedule, only one processes each
+// album. A single run releases its leases when it finishes, while a daemon
+// holds them until it exits. A replica that dies holding a lease loses it
+// once it expires.
+type LeaseConfig struct {
+	Enabled bool `json:"enabled"`
+	// TTLSeconds is how long a lease lasts without being renewed (default
+	// 300). Leases are renewed every third of this while they're held.
+	TTLSeconds int `json:"ttl_seconds"`
+	// Holder identifies this replica (default hostname and process ID).
+	Holder string `json:"holder"`
+}
+
+const (
+	defaultLeaseTTL = 5 * time.Minute
+	leaseTable      = "lychee_birb_title_leases"
+)
+
+func (c LeaseConfig) ttl() time.Duration {
+	if c.TTLSeconds > 0 {
+		return time.Duration(c.TTLSeconds) * time.Second
+	}
+	return defaultLeaseTTL
+}
+
+// Leases holds the album leases taken by a run, or by a daemon across its
+// runs.
+type Leases struct {
+	db     *sql.DB
+	holder string
+	ttl    time.Duration
+
+	mu   sync.Mutex
+	held map[string]bool
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openLeases connects to Lychee's database and creates the lease table if
+// it doesn't exist.
+func openLeases(config *Config) (*Leases, error) {
+	if config.Database.Type == "api" {
+		return nil, fmt.Errorf("leases require direct database access")
+	}
+	holder := config.Lease.Holder
+	if holder == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error getting hostname for lease holder: %v", err)
+		}
+		holder = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return nil, err
+	}
+	// expires_at is a Unix time, which compares the same way in every
+	// database
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ` + leaseTable + ` (
+		album_id VARCHAR(191) PRIMARY KEY,
+		holder VARCHAR(255) NOT NULL,
+		expires_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error creating lease table: %v", err)
+	}
+	return &Leases{db: db, holder: holder, ttl: config.Lease.ttl(), held: make(map[string]bool)}, nil
+}
+
+// Acquire takes the leases on as many of the albums as it can, renewing
+// those it already holds, and returns the albums whose leases it holds. It
+// keeps all of its leases renewed until Close.
+func (l *Leases) Acquire(albumIDs []string) ([]string, error) {
+	var held []string
+	for _, albumID := range albumIDs {
+		holder, err := l.acquire(albumID)
+		if err != nil {
+			return nil, fmt.Errorf("error acquiring lease on album %s: %v", albumID, err)
+		}
+		if holder != l.holder {
+			slog.Info("Skipping album leased by another replica", "album_id", albumID, "holder", holder)
+			continue
+		}
+		held = append(held, albumID)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, albumID := range held {
+		l.held[albumID] = true
+	}
+	if l.stop == nil {
+		l.stop, l.done = make(chan struct{}), make(chan struct{})
+		go l.keepRenewed()
+	}
+	return held, nil
+}
+
+// heldAlbums returns the albums whose leases are held, in no particular
+// order.
+func (l *Leases) heldAlbums() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Collect(maps.Keys(l.held))
+}
+
+// acquire takes or renews the lease on an album if it's free, expired, or
+// already ours, and returns the lease's holder.
+func (l *Leases) acquire(albumID string) (string, error) {
+	now := time.Now()
+	result, err := l.db.Exec("UPDATE "+leaseTable+" SET holder = ?, expires_at = ? WHERE album_id = ? AND (holder = ? OR expires_at < ?)",
+		l.holder, now.Add(l.ttl).Unix(), albumID, l.holder, now.Unix())
+	if err != nil {
+		return "", err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		return l.holder, nil
+	}
+
+	_, insertErr := l.db.Exec("INSERT INTO "+leaseTable+" (album_id, holder, expires_at) VALUES (?, ?, ?)",
+		albumID, l.holder, now.Add(l.ttl).Unix())
+	if insertErr == nil {
+		return l.holder, nil
+	}
+	// Another replica holds the lease, unless the insert failed for some
+	// other reason
+	var holder string
+	if err := l.db.QueryRow("SELECT holder FROM "+leaseTable+" WHERE album_id = ?", albumID).Scan(&holder); err != nil {
+		return "", insertErr
+	}
+	return holder, nil
+}
+
+func (l *Leases) keepRenewed() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, albumID := range l.heldAlbums() {
+			result, err := l.db.Exec("UPDATE "+leaseTable+" SET expires_at = ? WHERE album_id = ? AND holder = ?",
+				time.Now().Add(l.ttl).Unix(), albumID, l.holder)
+			if err != nil {
+				slog.Error("Error renewing lease", "album_id", albumID, "error", err)
+			} else if n, err := result.RowsAffected(); err == nil && n == 0 {
+				// The next Acquire tries to take it back
+				slog.Warn("Lost lease; another replica may process the album too", "album_id", albumID)
+				l.mu.Lock()
+				delete(l.held, albumID)
+				l.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Close releases the leases so that any replica can take them on the next
+// run, and closes the database connection.
+func (l *Leases) Close() error {
+	l.mu.Lock()
+	stop, done := l.stop, l.done
+	l.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, albumID := range l.heldAlbums() {
+		if _, err := l.db.ExecContext(ctx, "DELETE FROM "+leaseTable+" WHERE album_id = ? AND holder = ?", albumID, l.holder); err != nil {
+			slog.Error("Error releasing lease", "album_id", albumID, "error", err)
+		}
+	}
+	return l.db.Close()
+}
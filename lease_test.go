@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLeasesTwoContenders(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "lychee.db")
+	open := func(holder string) *Leases {
+		config := &Config{Lease: LeaseConfig{Enabled: true, TTLSeconds: 1, Holder: holder}}
+		config.Database.Type, config.Database.Database = "sqlite", dsn
+		leases, err := openLeases(config)
+		if err != nil {
+			t.Fatalf("openLeases(%s): %v", holder, err)
+		}
+		return leases
+	}
+	acquire := func(l *Leases, albumIDs []string, want []string) {
+		t.Helper()
+		got, err := l.Acquire(albumIDs)
+		if err != nil {
+			t.Fatalf("%s: Acquire: %v", l.holder, err)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: Acquire(%q) = %q, want %q", l.holder, albumIDs, got, want)
+		}
+	}
+
+	daemon, other := open("daemon"), open("other")
+	defer other.Close()
+
+	acquire(daemon, []string{"a", "b"}, []string{"a", "b"})
+	acquire(other, []string{"a", "b", "c"}, []string{"c"})
+
+	// Between runs, the daemon keeps its leases renewed past their TTL
+	time.Sleep(1500 * time.Millisecond)
+	acquire(other, []string{"a", "b"}, nil)
+	acquire(daemon, []string{"a"}, []string{"a"})
+	acquire(daemon, []string{"c"}, nil)
+
+	// Once the daemon exits, the other replica takes over
+	if err := daemon.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	acquire(other, []string{"a", "b"}, []string{"a", "b"})
+}
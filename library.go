@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Library is the Lychee instance whose photos are processed, accessed either
+// directly through its database or through its HTTP API.
+type Library interface {
+	// Photos returns the photos in the given album (and its descendants, with
+	// include_sub_albums) taken within the filter's date range, as
+	// queryPhotos does.
+	Photos(albumID string, imageVariants, videoVariants []int) ([]Photo, error)
+	// Albums returns every album in the library.
+	Albums() ([]Album, error)
+	// PhotoAlbumID returns the ID of an album containing the photo, which is
+	// smartUnsorted if it isn't in any.
+	PhotoAlbumID(photoID string) (string, error)
+	// ExistingPhotos returns the set of the given photo IDs that are still
+	// in the library.
+	ExistingPhotos(photoIDs []string) (map[string]bool, error)
+	// UpdatePhoto applies the changes to a photo.
+	UpdatePhoto(photoID string, changes []fieldChange) error
+	// UpdatePhotos applies the updates to several photos, atomically if the
+	// library supports transactions, and returns how many were applied.
+	UpdatePhotos(updates []photoUpdate) (int, error)
+	// DeletePhoto removes a photo, found in the given album, from the
+	// library.
+	DeletePhoto(albumID, photoID string) error
+	// MovePhoto moves a photo from one album to another.
+	MovePhoto(photoID, fromAlbumID, toAlbumID string) error
+	Close() error
+}
+
+// Album is an album in the library.
+type Album struct {
+	ID    string
+	Title string
+}
+
+// openLibrary connects to the Lychee instance using the configured access
+// mode. With http_sink, photo updates are sent there instead.
+func openLibrary(config *Config, downloader *Downloader) (Library, error) {
+	dates, err := config.Filter.dateRange(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var library Library
+	if config.Database.Type == "api" {
+		api, err := newAPILibrary(config, downloader)
+		if err != nil {
+			return nil, err
+		}
+		api.dates = dates
+		library = api
+	} else {
+		db, err := openDatabase(config)
+		if err != nil {
+			return nil, err
+		}
+		sqlLib := sqlLibrary{db: db, schema: config.Database.Schema, recursive: config.IncludeSubAlbums, dates: dates, uploadsPath: config.UploadsPath}
+		// With an API token, photos are deleted through the API, which
+		// removes their files wherever Lychee stores them
+		if config.API.Token != "" && config.BaseURL != "" {
+			if sqlLib.api, err = newAPILibrary(config, downloader); err != nil {
+				_ = db.Close()
+				return nil, err
+			}
+		}
+		library = sqlLib
+	}
+
+	if config.HTTPSink.URL == "" {
+		return library, nil
+	}
+	sink, err := newHTTPSinkLibrary(library, config)
+	if err != nil {
+		_ = library.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+// sqlLibrary accesses Lychee's database directly.
+type sqlLibrary struct {
+	db        *sql.DB
+	schema    string
+	recursive bool
+	dates     dateRange
+	// uploadsPath is where deleted photos' files are removed from, unless
+	// api is set
+	uploadsPath string
+	api         *apiLibrary
+}
+
+func (l sqlLibrary) Photos(albumID string, imageVariants, videoVariants []int) ([]Photo, error) {
+	return queryPhotos(l.db, l.schema, albumID, l.recursive, l.dates, imageVariants, videoVariants)
+}
+
+func (l sqlLibrary) Albums() ([]Album, error) {
+	return queryAlbums(l.db, l.schema)
+}
+
+func (l sqlLibrary) PhotoAlbumID(photoID string) (string, error) {
+	return queryPhotoAlbumID(l.db, l.schema, photoID)
+}
+
+func (l sqlLibrary) ExistingPhotos(photoIDs []string) (map[string]bool, error) {
+	return queryExistingPhotos(l.db, photoIDs)
+}
+
+func (l sqlLibrary) UpdatePhoto(photoID string, changes []fieldChange) error {
+	return updatePhoto(l.db, photoID, changes)
+}
+
+func (l sqlLibrary) UpdatePhotos(updates []photoUpdate) (int, error) {
+	if err := updatePhotos(l.db, updates); err != nil {
+		return 0, err
+	}
+	return len(updates), nil
+}
+
+func (l sqlLibrary) DeletePhoto(albumID, photoID string) error {
+	if l.api != nil {
+		return l.api.DeletePhoto(albumID, photoID)
+	}
+	return deletePhoto(l.db, l.schema, photoID, l.uploadsPath)
+}
+
+func (l sqlLibrary) MovePhoto(photoID, fromAlbumID, toAlbumID string) error {
+	return movePhoto(l.db, l.schema, photoID, fromAlbumID, toAlbumID)
+}
+
+func (l sqlLibrary) Close() error {
+	return l.db.Close()
+}
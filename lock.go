@@ -0,0 +1,10 @@
+package main
+
+// lockPath returns the path of the lock file that keeps two instances from
+// using the same state file at once.
+func lockPath(config *Config) string {
+	if config.LockFile != "" {
+		return config.LockFile
+	}
+	return config.StateFile + ".lock"
+}
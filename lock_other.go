@@ -0,0 +1,81 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// acquireLock creates the file at path, failing if it already exists, and
+// writes the process ID to it. The lock is released (and the file removed)
+// when the returned function is called. A lock file left behind by a process
+// that died without releasing it is removed and the lock taken.
+func acquireLock(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if os.IsExist(err) && lockIsStale(path) {
+		slog.Warn("Removing stale lock file", "path", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error removing stale lock file: %v", err)
+		}
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	}
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("another instance is already running (remove %s if it isn't)", path)
+		}
+		return nil, fmt.Errorf("error creating lock file: %v", err)
+	}
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	file.Close()
+
+	return func() {
+		_ = os.Remove(path)
+	}, nil
+}
+
+// lockIsStale reports whether the lock file at path names a process that no
+// longer exists. A lock file without a process ID may have just been created,
+// so it isn't stale.
+func lockIsStale(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+	// On Windows, FindProcess fails if there's no such process
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return true
+	}
+	process.Release()
+	return false
+}
+
+// waitForLockTimeout is how long waitForLock waits for a lock file to be
+// removed before assuming it was left behind by a crash.
+const waitForLockTimeout = 30 * time.Second
+
+// waitForLock creates the file at path, waiting for it to be removed if it
+// already exists. The lock is released (and the file removed) when the
+// returned function is called.
+func waitForLock(path string) (func(), error) {
+	deadline := time.Now().Add(waitForLockTimeout)
+	for {
+		unlock, err := acquireLock(path)
+		if err == nil {
+			return unlock, nil
+		}
+		if _, statErr := os.Stat(path); statErr != nil || time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logBackupLayout names rotated log files. They're parsed with
+// logBackupParseLayout, since time.Parse accepts a fractional second the
+// layout doesn't have, and names from before milliseconds were added have
+// none.
+const (
+	logBackupLayout      = "20060102T150405.000Z"
+	logBackupParseLayout = "20060102T150405Z"
+)
+
+// rotatingFile is a log file that's rotated once it reaches maxSize bytes or
+// is older than maxAge. Rotated files are renamed with the time of the
+// rotation (app.log becomes app-20250102T150405.000Z.log), and only the
+// newest maxBackups are kept. A zero limit disables it.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time
+}
+
+// openRotatingFile opens the log file at path, appending to it.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	// The current file was started when the newest backup was rotated. If
+	// it's never been rotated, it was started when its first message was
+	// logged or, failing that, it's at least as old as its last change, so
+	// that a log written by short runs still ages out.
+	backups, err := f.backups()
+	if err == nil && len(backups) > 0 {
+		f.started = backups[len(backups)-1].rotated
+	} else if f.size > 0 {
+		if first, ok := firstLogTime(path); ok {
+			f.started = first
+		} else if info, err := os.Stat(path); err == nil {
+			f.started = info.ModTime()
+		}
+	}
+	return f, nil
+}
+
+// firstLogTime returns the time of the first message in a log file written
+// by slog's text or JSON handler.
+func firstLogTime(path string) (time.Time, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer file.Close()
+
+	line, _ := bufio.NewReader(io.LimitReader(file, 4096)).ReadString('\n')
+	for _, prefix := range []string{"time=", `{"time":"`} {
+		if rest, ok := strings.CutPrefix(line, prefix); ok {
+			value, _, _ := strings.Cut(rest, " ")
+			value, _, _ = strings.Cut(value, `"`)
+			if t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(value)); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	f.started = time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && ((f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize) || (f.maxAge > 0 && time.Since(f.started) > f.maxAge)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("error closing log file: %v", err)
+	}
+	// Two rotations in the same millisecond mustn't overwrite a backup
+	ext := filepath.Ext(f.path)
+	rotated := time.Now().UTC()
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), rotated.Format(logBackupLayout), ext)
+	for {
+		if _, err := os.Stat(backup); err != nil {
+			break
+		}
+		rotated = rotated.Add(time.Millisecond)
+		backup = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), rotated.Format(logBackupLayout), ext)
+	}
+	if err := os.Rename(f.path, backup); err != nil {
+		return fmt.Errorf("error rotating log file: %v", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.maxBackups > 0 {
+		backups, err := f.backups()
+		if err != nil {
+			return err
+		}
+		for len(backups) > f.maxBackups {
+			if err := os.Remove(backups[0].path); err != nil {
+				return fmt.Errorf("error removing old log file: %v", err)
+			}
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+type logBackup struct {
+	path    string
+	rotated time.Time
+}
+
+// backups returns the rotated log files, oldest first.
+func (f *rotatingFile) backups() ([]logBackup, error) {
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(f.path, ext) + "-"
+	matches, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return nil, fmt.Errorf("error listing old log files: %v", err)
+	}
+	var backups []logBackup
+	for _, path := range matches {
+		rotated, err := time.Parse(logBackupParseLayout, strings.TrimSuffix(strings.TrimPrefix(path, prefix), ext))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, logBackup{path: path, rotated: rotated})
+	}
+	slices.SortFunc(backups, func(a, b logBackup) int { return a.rotated.Compare(b.rotated) })
+	return backups, nil
+}
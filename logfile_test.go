@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxSize     int64
+		maxBackups  int
+		writes      int
+		wantBackups int
+	}{
+		{"under the limit", 100, 5, 5, 0},
+		{"rotates", 25, 5, 5, 2},
+		{"every write", 10, 0, 20, 19},
+		{"keeps the newest", 10, 3, 20, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			f, err := openRotatingFile(path, tt.maxSize, 0, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.file.Close()
+
+			// Written faster than once a millisecond, so backup names collide
+			for i := 0; i < tt.writes; i++ {
+				if _, err := f.Write([]byte("0123456789")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			backups, err := f.backups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != tt.wantBackups {
+				t.Errorf("got %d backups, want %d", len(backups), tt.wantBackups)
+			}
+			// Every write is kept somewhere, except in pruned backups
+			total := f.size
+			for _, backup := range backups {
+				info, err := os.Stat(backup.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				total += info.Size()
+			}
+			if tt.maxBackups == 0 && total != int64(tt.writes*10) {
+				t.Errorf("got %d bytes in all files, want %d", total, tt.writes*10)
+			}
+		})
+	}
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour).UTC()
+	recent := time.Now().Add(-10 * time.Minute).UTC()
+	tests := []struct {
+		name     string
+		contents string
+		modTime  time.Time // zero to leave it alone
+		want     bool      // whether the first write rotates the file
+	}{
+		{"new file", "", time.Time{}, false},
+		{"old text log", "time=" + old.Format(time.RFC3339Nano) + " level=INFO msg=Hello\n", time.Time{}, true},
+		{"recent text log", "time=" + recent.Format(time.RFC3339Nano) + " level=INFO msg=Hello\n", time.Time{}, false},
+		{"old JSON log", `{"time":"` + old.Format(time.RFC3339Nano) + `","level":"INFO","msg":"Hello"}` + "\n", time.Time{}, true},
+		{"old log without times", "Hello\n", old, true},
+		{"recent log without times", "Hello\n", recent, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			if tt.contents != "" {
+				if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !tt.modTime.IsZero() {
+				if err := os.Chtimes(path, tt.modTime, tt.modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			f, err := openRotatingFile(path, 0, time.Hour, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.file.Close()
+			if _, err := f.Write([]byte("time=now\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			backups, err := f.backups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(backups) > 0; got != tt.want {
+				t.Errorf("rotated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotatingFileBackupNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	names := []string{
+		"app-20250102T150405Z.log",     // before milliseconds were added
+		"app-20250102T150406.500Z.log", // current
+		"app-20250101T000000.000Z.log",
+		"app-notes.log", // not a backup
+		"other-20250102T150405Z.log",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := (&rotatingFile{path: path}).backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, backup := range backups {
+		got = append(got, filepath.Base(backup.path))
+	}
+	want := "app-20250101T000000.000Z.log app-20250102T150405Z.log app-20250102T150406.500Z.log"
+	if strings.Join(got, " ") != want {
+		t.Errorf("backups = %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Values of the -log-format flag.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logOutput is a destination for log messages at or above level (debug,
+// info, warn, or error).
+type logOutput struct {
+	w     io.Writer
+	level string
+}
+
+// setupLogging sends log messages to each output through a slog handler in
+// the given format (text or json). Messages still written with the log
+// package, the fatal errors in main, are logged at error level.
+func setupLogging(format string, outputs ...logOutput) error {
+	var handlers fanoutHandler
+	for _, output := range outputs {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(output.level)); err != nil {
+			return fmt.Errorf("unknown log level %q (expected debug, info, warn, or error)", output.level)
+		}
+		opts := &slog.HandlerOptions{Level: level}
+
+		switch strings.ToLower(format) {
+		case logFormatText:
+			handlers = append(handlers, slog.NewTextHandler(output.w, opts))
+		case logFormatJSON:
+			handlers = append(handlers, slog.NewJSONHandler(output.w, opts))
+		default:
+			return fmt.Errorf("unknown log format %q (expected text or json)", format)
+		}
+	}
+
+	var handler slog.Handler = handlers
+	if len(handlers) == 1 {
+		handler = handlers[0]
+	}
+	slog.SetDefault(slog.New(handler))
+	slog.SetLogLoggerLevel(slog.LevelError)
+	return nil
+}
+
+// fanoutHandler passes each record on to every handler that logs its level.
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h {
+		if handler.Enabled(ctx, r.Level) {
+			errs = append(errs, handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
@@ -0,0 +1,41 @@
+package main
+
+import "log/slog"
+
+// lowResourceSizeVariants are the size variants preferred in low-resource
+// mode: large enough for legible overlay text, but far smaller to download
+// and decode than the default medium2x.
+var lowResourceSizeVariants = []string{"small2x", "medium", "small"}
+
+// lowResourceMaxPixels limits decoded image size in low-resource mode.
+const lowResourceMaxPixels = 16_000_000
+
+// applyLowResource adjusts the config for devices with little memory and
+// CPU, such as a Raspberry Pi Zero, when low_resource is set. Settings given
+// explicitly in the config file are left alone, except that the download
+// cache is always disabled.
+func (c *Config) applyLowResource() {
+	if !c.LowResource {
+		return
+	}
+
+	if len(c.SizeVariants) == 0 {
+		c.SizeVariants = lowResourceSizeVariants
+	}
+	if c.Image.MaxPixels == 0 {
+		c.Image.MaxPixels = lowResourceMaxPixels
+	}
+	if c.SkipVideos == nil {
+		skip := true
+		c.SkipVideos = &skip
+	}
+	if c.Download.CacheDir != "" {
+		slog.Info("Low-resource mode: ignoring download.cache_dir")
+		c.Download.CacheDir = ""
+	}
+}
+
+// skipVideos reports whether videos should be skipped entirely.
+func (c *Config) skipVideos() bool {
+	return c.SkipVideos != nil && *c.SkipVideos
+}
@@ -0,0 +1,651 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Lychee's size_variants.type values.
+var sizeVariantTypes = map[string]int{
+	"original": 0,
+	"medium2x": 1,
+	"medium":   2,
+	"small2x":  3,
+	"small":    4,
+	"thumb2x":  5,
+	"thumb":    6,
+}
+
+// schemaV4 is the Lychee 4 database schema, in which each photo belongs to a
+// single album recorded in photos.album_id. Lychee 5 and later record album
+// membership in the photo_album table.
+const schemaV4 = "v4"
+
+// checkSchema returns an error if schema isn't a supported database.schema
+// value.
+func checkSchema(schema string) error {
+	switch schema {
+	case "", schemaV4, "v5":
+		return nil
+	default:
+		return fmt.Errorf("unsupported database schema: %s", schema)
+	}
+}
+
+// Lychee's smart albums, which are selected by a condition rather than by
+// membership and can be processed like regular albums.
+const (
+	smartUnsorted = "unsorted" // photos not in any album
+	smartStarred  = "starred"
+	smartRecent   = "recent" // photos uploaded within recentAge
+)
+
+// recentAge is how recently a photo must have been uploaded to be in the
+// recent smart album; this is Lychee's default.
+const recentAge = 24 * time.Hour
+
+func isSmartAlbum(albumID string) bool {
+	return albumID == smartUnsorted || albumID == smartStarred || albumID == smartRecent
+}
+
+// smartAlbumCondition returns the WHERE condition, and its arguments, for the
+// photos in a smart album.
+func smartAlbumCondition(schema, albumID string) (string, []any) {
+	switch albumID {
+	case smartUnsorted:
+		if schema == schemaV4 {
+			return "p.album_id IS NULL", nil
+		}
+		return "NOT EXISTS (SELECT 1 FROM photo_album pa WHERE pa.photo_id = p.id)", nil
+	case smartStarred:
+		return "p.is_starred = ?", []any{true}
+	default:
+		return "p.created_at >= ?", []any{time.Now().Add(-recentAge).UTC()}
+	}
+}
+
+var (
+	defaultSizeVariants = []string{"medium2x"}
+
+	// Lychee generates still-image variants from a frame of each video, so
+	// prefer those over downloading the whole video and extracting a frame.
+	defaultVideoSizeVariants = []string{"small2x", "small", "original"}
+)
+
+// sizeVariantPreference returns the size variant types with the given names,
+// most preferred first, or those in defaults if names is empty.
+func sizeVariantPreference(names []string, defaults []string) ([]int, error) {
+	if len(names) == 0 {
+		names = defaults
+	}
+
+	types := make([]int, 0, len(names))
+	for _, name := range names {
+		t, ok := sizeVariantTypes[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown size variant: %s", name)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// variantRanks maps each size variant type in a preference list to its
+// position in the list.
+func variantRanks(variantTypes []int) map[int]int {
+	ranks := make(map[int]int, len(variantTypes))
+	for i, t := range variantTypes {
+		if _, ok := ranks[t]; !ok {
+			ranks[t] = i
+		}
+	}
+	return ranks
+}
+
+// queryPhotos returns the photos in the given album and, if recursive is set,
+// all of its descendant albums; smart albums have no descendants. Only photos
+// taken (or, if that isn't known, uploaded) within dates are returned. For each photo, the most-preferred available
+// size variant is selected, using videoVariants for videos and imageVariants
+// for everything else; photos with none of their preferred variants are
+// omitted.
+func queryPhotos(db *sql.DB, schema string, albumID string, recursive bool, dates dateRange, imageVariants, videoVariants []int) ([]Photo, error) {
+	imageRanks := variantRanks(imageVariants)
+	videoRanks := variantRanks(videoVariants)
+
+	var variants []any
+	for _, ranks := range []map[int]int{imageRanks, videoRanks} {
+		for t := range ranks {
+			variants = append(variants, t)
+		}
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(variants)), ", ")
+	// Newer versions of Lychee keep tags in their own table
+	tagPivot := tagPivotTable(db)
+	tagsColumn := "COALESCE(p.tags, '')"
+	if tagPivot != "" {
+		tagsColumn = "''"
+	}
+	membership, albumColumn := "JOIN photo_album pa on p.id = pa.photo_id", "pa.album_id"
+	if schema == schemaV4 {
+		membership, albumColumn = "", "p.album_id"
+	}
+	albumMatch, args := albumColumn+" = ?", []any{albumID}
+	if isSmartAlbum(albumID) {
+		// A photo in a smart album may be in any number of regular albums
+		membership, albumColumn = "", "?"
+		albumMatch, args = smartAlbumCondition(schema, albumID)
+		args = append([]any{albumID}, args...)
+	} else if recursive {
+		// Lychee stores the album tree as a nested set, so an album's
+		// descendants are the albums within its _lft/_rgt bounds
+		albumMatch = albumColumn + ` IN (
+			SELECT a.id FROM albums a JOIN albums parent ON a._lft BETWEEN parent._lft AND parent._rgt
+			WHERE parent.id = ?
+		)`
+	}
+	if !dates.Since.IsZero() {
+		albumMatch += " AND COALESCE(p.taken_at, p.created_at) >= ?"
+		args = append(args, dates.Since.UTC())
+	}
+	if !dates.Until.IsZero() {
+		albumMatch += " AND COALESCE(p.taken_at, p.created_at) < ?"
+		args = append(args, dates.Until.UTC())
+	}
+	query := `
+		SELECT p.id, p.title, COALESCE(p.description, ''), p.type, COALESCE(p.taken_at, p.created_at), COALESCE(p.checksum, ''),
+			p.is_starred, ` + tagsColumn + `, COALESCE(p.live_photo_content_id, ''),
+			sv.type, sv.short_path, COALESCE(sv.filesize, 0), COALESCE(sv.width, 0), COALESCE(sv.height, 0),
+			COALESCE(orig.short_path, ''), ` + albumColumn + `
+		FROM photos p
+		JOIN size_variants sv ON p.id = sv.photo_id
+		` + membership + `
+		LEFT JOIN size_variants orig ON p.id = orig.photo_id AND orig.type = 0
+		WHERE ` + albumMatch + ` AND sv.type IN (` + placeholders + `)
+		ORDER BY COALESCE(p.taken_at, p.created_at), p.id
+	`
+
+	rows, err := db.Query(query, append(args, variants...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying photos: %v", err)
+	}
+	defer rows.Close()
+
+	var photos []Photo
+	index := make(map[string]int)
+	for rows.Next() {
+		var photo Photo
+		var takenAt dbTime
+		var tags string
+		if err := rows.Scan(&photo.ID, &photo.Title, &photo.Description, &photo.Type, &takenAt, &photo.Checksum,
+			&photo.Starred, &tags, &photo.LivePhotoContentID,
+			&photo.SizeVariant, &photo.ShortPath, &photo.Filesize, &photo.Width, &photo.Height,
+			&photo.OriginalShortPath, &photo.AlbumID); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		photo.TakenAt = takenAt.Time
+		photo.Tags = parseTags(tags)
+
+		rank := imageRanks
+		if photo.IsVideo() {
+			rank = videoRanks
+		}
+		if _, ok := rank[photo.SizeVariant]; !ok {
+			continue
+		}
+
+		i, seen := index[photo.ID]
+		if !seen {
+			index[photo.ID] = len(photos)
+			photos = append(photos, photo)
+		} else if rank[photo.SizeVariant] < rank[photos[i].SizeVariant] {
+			photos[i] = photo
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	if tagPivot != "" {
+		if err := queryPhotoTags(db, tagPivot, photos); err != nil {
+			return nil, err
+		}
+	}
+	return photos, nil
+}
+
+// tagPivotTables are the names of the table linking photos to Lychee's tags
+// table, which replaced the photos.tags column.
+var tagPivotTables = []string{"photos_tags", "tags_photos"}
+
+// tagPivotTable returns the table linking photos to Lychee's tags table, or
+// "" if tags are stored in the photos.tags column. It must not be called
+// within a transaction, since PostgreSQL aborts a transaction after a query
+// on a missing table.
+func tagPivotTable(db *sql.DB) string {
+	if !tableExists(db, "tags") {
+		return ""
+	}
+	for _, table := range tagPivotTables {
+		if tableExists(db, table) {
+			return table
+		}
+	}
+	return ""
+}
+
+func tableExists(db *sql.DB, table string) bool {
+	rows, err := db.Query("SELECT 1 FROM " + table + " WHERE 1 = 0")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// queryPhotoTags fills in the photos' tags from Lychee's tags table.
+func queryPhotoTags(db *sql.DB, tagPivot string, photos []Photo) error {
+	index := make(map[string]int, len(photos))
+	ids := make([]string, len(photos))
+	for i, photo := range photos {
+		index[photo.ID] = i
+		ids[i] = photo.ID
+	}
+	for batch := range slices.Chunk(ids, existingPhotosBatch) {
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := db.Query("SELECT pt.photo_id, t.name FROM "+tagPivot+" pt JOIN tags t ON t.id = pt.tag_id"+
+			" WHERE pt.photo_id IN ("+placeholders+") ORDER BY t.name", args...)
+		if err != nil {
+			return fmt.Errorf("error querying tags: %v", err)
+		}
+		for rows.Next() {
+			var photoID, name string
+			if err := rows.Scan(&photoID, &name); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning tag: %v", err)
+			}
+			if i, ok := index[photoID]; ok {
+				photos[i].Tags = append(photos[i].Tags, name)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("error querying tags: %v", err)
+		}
+	}
+	return nil
+}
+
+// setPhotoTags replaces a photo's tags in Lychee's tags table, adding tags
+// that don't exist yet.
+func setPhotoTags(tx *sql.Tx, tagPivot, photoID string, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM "+tagPivot+" WHERE photo_id = ?", photoID); err != nil {
+		return fmt.Errorf("error removing tags: %v", err)
+	}
+	for _, tag := range tags {
+		// Looked up again after inserting, since not every driver reports
+		// the ID of an inserted row
+		var tagID int64
+		err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", tag).Scan(&tagID)
+		if errors.Is(err, sql.ErrNoRows) {
+			if _, err := tx.Exec("INSERT INTO tags (name) VALUES (?)", tag); err != nil {
+				return fmt.Errorf("error adding tag %q: %v", tag, err)
+			}
+			err = tx.QueryRow("SELECT id FROM tags WHERE name = ?", tag).Scan(&tagID)
+		}
+		if err != nil {
+			return fmt.Errorf("error looking up tag %q: %v", tag, err)
+		}
+		if _, err := tx.Exec("INSERT INTO "+tagPivot+" (photo_id, tag_id) VALUES (?, ?)", photoID, tagID); err != nil {
+			return fmt.Errorf("error tagging photo: %v", err)
+		}
+	}
+	return nil
+}
+
+// dbTime scans a timestamp that may be NULL or, from SQLite, text. SQLite
+// returns the result of an expression such as COALESCE as text because the
+// driver can't tell that it's a timestamp.
+type dbTime struct {
+	time.Time
+}
+
+func (t *dbTime) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		value = string(v)
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("unsupported timestamp type %T", value)
+	}
+	s = strings.TrimSuffix(s, "Z")
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+// queryAlbums returns all of Lychee's albums, excluding smart and tag albums.
+// Lychee 5 and later keep album titles in the base_albums table, which is
+// shared with tag albums.
+func queryAlbums(db *sql.DB, schema string) ([]Album, error) {
+	query := "SELECT a.id, b.title FROM albums a JOIN base_albums b ON b.id = a.id ORDER BY a._lft, a.id"
+	if schema == schemaV4 {
+		query = "SELECT id, title FROM albums ORDER BY _lft, id"
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying albums: %v", err)
+	}
+	defer rows.Close()
+
+	var albums []Album
+	for rows.Next() {
+		var album Album
+		var title sql.NullString
+		if err := rows.Scan(&album.ID, &title); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		album.Title = title.String
+		albums = append(albums, album)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return albums, nil
+}
+
+// queryPhotoAlbumID returns the ID of an album containing the photo (the
+// first by ID, if there are several), or smartUnsorted if it isn't in one.
+func queryPhotoAlbumID(db *sql.DB, schema string, photoID string) (string, error) {
+	var albumID sql.NullString
+	var err error
+	if schema == schemaV4 {
+		err = db.QueryRow("SELECT album_id FROM photos WHERE id = ?", photoID).Scan(&albumID)
+	} else {
+		err = db.QueryRow("SELECT MIN(pa.album_id) FROM photos p LEFT JOIN photo_album pa ON pa.photo_id = p.id WHERE p.id = ? GROUP BY p.id", photoID).Scan(&albumID)
+	}
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("photo %s not found", photoID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error querying photo: %v", err)
+	}
+	if !albumID.Valid || albumID.String == "" {
+		return smartUnsorted, nil
+	}
+	return albumID.String, nil
+}
+
+// existingPhotosBatch is how many photo IDs queryExistingPhotos looks up in
+// each query, keeping it under databases' limits on query parameters.
+const existingPhotosBatch = 500
+
+// queryExistingPhotos returns the set of the given photo IDs that are in the
+// photos table.
+func queryExistingPhotos(db *sql.DB, photoIDs []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	for batch := range slices.Chunk(photoIDs, existingPhotosBatch) {
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := db.Query("SELECT id FROM photos WHERE id IN ("+placeholders+")", args...)
+		if err != nil {
+			return nil, fmt.Errorf("error querying photos: %v", err)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning photo: %v", err)
+			}
+			existing[id] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error querying photos: %v", err)
+		}
+	}
+	return existing, nil
+}
+
+// fieldChange is a change to one column of a photo.
+type fieldChange struct {
+	Field    string // the photos table column
+	OldValue string
+	NewValue string
+}
+
+// updatePhoto applies all of the changes to a photo in a single transaction,
+// so that a failure can't leave the photo partially updated.
+func updatePhoto(db *sql.DB, photoID string, changes []fieldChange) error {
+	return updatePhotos(db, []photoUpdate{{PhotoID: photoID, Changes: changes}})
+}
+
+// photoUpdate is a set of changes to one photo.
+type photoUpdate struct {
+	PhotoID string
+	Changes []fieldChange
+	// Detection is the identification that gave the photo its title, if
+	// any, for libraries that pass it on.
+	Detection *DetectionEvent
+}
+
+// updatePhotos applies the updates to several photos in a single
+// transaction, so that either all of them are made or none are.
+func updatePhotos(db *sql.DB, updates []photoUpdate) error {
+	tagPivot := ""
+	for _, update := range updates {
+		if slices.ContainsFunc(update.Changes, func(c fieldChange) bool { return c.Field == "tags" }) {
+			tagPivot = tagPivotTable(db)
+			break
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, update := range updates {
+		if len(update.Changes) == 0 {
+			continue
+		}
+		sets := make([]string, 0, len(update.Changes))
+		args := make([]any, 0, len(update.Changes)+1)
+		for _, change := range update.Changes {
+			if change.Field == "tags" && tagPivot != "" {
+				if err := setPhotoTags(tx, tagPivot, update.PhotoID, parseTags(change.NewValue)); err != nil {
+					return fmt.Errorf("error updating photo %s: %v", update.PhotoID, err)
+				}
+				continue
+			}
+			sets = append(sets, change.Field+" = ?")
+			args = append(args, change.NewValue)
+		}
+		if len(sets) == 0 {
+			continue
+		}
+		args = append(args, update.PhotoID)
+
+		if _, err := tx.Exec("UPDATE photos SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+			if len(updates) > 1 {
+				return fmt.Errorf("error updating photo %s: %v", update.PhotoID, err)
+			}
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// pairLivePhotos finds live photos whose still image and video were stored as
+// separate photos, linked by their live photo content ID. It returns the
+// videos that need a title keyed by the ID of their still, and the set of IDs
+// of all videos that have a still.
+func pairLivePhotos(photos []Photo, needsTitle func(string) bool) (map[string][]Photo, map[string]bool) {
+	stills := make(map[string]Photo)
+	for _, photo := range photos {
+		if photo.LivePhotoContentID != "" && !photo.IsVideo() {
+			stills[photo.LivePhotoContentID] = photo
+		}
+	}
+
+	partners := make(map[string][]Photo)
+	paired := make(map[string]bool)
+	for _, photo := range photos {
+		if photo.LivePhotoContentID == "" || !photo.IsVideo() {
+			continue
+		}
+		still, ok := stills[photo.LivePhotoContentID]
+		if !ok {
+			continue
+		}
+		paired[photo.ID] = true
+		if needsTitle(photo.Title) {
+			partners[still.ID] = append(partners[still.ID], photo)
+		}
+	}
+	return partners, paired
+}
+
+// deletePhoto removes a photo, its size variants, and its album memberships
+// from the database in a single transaction, then deletes the size variants'
+// files from uploadsPath. Files still used by another photo (such as a
+// duplicate Lychee made without copying them) are left alone.
+func deletePhoto(db *sql.DB, schema string, photoID string, uploadsPath string) error {
+	if uploadsPath == "" {
+		return fmt.Errorf("deleting photos with direct database access requires uploads_path (or api.token, to delete through the API) so their files can be deleted too")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.Query("SELECT short_path FROM size_variants WHERE photo_id = ?", photoID)
+	if err != nil {
+		return fmt.Errorf("error querying size variants: %v", err)
+	}
+	var paths []string
+	for rows.Next() {
+		var path sql.NullString
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning size variant: %v", err)
+		}
+		if path.String != "" {
+			paths = append(paths, path.String)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error querying size variants: %v", err)
+	}
+
+	queries := []string{
+		"DELETE FROM size_variants WHERE photo_id = ?",
+		"DELETE FROM photos WHERE id = ?",
+	}
+	if schema != schemaV4 {
+		queries = append([]string{"DELETE FROM photo_album WHERE photo_id = ?"}, queries...)
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, photoID); err != nil {
+			return err
+		}
+	}
+
+	var orphaned []string
+	for _, path := range paths {
+		var shared bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM size_variants WHERE short_path = ?)", path).Scan(&shared); err != nil {
+			return fmt.Errorf("error checking for shared files: %v", err)
+		}
+		if !shared {
+			orphaned = append(orphaned, path)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	// The photo is gone from Lychee either way, so a file that can't be
+	// removed is only worth a warning
+	for _, path := range orphaned {
+		file := filepath.Join(uploadsPath, filepath.FromSlash(strings.TrimLeft(path, "/")))
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Error deleting photo file", "photo_id", photoID, "path", file, "error", err)
+		}
+	}
+	return nil
+}
+
+// movePhoto moves a photo from one album to another. Before Lychee 5 a photo
+// belonged to a single album, recorded on the photo itself. A photo found
+// through a smart album is added to the destination album, leaving any other
+// albums it's in alone. A photo that's already in the destination album just
+// leaves the source album.
+func movePhoto(db *sql.DB, schema string, photoID, fromAlbumID, toAlbumID string) error {
+	if schema == schemaV4 {
+		_, err := db.Exec("UPDATE photos SET album_id = ? WHERE id = ?", toAlbumID, photoID)
+		return err
+	}
+
+	// photo_album's primary key is (album_id, photo_id), so replace the
+	// memberships rather than updating one into a duplicate
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	albumIDs := []any{toAlbumID}
+	if !isSmartAlbum(fromAlbumID) {
+		albumIDs = append(albumIDs, fromAlbumID)
+	}
+	for _, albumID := range albumIDs {
+		if _, err := tx.Exec("DELETE FROM photo_album WHERE photo_id = ? AND album_id = ?", photoID, albumID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO photo_album (album_id, photo_id) VALUES (?, ?)", toAlbumID, photoID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
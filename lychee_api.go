@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIConfig holds the settings for accessing Lychee through its HTTP API
+// (database type "api").
+type APIConfig struct {
+	// Token is a Lychee user's API token. The user needs permission to edit
+	// (and, for the delete no-text action, delete) photos in the album.
+	Token string `json:"token"`
+	// TimeoutSeconds limits each API request (default 30).
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+const defaultAPITimeout = 30 * time.Second
+
+// apiLibrary accesses Lychee through the v2 HTTP API served by Lychee 6, so
+// the program doesn't depend on Lychee's database schema or credentials.
+type apiLibrary struct {
+	client    *http.Client
+	baseURL   string
+	token     string
+	timeout   time.Duration
+	recursive bool
+	// dates is applied to the photos returned, since the API can't filter
+	// them by date
+	dates dateRange
+}
+
+func newAPILibrary(config *Config, downloader *Downloader) (*apiLibrary, error) {
+	if config.BaseURL == "" {
+		return nil, fmt.Errorf("base_url is required when database type is api")
+	}
+	if config.API.Token == "" {
+		return nil, fmt.Errorf("api.token is required when database type is api")
+	}
+
+	timeout := defaultAPITimeout
+	if config.API.TimeoutSeconds > 0 {
+		timeout = time.Duration(config.API.TimeoutSeconds) * time.Second
+	}
+	// Requests go through the downloader's client so that they use the same
+	// proxy, TLS, and access settings as downloads
+	return &apiLibrary{
+		client:    downloader.client,
+		baseURL:   strings.TrimRight(config.BaseURL, "/"),
+		token:     config.API.Token,
+		timeout:   timeout,
+		recursive: config.IncludeSubAlbums,
+	}, nil
+}
+
+// apiPhoto is a photo as returned by the API.
+type apiPhoto struct {
+	ID                 string                     `json:"id"`
+	Title              string                     `json:"title"`
+	Description        string                     `json:"description"`
+	Type               string                     `json:"type"`
+	TakenAt            *time.Time                 `json:"taken_at"`
+	CreatedAt          time.Time                  `json:"created_at"`
+	Checksum           string                     `json:"checksum"`
+	IsStarred          bool                       `json:"is_starred"`
+	Tags               []string                   `json:"tags"`
+	LivePhotoContentID string                     `json:"live_photo_content_id"`
+	SizeVariants       map[string]*apiSizeVariant `json:"size_variants"`
+
+	// AlbumID is the album the photo was found in.
+	AlbumID string `json:"-"`
+}
+
+type apiSizeVariant struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Filesize is a number of bytes in some Lychee versions and a formatted
+	// string (such as "1.2 MB") in others; only the former is used.
+	Filesize json.RawMessage `json:"filesize"`
+}
+
+func (v *apiSizeVariant) filesize() int64 {
+	n, err := strconv.ParseInt(string(v.Filesize), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// uploadsShortPath returns the path of a size variant relative to Lychee's
+// uploads directory, given its URL.
+func uploadsShortPath(variantURL string) (string, bool) {
+	if u, err := url.Parse(variantURL); err == nil {
+		variantURL = u.Path
+	}
+	_, shortPath, ok := strings.Cut(variantURL, "uploads/")
+	return shortPath, ok
+}
+
+// albumPhotos appends the photos in the album, and in its descendants if
+// include_sub_albums is set, to found.
+func (l *apiLibrary) albumPhotos(albumID string, found []apiPhoto) ([]apiPhoto, error) {
+	var resp struct {
+		Resource struct {
+			Albums []struct {
+				ID string `json:"id"`
+			} `json:"albums"`
+			Photos []apiPhoto `json:"photos"`
+		} `json:"resource"`
+	}
+	query := url.Values{"album_id": {albumID}}
+	if err := l.call(http.MethodGet, "/api/v2/Album?"+query.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	for _, p := range resp.Resource.Photos {
+		p.AlbumID = albumID
+		found = append(found, p)
+	}
+	if l.recursive {
+		var err error
+		for _, child := range resp.Resource.Albums {
+			if found, err = l.albumPhotos(child.ID, found); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return found, nil
+}
+
+// Albums returns the albums the API user can see, including
+// shared and nested albums but not smart or tag albums.
+func (l *apiLibrary) Albums() ([]Album, error) {
+	type album struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	}
+	var root struct {
+		Albums       []album `json:"albums"`
+		SharedAlbums []album `json:"shared_albums"`
+	}
+	if err := l.call(http.MethodGet, "/api/v2/Albums", nil, &root); err != nil {
+		return nil, err
+	}
+
+	var albums []Album
+	queue := append(root.Albums, root.SharedAlbums...)
+	for len(queue) > 0 {
+		a := queue[0]
+		queue = queue[1:]
+		albums = append(albums, Album{ID: a.ID, Title: a.Title})
+
+		var resp struct {
+			Resource struct {
+				Albums []album `json:"albums"`
+			} `json:"resource"`
+		}
+		query := url.Values{"album_id": {a.ID}}
+		if err := l.call(http.MethodGet, "/api/v2/Album?"+query.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+		queue = append(queue, resp.Resource.Albums...)
+	}
+	return albums, nil
+}
+
+func (l *apiLibrary) Photos(albumID string, imageVariants, videoVariants []int) ([]Photo, error) {
+	found, err := l.albumPhotos(albumID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	imageRanks := variantRanks(imageVariants)
+	videoRanks := variantRanks(videoVariants)
+
+	photos := make([]Photo, 0, len(found))
+	seen := make(map[string]bool, len(found))
+	for _, p := range found {
+		if seen[p.ID] {
+			continue
+		}
+		seen[p.ID] = true
+		photo := Photo{
+			ID:                 p.ID,
+			Title:              p.Title,
+			Description:        p.Description,
+			Type:               p.Type,
+			TakenAt:            p.CreatedAt,
+			Checksum:           p.Checksum,
+			Starred:            p.IsStarred,
+			Tags:               p.Tags,
+			LivePhotoContentID: p.LivePhotoContentID,
+			AlbumID:            p.AlbumID,
+		}
+		if p.TakenAt != nil {
+			photo.TakenAt = *p.TakenAt
+		}
+		if !l.dates.contains(photo.TakenAt) {
+			continue
+		}
+		if original := p.SizeVariants["original"]; original != nil {
+			photo.OriginalShortPath, _ = uploadsShortPath(original.URL)
+		}
+
+		ranks := imageRanks
+		if photo.IsVideo() {
+			ranks = videoRanks
+		}
+		best := -1
+		for name, variant := range p.SizeVariants {
+			t, known := sizeVariantTypes[name]
+			rank, wanted := ranks[t]
+			if variant == nil || !known || !wanted || (best >= 0 && rank >= ranks[best]) {
+				continue
+			}
+			shortPath, ok := uploadsShortPath(variant.URL)
+			if !ok {
+				slog.Debug("Skipping size variant outside the uploads directory", "photo_id", p.ID, "variant", name, "url", variant.URL)
+				continue
+			}
+			best = t
+			photo.SizeVariant = t
+			photo.ShortPath = shortPath
+			photo.Filesize = variant.filesize()
+			photo.Width = variant.Width
+			photo.Height = variant.Height
+		}
+		if best >= 0 {
+			photos = append(photos, photo)
+		}
+	}
+
+	// Match queryPhotos' ordering
+	sort.SliceStable(photos, func(i, j int) bool {
+		if !photos[i].TakenAt.Equal(photos[j].TakenAt) {
+			return photos[i].TakenAt.Before(photos[j].TakenAt)
+		}
+		return photos[i].ID < photos[j].ID
+	})
+	return photos, nil
+}
+
+func (l *apiLibrary) PhotoAlbumID(photoID string) (string, error) {
+	var resp struct {
+		AlbumID *string `json:"album_id"`
+	}
+	query := url.Values{"photo_id": {photoID}}
+	if err := l.call(http.MethodGet, "/api/v2/Photo?"+query.Encode(), nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.AlbumID == nil || *resp.AlbumID == "" {
+		return smartUnsorted, nil
+	}
+	return *resp.AlbumID, nil
+}
+
+// UpdatePhotos updates each photo in turn, stopping at the first failure;
+// the API has no transactions, so the earlier updates remain.
+func (l *apiLibrary) UpdatePhotos(updates []photoUpdate) (int, error) {
+	for i, update := range updates {
+		if err := l.UpdatePhoto(update.PhotoID, update.Changes); err != nil {
+			return i, fmt.Errorf("error updating photo %s: %v", update.PhotoID, err)
+		}
+	}
+	return len(updates), nil
+}
+
+// ExistingPhotos looks each photo up with a separate request, since the API
+// has no way to look up several at once.
+func (l *apiLibrary) ExistingPhotos(photoIDs []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	for _, photoID := range photoIDs {
+		query := url.Values{"photo_id": {photoID}}
+		err := l.call(http.MethodGet, "/api/v2/Photo?"+query.Encode(), nil, nil)
+		var statusErr *apiStatusError
+		switch {
+		case err == nil:
+			existing[photoID] = true
+		case errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound:
+		default:
+			return nil, fmt.Errorf("error looking up photo %s: %v", photoID, err)
+		}
+	}
+	return existing, nil
+}
+
+// UpdatePhoto applies each change with a separate request; unlike the
+// database, the API can't update several fields atomically.
+func (l *apiLibrary) UpdatePhoto(photoID string, changes []fieldChange) error {
+	for _, change := range changes {
+		var err error
+		switch change.Field {
+		case "title":
+			err = l.call(http.MethodPatch, "/api/v2/Photo::rename", map[string]any{
+				"photo_id": photoID,
+				"title":    change.NewValue,
+			}, nil)
+		case "tags":
+			err = l.call(http.MethodPatch, "/api/v2/Photo::tags", map[string]any{
+				"photo_ids":      []string{photoID},
+				"tags":           parseTags(change.NewValue),
+				"shall_override": true,
+			}, nil)
+		case "description", "taken_at":
+			err = l.editPhoto(photoID, change.Field, change.NewValue)
+		default:
+			err = fmt.Errorf("updating %s is not supported through the API", change.Field)
+		}
+		if err != nil {
+			return fmt.Errorf("error updating %s: %v", change.Field, err)
+		}
+	}
+	return nil
+}
+
+// editPhoto changes a photo's description or taken_at. The API only changes
+// them along with the photo's other editable fields, so those are read first
+// and sent back unchanged.
+func (l *apiLibrary) editPhoto(photoID, field, value string) error {
+	var photo struct {
+		Title       string     `json:"title"`
+		Description string     `json:"description"`
+		Tags        []string   `json:"tags"`
+		License     string     `json:"license"`
+		CreatedAt   time.Time  `json:"created_at"`
+		TakenAt     *time.Time `json:"taken_at"`
+	}
+	query := url.Values{"photo_id": {photoID}}
+	if err := l.call(http.MethodGet, "/api/v2/Photo?"+query.Encode(), nil, &photo); err != nil {
+		return fmt.Errorf("error reading photo: %v", err)
+	}
+	edit := map[string]any{
+		"photo_id":    photoID,
+		"title":       photo.Title,
+		"description": photo.Description,
+		"tags":        photo.Tags,
+		"license":     photo.License,
+		"upload_date": photo.CreatedAt,
+		"taken_at":    photo.TakenAt,
+	}
+	if field == "taken_at" {
+		takenAt, err := time.ParseInLocation(overlayTimestampLayout, value, time.UTC)
+		if err != nil {
+			return err
+		}
+		edit[field] = takenAt
+	} else {
+		edit[field] = value
+	}
+	return l.call(http.MethodPatch, "/api/v2/Photo", edit, nil)
+}
+
+// DeletePhoto deletes a photo through the API, which also removes its files.
+func (l *apiLibrary) DeletePhoto(albumID, photoID string) error {
+	return l.call(http.MethodDelete, "/api/v2/Photo", map[string]any{
+		"photo_ids": []string{photoID},
+		"from_id":   albumID,
+	}, nil)
+}
+
+// MovePhoto moves a photo between albums through the API.
+func (l *apiLibrary) MovePhoto(photoID, fromAlbumID, toAlbumID string) error {
+	body := map[string]any{
+		"photo_ids": []string{photoID},
+		"album_id":  toAlbumID,
+	}
+	if !isSmartAlbum(fromAlbumID) {
+		body["from_id"] = fromAlbumID
+	}
+	return l.call(http.MethodPost, "/api/v2/Photo::move", body, nil)
+}
+
+func (l *apiLibrary) Close() error {
+	return nil
+}
+
+// call makes an API request with a JSON body (if body is non-nil) and decodes
+// the JSON response into out (if out is non-nil).
+func (l *apiLibrary) call(method, path string, body any, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %v", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, l.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", l.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling Lychee API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &apiStatusError{
+			method:  method,
+			path:    path,
+			status:  resp.Status,
+			code:    resp.StatusCode,
+			message: strings.TrimSpace(string(msg)),
+		}
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return &authError{url: resp.Request.URL.String()}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding Lychee API response: %v", err)
+	}
+	return nil
+}
+
+// apiStatusError is returned for API responses with an unsuccessful status.
+type apiStatusError struct {
+	method  string
+	path    string
+	status  string
+	code    int
+	message string
+}
+
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("Lychee API %s %s returned %s: %s", e.method, e.path, e.status, e.message)
+}
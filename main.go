@@ -1,28 +1,40 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
 	"image/jpeg"
 	"io"
 	"log"
-	"net/http"
+	"log/slog"
+	"maps"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	vision "cloud.google.com/go/vision/apiv1"
 	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
 )
 
 var Version = "<dev>"
@@ -35,31 +47,294 @@ type Config struct {
 		User     string `json:"user"`
 		Password string `json:"password"`
 		Database string `json:"database"`
+		Schema   string `json:"schema"`
+
+		Tunnel TunnelConfig `json:"tunnel"`
 	} `json:"database"`
 	GoogleCloud struct {
 		ProjectID       string `json:"project_id"`
 		CredentialsFile string `json:"credentials_file"`
 	} `json:"gcp"`
-	BaseURL   string `json:"base_url"`
-	AlbumID   string `json:"album_id"`
-	StateFile string `json:"statefile"`
+	Journal struct {
+		Type    string            `json:"type"`
+		Path    string            `json:"path"`
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+	} `json:"journal"`
+	API               APIConfig              `json:"api"`
+	Notifications     []NotifierConfig       `json:"notifications"`
+	RareSpecies       RarityConfig           `json:"rare_species"`
+	Duplicates        DuplicateConfig        `json:"duplicates"`
+	HTTPSink          HTTPSinkConfig         `json:"http_sink"`
+	Lease             LeaseConfig            `json:"lease"`
+	Download          DownloadConfig         `json:"download"`
+	Video             VideoConfig            `json:"video"`
+	BirdNET           BirdNETConfig          `json:"birdnet"`
+	Ignore            IgnoreConfig           `json:"ignore"`
+	Filter            FilterConfig           `json:"filter"`
+	TitleMatch        TitleMatchConfig       `json:"title_match"`
+	Burst             BurstConfig            `json:"burst"`
+	Crop              CropConfig             `json:"crop"`
+	Image             ImageConfig            `json:"image"`
+	Proxy             string                 `json:"proxy"`
+	BaseURL           string                 `json:"base_url"`
+	UploadsPath       string                 `json:"uploads_path"`
+	AlbumIDs          albumList              `json:"album_id"`
+	AlbumTitles       albumList              `json:"album_title"`
+	IncludeSubAlbums  bool                   `json:"include_sub_albums"`
+	AllAlbums         bool                   `json:"all_albums"`
+	ExcludeAlbums     []string               `json:"exclude_albums"`
+	Albums            map[string]AlbumConfig `json:"albums"`
+	SizeVariants      []string               `json:"size_variants"`
+	VideoSizeVariants []string               `json:"video_size_variants"`
+	StateFile         string                 `json:"statefile"`
+	LockFile          string                 `json:"lock_file"`
+	CacheOCR          bool                   `json:"cache_ocr"`
+	MaxFailures       int                    `json:"max_failures"`
+	UpdateBatchSize   int                    `json:"update_batch_size"`
+	BackupDir         string                 `json:"backup_dir"`
+	Target            string                 `json:"target"`
+	TagSpecies        bool                   `json:"tag_species"`
+	OverlayTime       OverlayTimeConfig      `json:"overlay_time"`
+	OverlayData       OverlayDataConfig      `json:"overlay_data"`
+	TitleRules        []TitleRule            `json:"title_rules"`
+	RejectPatterns    []string               `json:"reject_patterns"`
+	Checklist         ChecklistConfig        `json:"checklist"`
+	Translation       TranslationConfig      `json:"translation"`
+	MultiSpecies      MultiSpeciesConfig     `json:"multi_species"`
+	Disambiguate      string                 `json:"disambiguate"`
+	AliasesFile       string                 `json:"aliases_file"`
+	SightingsLog      string                 `json:"sightings_log"`
+	Occurrence        OccurrenceConfig       `json:"occurrence"`
+	Schedule          string                 `json:"schedule"`
+	SkipVideos        *bool                  `json:"skip_videos"`
+	LowResource       bool                   `json:"low_resource"`
+	StatusAddr        string                 `json:"status_addr"`
+	GRPCAddr          string                 `json:"grpc_addr"`
+	GRPCToken         string                 `json:"grpc_token"`
+	GRPCMaxMessage    int                    `json:"grpc_max_message_bytes"`
+	Metrics           MetricsConfig          `json:"metrics"`
+	Tracing           TracingConfig          `json:"tracing"`
+	Healthcheck       HealthcheckConfig      `json:"healthcheck"`
+
+	NoTextConfig
+}
+
+// CropConfig describes the region of each image that is sent for OCR, as
+// fractions of the image height.
+type CropConfig struct {
+	// Height is the fraction of the image height to keep (default 0.2).
+	Height float64 `json:"height"`
+	// Offset is the fraction of the image height between the bottom of the
+	// crop region and the bottom of the image (default 0).
+	Offset float64 `json:"offset"`
+}
+
+// ImageConfig holds preprocessing options applied to the cropped region.
+type ImageConfig struct {
+	MaxLongEdge int  `json:"max_long_edge"`
+	Grayscale   bool `json:"grayscale"`
+	// MaxPixels is the largest image (width × height) that will be decoded.
+	MaxPixels int64 `json:"max_pixels"`
+}
+
+const defaultMaxImagePixels = 100_000_000
+
+func (c ImageConfig) maxPixels() int64 {
+	if c.MaxPixels > 0 {
+		return c.MaxPixels
+	}
+	return defaultMaxImagePixels
+}
+
+// checkImageSize returns an error if an image with the given dimensions is
+// too large to safely decode.
+func checkImageSize(width, height int, maxPixels int64) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid image dimensions %dx%d", width, height)
+	}
+	if int64(width)*int64(height) > maxPixels {
+		return fmt.Errorf("image dimensions %dx%d exceed limit of %d pixels", width, height, maxPixels)
+	}
+	return nil
+}
+
+// albumList is a list of album IDs (or titles) that can be given in the config
+// file as either a single album or a list, and on the command line by
+// repeating -album (or -album-title).
+type albumList []string
+
+func (l *albumList) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		*l = nil
+		if id != "" {
+			*l = albumList{id}
+		}
+		return nil
+	}
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return fmt.Errorf("expected an album or a list of albums")
+	}
+	*l = ids
+	return nil
+}
+
+func (l *albumList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *albumList) Set(id string) error {
+	*l = append(*l, id)
+	return nil
+}
+
+// selectAlbums returns the IDs of the albums to process: either those
+// configured by ID or title, or with all_albums, every album in the library
+// that isn't excluded.
+func selectAlbums(config *Config, library Library) ([]string, error) {
+	if !config.AllAlbums {
+		if len(config.AlbumTitles) == 0 {
+			return config.AlbumIDs, nil
+		}
+		all, err := library.Albums()
+		if err != nil {
+			return nil, fmt.Errorf("error listing albums: %v", err)
+		}
+		albumIDs := slices.Clone(config.AlbumIDs)
+		for _, title := range config.AlbumTitles {
+			albumID, err := findAlbum(all, title)
+			if err != nil {
+				return nil, err
+			}
+			slog.Info("Found album", "title", title, "album_id", albumID)
+			if !slices.Contains(albumIDs, albumID) {
+				albumIDs = append(albumIDs, albumID)
+			}
+		}
+		return albumIDs, nil
+	}
+	// Smart albums can be processed along with all regular albums
+	if len(config.AlbumTitles) > 0 || slices.ContainsFunc(config.AlbumIDs, func(id string) bool { return !isSmartAlbum(id) }) {
+		return nil, fmt.Errorf("set either album_id, album_title, or all_albums")
+	}
+
+	all, err := library.Albums()
+	if err != nil {
+		return nil, fmt.Errorf("error listing albums: %v", err)
+	}
+	albumIDs := make([]string, 0, len(all))
+	for _, album := range all {
+		if !slices.Contains(config.ExcludeAlbums, album.ID) && album.ID != config.QuarantineAlbum {
+			albumIDs = append(albumIDs, album.ID)
+		}
+	}
+	slog.Info("Processing albums", "count", len(albumIDs), "total", len(all))
+	return append(slices.Clone(config.AlbumIDs), albumIDs...), nil
+}
+
+// findAlbum returns the ID of the album with the given title. An exact match
+// is preferred over one that differs only in case; since titles needn't be
+// unique, a title matching several albums is an error.
+func findAlbum(albums []Album, title string) (string, error) {
+	var exact, folded []Album
+	for _, album := range albums {
+		if album.Title == title {
+			exact = append(exact, album)
+		} else if strings.EqualFold(album.Title, title) {
+			folded = append(folded, album)
+		}
+	}
+	matches := exact
+	if len(matches) == 0 {
+		matches = folded
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no album is titled %q", title)
+	case 1:
+		return matches[0].ID, nil
+	default:
+		ids := make([]string, len(matches))
+		for i, album := range matches {
+			ids[i] = album.ID
+		}
+		return "", fmt.Errorf("%d albums are titled %q (%s); use album_id to choose one", len(matches), title, strings.Join(ids, ", "))
+	}
+}
+
+// AlbumConfig holds per-album settings, keyed by album ID in Config.Albums.
+type AlbumConfig struct {
+	// ReadOnly albums are processed and reported on, but the database is
+	// never updated, regardless of -dry-run.
+	ReadOnly bool `json:"read_only"`
+	// NoTextAction, if set, overrides the global no_text_action.
+	NoTextAction []string `json:"no_text_action"`
+	// Schedule, if set, runs the album on its own schedule in daemon mode,
+	// rather than with the other albums on the global schedule.
+	Schedule string `json:"schedule"`
 }
 
 type Photo struct {
-	ID       string
-	Title    string
-	ImageURL string
+	ID          string
+	Title       string
+	Description string
+	Type        string    // MIME type of the original
+	TakenAt     time.Time // when the photo was taken, or uploaded if unknown
+	Checksum    string    // of the original file
+	Starred     bool
+	Tags        []string
+	SizeVariant int
+	ShortPath   string
+	Filesize    int64 // of the selected size variant, as recorded by Lychee
+	Width       int
+	Height      int
+	ImageURL    string
+	ImageFile   string // local path, when reading from uploads_path
+
+	OriginalShortPath string
+	OriginalURL       string
+	OriginalFile      string
+
+	AlbumID string // the album the photo was found in
+	WebLink string // the photo's page in the Lychee web UI
+
+	// LivePhotoContentID links the still and video halves of a live photo
+	// that were stored as separate photos.
+	LivePhotoContentID string
 }
 
-type PhotoError struct {
-	ID      string
-	URL     string
-	Error   string
-	WebLink string
+// locatePhoto fills in the URLs (and, with uploads_path, local paths) of the
+// photo's files and its page in the web UI.
+func locatePhoto(config *Config, photo Photo) Photo {
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+	shortPath := strings.TrimLeft(photo.ShortPath, "/")
+	photo.ImageURL = fmt.Sprintf("%s/uploads/%s", baseURL, shortPath)
+	if photo.OriginalShortPath != "" {
+		photo.OriginalURL = fmt.Sprintf("%s/uploads/%s", baseURL, strings.TrimLeft(photo.OriginalShortPath, "/"))
+	}
+	photo.WebLink = fmt.Sprintf("%s/gallery/%s/%s", baseURL, photo.AlbumID, photo.ID)
+	if config.UploadsPath != "" {
+		photo.ImageFile = filepath.Join(config.UploadsPath, filepath.FromSlash(shortPath))
+		if photo.OriginalShortPath != "" {
+			photo.OriginalFile = filepath.Join(config.UploadsPath, filepath.FromSlash(strings.TrimLeft(photo.OriginalShortPath, "/")))
+		}
+	}
+	return photo
 }
 
-type State struct {
-	NoTextPhotos map[string]bool `json:"no_text_photos"`
+// IsVideo reports whether the photo's original is a video.
+func (p Photo) IsVideo() bool {
+	return strings.HasPrefix(p.Type, "video/")
+}
+
+type PhotoError struct {
+	ID      string `json:"photo_id"`
+	URL     string `json:"image_url"`
+	Error   string `json:"error"`
+	WebLink string `json:"web_url"`
 }
 
 func loadConfig(path string) (*Config, error) {
@@ -73,6 +348,10 @@ func loadConfig(path string) (*Config, error) {
 	if err := json.NewDecoder(file).Decode(&config); err != nil {
 		return nil, fmt.Errorf("error decoding config file: %v", err)
 	}
+	if _, err := parseProxyURL(config.Proxy); err != nil {
+		return nil, fmt.Errorf("error in proxy config: %v", err)
+	}
+	config.applyLowResource()
 
 	return &config, nil
 }
@@ -94,175 +373,238 @@ func isVideoFile(path string) bool {
 	return ext == ".mp4" || ext == ".mov" || ext == ".avi"
 }
 
-func extractFirstFrame(videoPath string) (string, error) {
-	// Create a temporary file for the output frame
-	tmpFile, err := os.CreateTemp("", "frame-*.jpg")
+func isGIFFile(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".gif"
+}
+
+func extractGIFFrame(gifPath string, maxPixels int64) (string, error) {
+	file, err := os.Open(gifPath)
 	if err != nil {
-		return "", fmt.Errorf("error creating temp file: %v", err)
+		return "", fmt.Errorf("error opening GIF: %v", err)
 	}
-	defer tmpFile.Close()
-
-	// Use ffmpeg to extract the first frame with specific quality settings
-	cmd := exec.Command("ffmpeg",
-		"-i", videoPath, // Input video
-		"-vframes", "1", // Extract only one frame
-		"-q:v", "2", // High quality
-		"-y",           // Overwrite output file if it exists
-		tmpFile.Name()) // Output file
+	defer file.Close()
 
-	// Capture both stdout and stderr for better error reporting
-	output, err := cmd.CombinedOutput()
+	// Check the dimensions in the header before decoding any frames
+	cfg, err := gif.DecodeConfig(file)
 	if err != nil {
-		return "", fmt.Errorf("error extracting frame: %v (ffmpeg output: %s)", err, string(output))
+		return "", fmt.Errorf("error decoding GIF header: %v", err)
+	}
+	if err := checkImageSize(cfg.Width, cfg.Height, maxPixels); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("error rewinding GIF: %v", err)
 	}
 
-	return tmpFile.Name(), nil
-}
-
-func downloadFile(url string) (string, error) {
-	resp, err := http.Get(url)
+	g, err := gif.DecodeAll(file)
 	if err != nil {
-		return "", fmt.Errorf("error downloading file: %v", err)
+		return "", fmt.Errorf("error decoding GIF: %v", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status: %s", resp.Status)
+	if len(g.Image) == 0 {
+		return "", fmt.Errorf("GIF contains no frames")
 	}
 
-	// Determine file extension from URL
-	ext := filepath.Ext(url)
-	if ext == "" {
-		ext = ".jpg" // Default to jpg if no extension found
+	// The first frame may not cover the whole logical screen, so draw it onto
+	// a canvas of the full GIF size
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	if canvas.Bounds().Empty() {
+		canvas = image.NewRGBA(g.Image[0].Bounds())
 	}
+	draw.Draw(canvas, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Over)
 
-	// Create a temporary file with the appropriate extension
-	tmpFile, err := os.CreateTemp("", "file-*"+ext)
+	// Create a temporary file for the output frame
+	tmpFile, err := os.CreateTemp("", "frame-*.jpg")
 	if err != nil {
 		return "", fmt.Errorf("error creating temp file: %v", err)
 	}
 	defer tmpFile.Close()
 
-	// Copy the file data
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		return "", fmt.Errorf("error saving file: %v", err)
+	if err := jpeg.Encode(tmpFile, canvas, &jpeg.Options{Quality: 95}); err != nil {
+		return "", fmt.Errorf("error encoding frame: %v", err)
 	}
 
 	return tmpFile.Name(), nil
 }
 
-func cropImage(inputPath string) (string, error) {
-	// Open the input image
-	file, err := os.Open(inputPath)
-	if err != nil {
-		return "", fmt.Errorf("error opening image: %v", err)
+// downscaleImage shrinks img so its longest edge is at most maxLongEdge pixels,
+// averaging the source pixels covered by each output pixel. Images that are
+// already small enough (or a maxLongEdge <= 0) are returned unchanged.
+func downscaleImage(img image.Image, maxLongEdge int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if maxLongEdge <= 0 || max(w, h) <= maxLongEdge {
+		return img
 	}
-	defer file.Close()
 
-	// Decode the image
-	img, err := jpeg.Decode(file)
-	if err != nil {
-		return "", fmt.Errorf("error decoding image: %v", err)
+	scale := float64(maxLongEdge) / float64(max(w, h))
+	dstW := max(1, int(float64(w)*scale+0.5))
+	dstH := max(1, int(float64(h)*scale+0.5))
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for dy := 0; dy < dstH; dy++ {
+		sy0 := bounds.Min.Y + dy*h/dstH
+		sy1 := bounds.Min.Y + (dy+1)*h/dstH
+		for dx := 0; dx < dstW; dx++ {
+			sx0 := bounds.Min.X + dx*w/dstW
+			sx1 := bounds.Min.X + (dx+1)*w/dstW
+
+			var r, g, b, a, n uint64
+			for y := sy0; y < sy1; y++ {
+				for x := sx0; x < sx1; x++ {
+					cr, cg, cb, ca := img.At(x, y).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(dx, dy, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
+
+// cropRegion returns the part of img described by crop.
+func cropRegion(img image.Image, crop CropConfig) image.Image {
+	cropFraction := crop.Height
+	if cropFraction <= 0 || cropFraction > 1 {
+		cropFraction = 0.2
 	}
 
 	// Get image bounds
 	bounds := img.Bounds()
 	height := bounds.Dy()
 
-	// Calculate crop dimensions (bottom 20%)
-	cropHeight := height / 5
-	cropY := height - cropHeight
+	// Calculate crop dimensions, measured up from the bottom of the image
+	cropHeight := max(1, int(float64(height)*cropFraction))
+	cropY := height - cropHeight - int(float64(height)*crop.Offset)
+	cropY = min(max(cropY, 0), height-cropHeight)
 
 	// Create a new image for the cropped portion
 	cropped := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), cropHeight))
 
-	// Copy the bottom 20% of the image
+	// Copy the crop region of the image
 	for y := 0; y < cropHeight; y++ {
 		for x := 0; x < bounds.Dx(); x++ {
-			cropped.Set(x, y, img.At(x, cropY+y))
+			cropped.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+cropY+y))
 		}
 	}
 
-	// Create output file
-	outputPath := inputPath + ".cropped.jpg"
-	outFile, err := os.Create(outputPath)
+	return cropped
+}
+
+// preprocessImage applies the configured preprocessing steps to a cropped region.
+func preprocessImage(img image.Image, opts ImageConfig) image.Image {
+	// Shrink the cropped region if it's larger than needed for OCR
+	img = downscaleImage(img, opts.MaxLongEdge)
+
+	if opts.Grayscale {
+		gray := image.NewGray(img.Bounds())
+		draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = gray
+	}
+
+	return img
+}
+
+func writeJPEG(path string, img image.Image) error {
+	outFile, err := os.Create(path)
 	if err != nil {
-		return "", fmt.Errorf("error creating output file: %v", err)
+		return fmt.Errorf("error creating output file: %v", err)
 	}
 	defer outFile.Close()
 
-	// Encode the cropped image
-	if err := jpeg.Encode(outFile, cropped, nil); err != nil {
-		return "", fmt.Errorf("error encoding cropped image: %v", err)
+	if err := jpeg.Encode(outFile, img, nil); err != nil {
+		return fmt.Errorf("error encoding image: %v", err)
 	}
-
-	return outputPath, nil
+	return nil
 }
 
-func performOCR(ctx context.Context, imagePath string, client *vision.ImageAnnotatorClient) (string, error) {
-	file, err := os.Open(imagePath)
+func decodeJPEG(path string, maxPixels int64) (image.Image, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return "", fmt.Errorf("error opening image: %v", err)
+		return nil, fmt.Errorf("error opening image: %v", err)
 	}
 	defer file.Close()
 
-	image, err := vision.NewImageFromReader(file)
+	// Check the dimensions in the header before allocating the full image
+	cfg, err := jpeg.DecodeConfig(file)
 	if err != nil {
-		return "", fmt.Errorf("error creating vision image: %v", err)
+		return nil, fmt.Errorf("error decoding image header: %v", err)
+	}
+	if err := checkImageSize(cfg.Width, cfg.Height, maxPixels); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error rewinding image: %v", err)
 	}
 
-	annotations, err := client.DetectTexts(ctx, image, nil, 1)
+	img, err := jpeg.Decode(file)
 	if err != nil {
-		return "", fmt.Errorf("error detecting text: %v", err)
+		return nil, fmt.Errorf("error decoding image: %v", err)
 	}
+	return img, nil
+}
 
-	if len(annotations) == 0 {
-		return "", fmt.Errorf("no text detected")
+func cropImage(inputPath string, crop CropConfig, opts ImageConfig) (string, error) {
+	img, err := decodeJPEG(inputPath, opts.maxPixels())
+	if err != nil {
+		return "", err
 	}
 
-	// Get the first (and should be only) text annotation
-	return annotations[0].Description, nil
+	result := preprocessImage(cropRegion(img, crop), opts)
+
+	outputPath := inputPath + ".cropped.jpg"
+	if err := writeJPEG(outputPath, result); err != nil {
+		return "", fmt.Errorf("error writing cropped image: %v", err)
+	}
+
+	return outputPath, nil
 }
 
-func loadState(path string) (*State, error) {
-	file, err := os.Open(path)
+func performOCR(ctx context.Context, imagePath string, client *vision.ImageAnnotatorClient) (string, error) {
+	file, err := os.Open(imagePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Return empty state if file doesn't exist
-			return &State{NoTextPhotos: make(map[string]bool)}, nil
-		}
-		return nil, fmt.Errorf("error opening state file: %v", err)
+		return "", fmt.Errorf("error opening image: %v", err)
 	}
 	defer file.Close()
 
-	var state State
-	if err := json.NewDecoder(file).Decode(&state); err != nil {
-		return nil, fmt.Errorf("error decoding state file: %v", err)
+	image, err := vision.NewImageFromReader(file)
+	if err != nil {
+		return "", fmt.Errorf("error creating vision image: %v", err)
 	}
 
-	return &state, nil
-}
-
-func saveState(path string, state *State) error {
-	file, err := os.Create(path)
+	annotations, err := client.DetectTexts(ctx, image, nil, 1)
 	if err != nil {
-		return fmt.Errorf("error creating state file: %v", err)
+		return "", fmt.Errorf("error detecting text: %v", err)
 	}
-	defer file.Close()
 
-	if err := json.NewEncoder(file).Encode(state); err != nil {
-		return fmt.Errorf("error encoding state file: %v", err)
+	if len(annotations) == 0 {
+		return "", fmt.Errorf("no text detected")
 	}
 
-	return nil
+	// Get the first (and should be only) text annotation
+	return annotations[0].Description, nil
 }
 
 func buildConnectionString(config *Config) (string, string, error) {
 	switch strings.ToLower(config.Database.Type) {
 	case "mysql":
-		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
+		network := "tcp"
+		if config.Database.Tunnel.enabled() {
+			network = tunnelNetwork
+		}
+		dsn := fmt.Sprintf("%s:%s@%s(%s:%d)/%s?parseTime=true",
 			config.Database.User,
 			config.Database.Password,
+			network,
 			config.Database.Host,
 			config.Database.Port,
 			config.Database.Database,
@@ -279,17 +621,104 @@ func buildConnectionString(config *Config) (string, string, error) {
 		return "postgres", dsn, nil
 	case "sqlite", "sqlite3":
 		return "sqlite3", config.Database.Database, nil
+	case "api":
+		return "", "", fmt.Errorf("this command needs direct database access, which isn't available with database type api")
 	default:
 		return "", "", fmt.Errorf("unsupported database type: %s", config.Database.Type)
 	}
 }
 
+// createReviewTask opens a Things URL that adds a task to review the photo.
+func createReviewTask(photo Photo, notes string, dryRun bool) {
+	thingsURL := fmt.Sprintf("things:///add?title=%s&notes=%s",
+		url.PathEscape(fmt.Sprintf("[Lychee BB] Review %s", photo.ID)),
+		url.PathEscape(notes))
+	if dryRun {
+		slog.Info("Would open Things URL", "url", thingsURL)
+		return
+	}
+	if err := exec.Command("open", thingsURL).Run(); err != nil {
+		slog.Error("Error opening Things URL", "error", err)
+	}
+}
+
+// newVisionClient creates a Cloud Vision client with the configured
+// credentials, connecting through the proxy if one is set.
+func newVisionClient(ctx context.Context, config *Config, proxyURL *url.URL) (*vision.ImageAnnotatorClient, error) {
+	clientOpts := []option.ClientOption{option.WithCredentialsFile(config.GoogleCloud.CredentialsFile)}
+	if proxyURL != nil {
+		dialer, err := proxyDialer(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("error in proxy config: %v", err)
+		}
+		clientOpts = append(clientOpts, option.WithGRPCDialOption(grpc.WithContextDialer(dialer)))
+	}
+	return vision.NewImageAnnotatorClient(ctx, clientOpts...)
+}
+
+func openDatabase(config *Config) (*sql.DB, error) {
+	if err := checkSchema(config.Database.Schema); err != nil {
+		return nil, err
+	}
+	driver, dsn, err := buildConnectionString(config)
+	if err != nil {
+		return nil, fmt.Errorf("error building connection string: %v", err)
+	}
+
+	if config.Database.Tunnel.enabled() {
+		return openTunneledDatabase(driver, dsn, config.Database.Tunnel)
+	}
+	if driver == "postgres" {
+		connector, err := pq.NewConnector(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to database: %v", err)
+		}
+		return sql.OpenDB(postgresConnector{connector}), nil
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database: %v", err)
+	}
+	return db, nil
+}
+
 func main() {
 	dryRun := flag.Bool("dry-run", true, "Perform a dry run without updating the database")
 	showVersion := flag.Bool("version", false, "Show version and exit")
 	configFile := flag.String("config", "config.json", "Path to configuration file")
 	maxImages := flag.Int("max", 0, "Maximum number of images to process (0 for unlimited)")
 	things := flag.Bool("things", false, "Create Things tasks for photos with no text detected")
+	workers := flag.Int("workers", 1, "Number of photos to download and OCR concurrently")
+	photoTimeout := flag.Duration("photo-timeout", 0, "Maximum time to spend downloading and OCRing each photo (0 for unlimited)")
+	maxRuntime := flag.Duration("max-runtime", 0, "Stop starting new photos and cancel in-progress ones after this long (0 for unlimited)")
+	resume := flag.Bool("resume", false, "Resume an interrupted run, skipping the photos it already handled")
+	force := flag.Bool("force", false, "Process photos again that the state file records as having no text or as failing too often")
+	retitle := flag.Bool("retitle", false, "Process photos again that already have a title")
+	confirmWrites := flag.Bool("confirm", false, "List each batch of title updates and ask before writing it")
+	output := flag.String("output", outputText, "Format of the run summary: text or json")
+	outputFile := flag.String("output-file", "", "Write the -output json report to this file instead of stdout")
+	events := flag.String("events", "", "Append a JSON line for each photo's outcome to this file as the run progresses (- for stdout)")
+	color := flag.Bool("color", false, "Colorize the dry-run table of proposed changes")
+	report := flag.String("report", "", "Write the run's title changes and errors to this .html or .csv file")
+	lastRun := flag.Bool("last-run", false, "Print a summary of the last completed run and exit")
+	var albums albumList
+	flag.Var(&albums, "album", "Album ID to process instead of the config file's albums (repeat for several albums)")
+	since := flag.String("since", "", "Only process photos taken at or after this date (YYYY-MM-DD), time (RFC 3339), or age (e.g. 7d)")
+	until := flag.String("until", "", "Only process photos taken before this time, or on or before this date")
+	var albumTitles albumList
+	flag.Var(&albumTitles, "album-title", "Title of an album to process instead of the config file's albums (repeat for several albums)")
+	photoID := flag.String("photo-id", "", "Process only this photo, whatever its title, without reading or updating the state file")
+	simulate := flag.Bool("simulate", false, "Run against a built-in sample album, media server, and OCR provider instead of real infrastructure")
+	logLevel := flag.String("log-level", "info", "Minimum level of log messages: debug, info, warn, or error")
+	logFormat := flag.String("log-format", logFormatText, "Format of log messages: text or json")
+	showProgress := flag.Bool("progress", true, "Show a progress bar instead of per-photo log messages when stdout is a terminal")
+	quiet := flag.Bool("quiet", false, "Only log warnings and errors (same as -log-level warn)")
+	verbose := flag.Bool("verbose", false, "Log each step of processing a photo (same as -log-level debug)")
+	logFile := flag.String("log-file", "", "Also write log messages to this file")
+	logMaxSize := flag.Int64("log-max-size", 10, "Rotate the -log-file once it reaches this many megabytes (0 for no limit)")
+	logMaxAge := flag.Duration("log-max-age", 0, "Rotate the -log-file once it's older than this (e.g. 24h; 0 for no limit)")
+	logMaxBackups := flag.Int("log-max-backups", 5, "Number of rotated log files to keep (0 to keep them all)")
 	flag.Parse()
 
 	if *showVersion {
@@ -297,208 +726,1236 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Load configuration
-	config, err := loadConfig(*configFile)
-	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+	opts := runOptions{
+		DryRun:    *dryRun,
+		MaxImages: *maxImages,
+		Things:    *things,
+		Workers:   *workers,
+
+		PhotoTimeout: *photoTimeout,
+		MaxRuntime:   *maxRuntime,
+		Resume:       *resume,
+
+		Force:   *force,
+		Retitle: *retitle,
+		Confirm: *confirmWrites,
+
+		Output:     *output,
+		OutputFile: *outputFile,
+		Events:     *events,
+		Report:     *report,
+		Color:      *color,
 	}
-
-	// Load state
-	state, err := loadState(config.StateFile)
-	if err != nil {
-		log.Fatalf("Error loading state: %v", err)
+	if opts.Output != outputText && opts.Output != outputJSON {
+		log.Fatalf("Error: unknown -output %q (expected text or json)", opts.Output)
 	}
-
-	// Initialize database connection
-	driver, dsn, err := buildConnectionString(config)
-	if err != nil {
-		log.Fatalf("Error building connection string: %v", err)
+	if opts.Confirm && !opts.textOutput() {
+		log.Fatalf("Error: -confirm can't be used with JSON output on stdout; use -output-file or an -events file")
+	}
+	if opts.Events == "-" && opts.Output == outputJSON && opts.OutputFile == "" {
+		log.Fatalf("Error: -output json and -events can't both write to stdout; use -output-file")
+	}
+	if opts.Report != "" {
+		if _, err := reportFormat(opts.Report); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
 	}
 
-	db, err := sql.Open(driver, dsn)
-	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+	levelSet, photoIDSet := false, false
+	flag.Visit(func(f *flag.Flag) {
+		levelSet = levelSet || f.Name == "log-level"
+		photoIDSet = photoIDSet || f.Name == "photo-id"
+	})
+	// An empty -photo-id would otherwise process every photo
+	if photoIDSet && strings.TrimSpace(*photoID) == "" {
+		log.Fatalf("Error: -photo-id requires a photo ID")
+	}
+	if *quiet || *verbose {
+		if levelSet {
+			log.Fatalf("Error: -quiet and -verbose can't be used with -log-level")
+		}
+		if *quiet && *verbose {
+			log.Fatalf("Error: -quiet and -verbose can't be used together")
+		}
+		if *quiet {
+			*logLevel = "warn"
+		} else {
+			*logLevel = "debug"
+		}
+		levelSet = true
 	}
-	defer db.Close()
 
-	// Initialize Google Cloud Vision client
-	ctx := context.Background()
-	client, err := vision.NewImageAnnotatorClient(ctx,
-		option.WithCredentialsFile(config.GoogleCloud.CredentialsFile))
-	if err != nil {
-		log.Fatalf("Error creating Vision client: %v", err)
+	// The progress bar stands in for per-photo log messages on the
+	// terminal, unless a log level was asked for
+	stderr := logOutput{w: os.Stderr, level: *logLevel}
+	if *showProgress && opts.textOutput() && !opts.Confirm && isTerminal(os.Stdout) {
+		opts.Progress = newProgressBar(os.Stdout)
+		stderr.w = opts.Progress.LogWriter(os.Stderr)
+		if !levelSet {
+			stderr.level = "warn"
+		}
+	}
+	logOutputs := []logOutput{stderr}
+	if *logFile != "" {
+		file, err := openRotatingFile(*logFile, *logMaxSize<<20, *logMaxAge, *logMaxBackups)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		logOutputs = append(logOutputs, logOutput{w: file, level: *logLevel})
+	}
+	if err := setupLogging(*logFormat, logOutputs...); err != nil {
+		log.Fatalf("Error: %v", err)
 	}
-	defer client.Close()
 
-	// Query for photos
-	query := `
-		SELECT p.id, p.title, sv.short_path
-		FROM photos p
-		JOIN size_variants sv ON p.id = sv.photo_id
-		JOIN photo_album pa on p.id = pa.photo_id
-		WHERE pa.album_id = ? AND sv.type = 1
-	`
+	// run-all loads its own config files
+	if flag.Arg(0) == "run-all" {
+		if *simulate || *lastRun {
+			log.Fatalf("Error: run-all can't be used with -simulate or -last-run")
+		}
+		if err := runAll(context.Background(), flag.Args()[1:], opts); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
 
-	rows, err := db.Query(query, config.AlbumID)
+	// Load configuration
+	config, err := loadConfig(*configFile)
 	if err != nil {
-		log.Fatalf("Error querying photos: %v", err)
+		log.Fatalf("Error loading config: %v", err)
 	}
-	defer rows.Close()
 
-	photoCount := 0
-	processedCount := 0
-	updatedCount := 0
-	thingsCount := 0
-	var errors []PhotoError
+	if *since != "" {
+		config.Filter.Since = *since
+	}
+	if *until != "" {
+		config.Filter.Until = *until
+	}
+	if len(albums) > 0 || len(albumTitles) > 0 {
+		config.AlbumIDs = albums
+		config.AlbumTitles = albumTitles
+		config.AllAlbums = false
+	}
 
-	for rows.Next() {
-		var photo Photo
-		var shortPath string
-		if err := rows.Scan(&photo.ID, &photo.Title, &shortPath); err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue
+	if *lastRun {
+		if err := printLastRun(config); err != nil {
+			log.Fatalf("Error: %v", err)
 		}
+		return
+	}
 
-		// Skip if title is not a UUID
-		if !isUUID(photo.Title) {
-			continue
+	var simulation *Simulation
+	if *simulate {
+		if flag.Arg(0) != "" && flag.Arg(0) != "bench" {
+			log.Fatalf("Error: -simulate can't be used with the %s command", flag.Arg(0))
 		}
-
-		// Skip if we've already processed this photo and found no text
-		if state.NoTextPhotos[photo.ID] {
-			log.Printf("Skipping photo %s (previously found no text)", photo.ID)
-			continue
+		simulation, err = startSimulation(config)
+		if err != nil {
+			log.Fatalf("Error starting simulation: %v", err)
 		}
+		defer simulation.Close()
+		slog.Info("Simulating a run against a sample album", "photos", len(simulatedPhotos), "base_url", config.BaseURL)
+	}
 
-		// Check if we've reached the maximum number of images to process
-		if *maxImages > 0 && photoCount >= *maxImages {
-			log.Printf("Reached maximum number of images to process (%d)", *maxImages)
-			break
+	if *photoID != "" {
+		if flag.Arg(0) != "" {
+			log.Fatalf("Error: -photo-id can't be used with the %s command", flag.Arg(0))
 		}
+		// Keep this run's state, and its checkpoint, apart from the real
+		// state file
+		dir, err := os.MkdirTemp("", "lychee-birb-title-photo-")
+		if err != nil {
+			log.Fatalf("Error creating temporary state directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		config.StateFile = filepath.Join(dir, "state.json")
+		config.Filter.Since, config.Filter.Until = "", ""
+		opts.PhotoID = *photoID
+	}
 
-		photoCount++
-
-		// Clean up the base URL and paths
-		baseURL := strings.TrimRight(config.BaseURL, "/")
-		shortPath = strings.TrimLeft(shortPath, "/")
-		photo.ImageURL = fmt.Sprintf("%s/uploads/%s", baseURL, shortPath)
-		webLink := fmt.Sprintf("%s/gallery/%s/%s", baseURL, config.AlbumID, photo.ID)
-
-		// Download and process the file
-		filePath, err := downloadFile(photo.ImageURL)
+	// Commands that write to the state file (or, like undo, to photos a run
+	// may be processing) must not run concurrently
+	switch flag.Arg(0) {
+	case "", "daemon", "state", "undo":
+		unlock, err := acquireLock(lockPath(config))
 		if err != nil {
-			errors = append(errors, PhotoError{
-				ID:      photo.ID,
-				URL:     photo.ImageURL,
-				Error:   fmt.Sprintf("Error downloading file: %v", err),
-				WebLink: webLink,
-			})
-			continue
+			log.Fatalf("Error: %v", err)
 		}
-		defer func() { _ = os.Remove(filePath) }()
+		defer unlock()
+	}
 
-		// If it's a video, extract the first frame
-		var imagePath string
-		if isVideoFile(photo.ImageURL) {
-			imagePath, err = extractFirstFrame(filePath)
-			if err != nil {
-				errors = append(errors, PhotoError{
-					ID:      photo.ID,
-					URL:     photo.ImageURL,
-					Error:   fmt.Sprintf("Error extracting frame from video: %v", err),
-					WebLink: webLink,
-				})
-				continue
-			}
-			defer func() { _ = os.Remove(imagePath) }()
-		} else {
-			imagePath = filePath
+	// Commands that don't need the Vision API
+	switch flag.Arg(0) {
+	case "state":
+		if err := runStateCommand(config, flag.Args()[1:], *dryRun); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	case "undo":
+		if flag.NArg() != 2 {
+			log.Fatalf("Usage: lychee-birb-title [flags] undo RUN_ID")
+		}
+		if err := runUndo(config, flag.Arg(1), *dryRun); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	case "verify":
+		if err := runVerify(config); err != nil {
+			log.Fatalf("Error: %v", err)
 		}
+		return
+	case "export-occurrences":
+		if err := runExportOccurrences(config, flag.Args()[1:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	case "support-bundle":
+		if err := runSupportBundle(config, *configFile, *logFile, flag.Args()[1:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	proxyURL, err := parseProxyURL(config.Proxy)
+	if err != nil {
+		log.Fatalf("Error in proxy config: %v", err)
+	}
 
-		// Now crop the image (or the extracted frame)
-		croppedPath, err := cropImage(imagePath)
+	// Initialize Google Cloud Vision client
+	ctx := context.Background()
+	var ocr OCRProvider = simulatedOCR{}
+	if simulation == nil {
+		client, err := newVisionClient(ctx, config, proxyURL)
 		if err != nil {
-			errors = append(errors, PhotoError{
-				ID:      photo.ID,
-				URL:     photo.ImageURL,
-				Error:   fmt.Sprintf("Error cropping image: %v", err),
-				WebLink: webLink,
-			})
-			continue
+			log.Fatalf("Error creating Vision client: %v", err)
 		}
-		defer func() { _ = os.Remove(croppedPath) }()
+		defer client.Close()
+		ocr = visionOCR{client}
+	}
 
-		processedCount++
+	downloader, err := newDownloader(config.Download, config.BaseURL, proxyURL)
+	if err != nil {
+		log.Fatalf("Error configuring downloads: %v", err)
+	}
 
-		text, err := performOCR(ctx, croppedPath, client)
-		if err != nil {
-			if strings.Contains(err.Error(), "no text detected") {
-				// If no text detected and --things flag is set, create a task for manual review
-				if *things {
-					// Add to state file
-					state.NoTextPhotos[photo.ID] = true
-					if err := saveState(config.StateFile, state); err != nil {
-						log.Printf("Error saving state: %v", err)
-					}
+	if config.LowResource && opts.Workers > 1 {
+		slog.Info("Low-resource mode: using 1 worker", "workers", opts.Workers)
+		opts.Workers = 1
+	}
 
-					// Create Things URL for manual review
-					thingsURL := fmt.Sprintf("things:///add?title=%s&notes=%s",
-						url.PathEscape(fmt.Sprintf("[Lychee BB] Review %s", photo.ID)),
-						url.PathEscape(fmt.Sprintf("Image: %s\nWeb UI: %s", photo.ImageURL, webLink)))
-					if *dryRun {
-						fmt.Printf("Would open Things URL: %s\n", thingsURL)
-					} else {
-						if err := exec.Command("open", thingsURL).Run(); err != nil {
-							log.Printf("Error opening Things URL: %v", err)
-						}
-					}
-					thingsCount++
+	switch flag.Arg(0) {
+	case "":
+		if err := run(ctx, config, ocr, downloader, opts); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if simulation != nil && opts.textOutput() {
+			fmt.Println("\nSimulated album after the run:")
+			if err := simulation.PrintAlbum(os.Stdout); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+	case "daemon":
+		if opts.Confirm {
+			log.Fatalf("Error: daemon can't be used with -confirm")
+		}
+		if err := runDaemon(ctx, config, ocr, downloader, opts); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	case "calibrate":
+		if flag.NArg() != 2 {
+			log.Fatalf("Usage: lychee-birb-title [flags] calibrate SAMPLES_FILE")
+		}
+		if err := runCalibrate(ctx, config, *configFile, flag.Arg(1), ocr, downloader, *dryRun); err != nil {
+			log.Fatalf("Error calibrating: %v", err)
+		}
+		return
+	case "bench":
+		if err := runBench(ctx, config, ocr, downloader, flag.Args()[1:]); err != nil {
+			log.Fatalf("Error benchmarking: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown command: %s", flag.Arg(0))
+	}
+}
+
+// errInterrupted is returned by run when it was stopped early by a signal.
+var errInterrupted = errors.New("run interrupted")
+
+// runOptions holds the command-line options that affect a run.
+type runOptions struct {
+	DryRun    bool
+	MaxImages int
+	Things    bool
+	Workers   int
+
+	// PhotoTimeout bounds the time spent on each photo, and MaxRuntime the
+	// time spent on the whole run (0 for unlimited).
+	PhotoTimeout time.Duration
+	MaxRuntime   time.Duration
+
+	// Resume skips the photos handled by the previous, unfinished run.
+	Resume bool
+
+	// Force processes photos previously found to have no text or skipped
+	// after max_failures, and Retitle photos that already have a title.
+	Force   bool
+	Retitle bool
+
+	// Confirm lists each batch of title updates and asks on the terminal
+	// before writing it.
+	Confirm bool
+
+	// Output is the format of the run summary, and OutputFile where a JSON
+	// summary is written instead of stdout.
+	Output     string
+	OutputFile string
+
+	// Events is where each photo's outcome is streamed as a JSON line, or
+	// "-" for stdout.
+	Events string
+
+	// Report is an HTML or CSV file the run's changes and errors are
+	// written to.
+	Report string
+
+	// Progress, if set, shows the run's progress on the terminal.
+	Progress *progressBar
+
+	// Color colorizes the dry-run table of proposed changes.
+	Color bool
+
+	// PhotoID, if set, limits the run to this photo, which is processed
+	// whatever its title and the configured filters.
+	PhotoID string
+
+	// Album, if set, limits the run to this album instead of the configured
+	// ones, and SkipAlbums leaves albums out of the configured ones. The
+	// daemon uses them to run albums on their own schedules.
+	Album      string
+	SkipAlbums []string
+
+	// Leases, if set, are the daemon's album leases, which it holds between
+	// runs; otherwise a run with leases enabled takes its own and releases
+	// them when it finishes.
+	Leases *Leases
+}
+
+// textOutput reports whether the human-readable summary is printed to
+// stdout, which is left to the JSON report or events when they're written
+// there.
+func (o runOptions) textOutput() bool {
+	return (o.Output != outputJSON || o.OutputFile != "") && o.Events != "-"
+}
+
+// run processes the configured album once.
+func run(ctx context.Context, config *Config, ocr OCRProvider, downloader *Downloader, opts runOptions) (err error) {
+	// Tell the cron monitor the run has started, and how it finished;
+	// interrupted runs are left for the monitor to notice if they stop
+	// running altogether
+	healthcheckClient := config.httpClient(healthcheckTimeout)
+	pingHealthcheck(config.Healthcheck, healthcheckClient, healthcheckStart, "")
+	var finished *RunSummary
+	defer func() {
+		var body string
+		if finished != nil {
+			body = finished.String()
+		}
+		switch {
+		case errors.Is(err, errInterrupted):
+		case err != nil:
+			pingHealthcheck(config.Healthcheck, healthcheckClient, healthcheckFail, strings.TrimSpace(fmt.Sprintf("%s\nError: %v", body, err)))
+		case config.Healthcheck.FailOnErrors && finished != nil && finished.Errors > 0:
+			pingHealthcheck(config.Healthcheck, healthcheckClient, healthcheckFail, body)
+		default:
+			pingHealthcheck(config.Healthcheck, healthcheckClient, healthcheckSuccess, body)
+		}
+	}()
+
+	// Load state
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return fmt.Errorf("error loading state: %v", err)
+	}
+
+	// Connect to Lychee
+	library, err := openLibrary(config, downloader)
+	if err != nil {
+		return err
+	}
+	defer library.Close()
+
+	// Open the change journal
+	journal, err := newJournal(config)
+	if err != nil {
+		return fmt.Errorf("error opening journal: %v", err)
+	}
+	defer journal.Close()
+	started := time.Now()
+	runID := started.UTC().Format("20060102T150405Z")
+
+	// Each run is a trace, with a span for each photo
+	var tracer *tracer
+	if config.Tracing.Endpoint != "" {
+		tracer = newTracer(config.Tracing, config.httpClient(30*time.Second))
+	}
+	ctx, runSpan := tracer.Start(ctx, "run", slog.String("run.id", runID), slog.Bool("dry_run", opts.DryRun))
+	defer func() {
+		runSpan.End()
+		if err := tracer.Flush(); err != nil {
+			slog.Error("Error exporting traces", "error", err)
+		}
+	}()
+	recordChanges := func(photo Photo, changes []fieldChange) {
+		for _, change := range changes {
+			if err := journal.Record(JournalEntry{
+				RunID:    runID,
+				Time:     time.Now(),
+				PhotoID:  photo.ID,
+				AlbumID:  photo.AlbumID,
+				Field:    change.Field,
+				OldValue: change.OldValue,
+				NewValue: change.NewValue,
+			}); err != nil {
+				slog.Error("Error recording journal entry", "photo_id", photo.ID, "error", err)
+			}
+		}
+	}
+
+	imageVariants, err := sizeVariantPreference(config.SizeVariants, defaultSizeVariants)
+	if err != nil {
+		return fmt.Errorf("error in size_variants config: %v", err)
+	}
+	videoVariants, err := sizeVariantPreference(config.VideoSizeVariants, defaultVideoSizeVariants)
+	if err != nil {
+		return fmt.Errorf("error in video_size_variants config: %v", err)
+	}
+
+	pipeline, err := newTitlePipeline(config)
+	if err != nil {
+		return err
+	}
+
+	notifyClient := config.httpClient(30 * time.Second)
+	notifiers, err := newNotifiers(config.Notifications, notifyClient)
+	if err != nil {
+		return fmt.Errorf("error in notifications config: %v", err)
+	}
+	speciesNotifiers, err := newNotifiers(speciesNotifications(config.Notifications), notifyClient)
+	if err != nil {
+		return fmt.Errorf("error in notifications config: %v", err)
+	}
+	// wouldNotify holds the species a dry run would have notified about, as
+	// a dry run doesn't record them in the state
+	wouldNotify := make(map[string]bool)
+
+	var sightings *SightingCounts
+	if config.RareSpecies.enabled() {
+		if config.SightingsLog == "" {
+			return fmt.Errorf("rare_species notifications require a sightings_log")
+		}
+		sightings, err = loadSightingCounts(config.SightingsLog)
+		if err != nil {
+			return err
+		}
+	}
+
+	needsTitle, err := config.TitleMatch.matcher()
+	if err != nil {
+		return err
+	}
+	targets, err := config.targetFields()
+	if err != nil {
+		return err
+	}
+	if err := config.OverlayTime.validate(); err != nil {
+		return err
+	}
+	if config.OverlayData.Description && slices.Contains(targets, "description") {
+		return fmt.Errorf("overlay_data description can't be used with target %s", config.Target)
+	}
+	if err := checkDisambiguate(config.Disambiguate); err != nil {
+		return err
+	}
+	if config.Checklist.ScientificName == scientificNameDescription && (slices.Contains(targets, "description") || config.OverlayData.Description) {
+		return fmt.Errorf("checklist scientific_name description can't be used with a target or overlay_data that writes descriptions")
+	}
+
+	var hashes *HashIndex
+	if config.Duplicates.Enabled {
+		if err := config.Duplicates.validate(); err != nil {
+			return err
+		}
+		hashes = newHashIndex(state.PhotoHashes, config.Duplicates.maxDistance())
+	}
+
+	var cache *ocrCache
+	if config.CacheOCR {
+		cache = &ocrCache{entries: maps.Clone(state.OCRCache), settings: ocrSettings(config)}
+	}
+
+	var albumIDs []string
+	if opts.PhotoID != "" {
+		albumID, err := library.PhotoAlbumID(opts.PhotoID)
+		if err != nil {
+			return err
+		}
+		albumIDs = []string{albumID}
+	} else if opts.Album != "" {
+		albumIDs = []string{opts.Album}
+	} else {
+		if albumIDs, err = selectAlbums(config, library); err != nil {
+			return err
+		}
+		albumIDs = slices.DeleteFunc(slices.Clone(albumIDs), func(id string) bool {
+			return slices.Contains(opts.SkipAlbums, id)
+		})
+	}
+	if config.Lease.Enabled && opts.PhotoID == "" {
+		leases := opts.Leases
+		if leases == nil {
+			if leases, err = openLeases(config); err != nil {
+				return err
+			}
+			defer leases.Close()
+		}
+		if albumIDs, err = leases.Acquire(albumIDs); err != nil {
+			return err
+		}
+	}
+
+	// Settings that can differ between albums
+	albumActions := make(map[string]map[string]bool)
+	for _, albumID := range albumIDs {
+		albumActions[albumID], err = config.noTextActions(albumID, opts.Things)
+		if err != nil {
+			return err
+		}
+		if config.Albums[albumID].ReadOnly && !opts.DryRun {
+			slog.Info("Album is read-only; the database will not be updated", "album_id", albumID)
+		}
+	}
+
+	checkpoint, err := openCheckpoint(checkpointPath(config), opts.Resume)
+	if err != nil {
+		return err
+	}
+	defer checkpoint.Close()
+	if opts.Resume {
+		slog.Info("Resuming; skipping photos handled by the previous run", "count", len(checkpoint.Done))
+	}
+	var eventStream *eventStream
+	if opts.Events != "" {
+		if eventStream, err = openEventStream(opts.Events); err != nil {
+			return err
+		}
+		defer eventStream.Close()
+	}
+
+	// recordOutcome records a photo's outcome in the checkpoint, the report,
+	// and the event stream. newTitles holds the title each photo was (or
+	// would have been) given, detections its identification, and
+	// photoErrors its error, if any.
+	var reports []DetectionEvent
+	var photoErrors []PhotoError
+	newTitles := make(map[string]string)
+	detections := make(map[string]DetectionEvent)
+	recordOutcome := func(photo Photo, outcome string) {
+		if err := checkpoint.Record(runID, photo.ID, outcome); err != nil {
+			slog.Error("Error recording checkpoint", "photo_id", photo.ID, "error", err)
+		}
+		report, ok := detections[photo.ID]
+		if !ok {
+			report = newPhotoEvent(runID, photo)
+		}
+		report.Outcome = outcome
+		switch outcome {
+		case outcomeUpdated, outcomeIdentified:
+			report.Title = newTitles[photo.ID]
+		case outcomeError:
+			for _, err := range slices.Backward(photoErrors) {
+				if err.ID == photo.ID {
+					report.Error = err.Error
+					break
 				}
-			} else {
-				errors = append(errors, PhotoError{
-					ID:      photo.ID,
-					URL:     photo.ImageURL,
-					Error:   fmt.Sprintf("OCR error: %v", err),
-					WebLink: webLink,
-				})
 			}
-			continue
 		}
+		reports = append(reports, report)
+		if err := eventStream.Emit(report); err != nil {
+			slog.Error("Error writing event", "photo_id", photo.ID, "error", err)
+		}
+	}
 
-		log.Printf("Photo %s: %s", photo.ID, text)
+	// Query for photos. A photo in more than one of the albums is processed
+	// once, with the settings of the first album it's found in. Quarantined
+	// photos are skipped unless the quarantine album itself was selected.
+	var photos []Photo
+	foundIn := make(map[string]string)
+	for _, albumID := range albumIDs {
+		albumPhotos, err := library.Photos(albumID, imageVariants, videoVariants)
+		if err != nil {
+			return fmt.Errorf("error querying photos in album %s: %v", albumID, err)
+		}
+		for _, photo := range albumPhotos {
+			if _, ok := foundIn[photo.ID]; ok {
+				continue
+			}
+			if config.QuarantineAlbum != "" && photo.AlbumID == config.QuarantineAlbum && albumID != config.QuarantineAlbum {
+				continue
+			}
+			foundIn[photo.ID] = albumID
+			photos = append(photos, photo)
+		}
+	}
+	if opts.PhotoID != "" {
+		photos = slices.DeleteFunc(photos, func(photo Photo) bool { return photo.ID != opts.PhotoID })
+		if len(photos) == 0 {
+			return fmt.Errorf("photo %s has none of the configured size variants", opts.PhotoID)
+		}
+	}
+	noTextActions := func(photo Photo) map[string]bool {
+		return albumActions[foundIn[photo.ID]]
+	}
+	readOnly := func(photo Photo) bool {
+		return opts.DryRun || config.Albums[foundIn[photo.ID]].ReadOnly
+	}
 
-		// Update database if not in dry run mode
-		if !*dryRun {
-			updateQuery := "UPDATE photos SET title = ? WHERE id = ?"
-			_, err := db.Exec(updateQuery, text, photo.ID)
+	// Back up the titles of the photos this run may change before changing
+	// any of them
+	if config.BackupDir != "" {
+		var affected []Photo
+		for _, photo := range photos {
+			if !readOnly(photo) && (needsTitle(photo.Title) || opts.Retitle || opts.PhotoID != "") {
+				affected = append(affected, photo)
+			}
+		}
+		if len(affected) > 0 {
+			path, err := writeTitleBackup(config.BackupDir, runID, affected)
 			if err != nil {
-				errors = append(errors, PhotoError{
-					ID:      photo.ID,
-					URL:     photo.ImageURL,
+				return err
+			}
+			slog.Info("Backed up titles", "count", len(affected), "path", path)
+		}
+	}
+
+	// Delete photos that have had no text for long enough
+	deletedCount := 0
+	if config.NoTextDeleteAfterDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -config.NoTextDeleteAfterDays)
+		kept := photos[:0]
+		for _, photo := range photos {
+			since, ok := state.NoTextSince[photo.ID]
+			if !ok || !noTextActions(photo)[noTextDelete] || !needsTitle(photo.Title) || since.After(cutoff) {
+				kept = append(kept, photo)
+				continue
+			}
+			if readOnly(photo) {
+				slog.Info("Would delete photo without text", "photo_id", photo.ID, "no_text_since", since.Format(time.RFC3339))
+				kept = append(kept, photo)
+				continue
+			}
+			if err := library.DeletePhoto(photo.AlbumID, photo.ID); err != nil {
+				slog.Error("Error deleting photo", "photo_id", photo.ID, "error", err)
+				kept = append(kept, photo)
+				continue
+			}
+			slog.Info("Deleted photo without text", "photo_id", photo.ID, "no_text_since", since.Format(time.RFC3339))
+			recordChanges(photo, []fieldChange{{Field: "deleted", OldValue: photo.Title}})
+			delete(state.NoTextSince, photo.ID)
+			delete(state.NoTextPhotos, photo.ID)
+			delete(state.NoTextChecked, photo.ID)
+			deletedCount++
+		}
+		photos = kept
+		if deletedCount > 0 {
+			if err := saveState(config.StateFile, state); err != nil {
+				slog.Error("Error saving state", "error", err)
+			}
+		}
+	}
+
+	// The video halves of live photos get their titles from their stills
+	livePartners, livePaired := pairLivePhotos(photos, needsTitle)
+
+	// Titles already in each album, which new titles are kept distinct from
+	// with disambiguate
+	titles := make(albumTitles)
+	for _, photo := range photos {
+		titles.add(photo.AlbumID, photo.Title)
+	}
+
+	// Photos found to have no text before rechecking was configured are
+	// rechecked a full interval from now, rather than all at once
+	if config.NoTextRecheckAfterDays > 0 {
+		started := 0
+		for id := range state.NoTextPhotos {
+			if _, ok := state.NoTextChecked[id]; !ok {
+				state.NoTextChecked[id] = time.Now()
+				started++
+			}
+		}
+		if started > 0 {
+			if err := saveState(config.StateFile, state); err != nil {
+				slog.Error("Error saving state", "error", err)
+			}
+		}
+	}
+
+	// Select the photos that need titles
+	var candidates []Photo
+	oversizeCount := 0
+	for _, photo := range photos {
+		// A photo asked for by ID is processed whatever its title and the
+		// filters
+		wanted := opts.PhotoID != ""
+
+		// Skip photos that already have a title
+		if !needsTitle(photo.Title) && !wanted && !opts.Retitle {
+			continue
+		}
+
+		// With the description as the only target, titles aren't changed,
+		// so a description shows that a photo has been done
+		if config.Target == targetDescription && photo.Description != "" && !wanted && !opts.Retitle {
+			continue
+		}
+
+		if photo.IsVideo() && config.skipVideos() {
+			continue
+		}
+
+		if livePaired[photo.ID] {
+			continue
+		}
+
+		// Skip photos handled by the run being resumed
+		if checkpoint.Done[photo.ID] {
+			continue
+		}
+
+		// Skip photos on the ignore list
+		if config.Ignore.ignores(photo) && !wanted {
+			slog.Debug("Skipping photo ignored by config", "photo_id", photo.ID)
+			continue
+		}
+
+		// Skip photos that aren't starred or tagged as configured
+		if !config.Filter.matches(photo) && !wanted {
+			continue
+		}
+
+		// Skip files that are too large, before spending time downloading them
+		if reason := config.Download.oversizeReason(photo, config.Image); reason != "" {
+			slog.Info("Skipping photo", "photo_id", photo.ID, "reason", reason)
+			oversizeCount++
+			continue
+		}
+
+		// Skip if we've already processed this photo and found no text
+		if state.NoTextPhotos[photo.ID] && !opts.Force {
+			checked := state.NoTextChecked[photo.ID]
+			if !config.noTextRecheckDue(checked, time.Now()) {
+				slog.Debug("Skipping photo previously found to have no text", "photo_id", photo.ID)
+				continue
+			}
+			slog.Info("Rechecking photo", "photo_id", photo.ID, "no_text_found", checked.Format(time.DateOnly))
+		}
+		if failure := state.Failures[photo.ID]; config.MaxFailures > 0 && failure.Attempts >= config.MaxFailures && !opts.Force && !wanted {
+			slog.Debug("Skipping failing photo", "photo_id", photo.ID, "attempts", failure.Attempts, "last_error", failure.LastError)
+			continue
+		}
+		if title, ok := state.SentTitles[photo.ID]; ok && config.HTTPSink.URL != "" {
+			slog.Debug("Skipping photo whose title was already sent to http_sink", "photo_id", photo.ID, "title", title)
+			continue
+		}
+
+		// Check if we've reached the maximum number of images to process
+		if opts.MaxImages > 0 && len(candidates) >= opts.MaxImages {
+			slog.Info("Reached maximum number of images to process", "max_images", opts.MaxImages)
+			break
+		}
+
+		candidates = append(candidates, locatePhoto(config, photo))
+	}
+
+	photoCount := len(candidates)
+	processedCount := 0
+	updatedCount := 0
+	thingsCount := 0
+
+	// Title updates are queued and written update_batch_size at a time, in
+	// a single transaction; a photo's outcome is only recorded once its
+	// title has been written
+	type queuedTitle struct {
+		photo   Photo
+		title   string
+		species string        // the tag added with tag_species
+		extra   []fieldChange // changes to make along with the title
+		outcome bool          // whether to record the photo's outcome
+		// detection is the identification the title came from, if any
+		detection *DetectionEvent
+		sighting  bool // whether to record and notify about the sighting
+	}
+	var queued []queuedTitle
+
+	// recordSighting adds a sighting of species to the sightings log and
+	// sends its rare species and species notifications. Sightings of photos
+	// whose titles are written are recorded once the write succeeds.
+	recordSighting := func(photo Photo, species string, detection DetectionEvent) {
+		if config.SightingsLog != "" && !opts.DryRun {
+			if err := appendSighting(config.SightingsLog, detection); err != nil {
+				slog.Error("Error recording sighting", "photo_id", photo.ID, "error", err)
+			}
+		}
+
+		if sightings != nil {
+			previous := sightings.Count(species)
+			if previous < config.RareSpecies.threshold(species) {
+				message := rareSightingMessage(species, previous, sightings.Rarity(species), photo)
+				if opts.DryRun {
+					slog.Info("Would send rare species notification", "message", strings.ReplaceAll(message, "\n", "; "))
+				} else {
+					notifyAll(notifiers, "Lychee BB: rare visitor", message)
+				}
+			}
+			sightings.Add(species)
+		}
+
+		key := strings.TrimSpace(species)
+		if _, notified := state.NotifiedSpecies[key]; len(speciesNotifiers) > 0 && !notified && !wouldNotify[key] {
+			message := fmt.Sprintf("%s\nImage: %s\nWeb UI: %s", species, photo.ImageURL, photo.WebLink)
+			if opts.DryRun {
+				wouldNotify[key] = true
+				slog.Info("Would send species notification", "message", strings.ReplaceAll(message, "\n", "; "))
+			} else {
+				state.NotifiedSpecies[key] = time.Now()
+				notifyAll(speciesNotifiers, "Lychee BB: "+species, message)
+			}
+		}
+	}
+	flushTitles := func() {
+		if len(queued) == 0 {
+			return
+		}
+		batch := queued
+		queued = nil
+
+		if opts.Confirm {
+			fmt.Println()
+			for _, q := range batch {
+				fmt.Printf("  %s: %q -> %q\n", q.photo.ID, q.photo.Title, q.title)
+			}
+		}
+		if opts.Confirm && !confirm(fmt.Sprintf("Write %d titles?", len(batch))) {
+			slog.Info("Skipped writing titles", "count", len(batch))
+			for _, q := range batch {
+				if q.outcome {
+					recordOutcome(q.photo, outcomeIdentified)
+				}
+			}
+			return
+		}
+
+		updates := make([]photoUpdate, len(batch))
+		for i, q := range batch {
+			updates[i] = photoUpdate{
+				PhotoID:   q.photo.ID,
+				Changes:   append(targetChanges(targets, q.photo, q.title, q.species), q.extra...),
+				Detection: q.detection,
+			}
+		}
+		_, updateSpan := startSpan(ctx, "update_photos", slog.Int("photos", len(updates)))
+		written, err := library.UpdatePhotos(updates)
+		if err != nil {
+			updateSpan.SetError(err.Error())
+		}
+		updateSpan.End()
+		for i, q := range batch {
+			if i >= written {
+				photoErrors = append(photoErrors, PhotoError{
+					ID:      q.photo.ID,
+					URL:     q.photo.ImageURL,
 					Error:   fmt.Sprintf("Error updating database: %v", err),
-					WebLink: webLink,
+					WebLink: q.photo.WebLink,
 				})
+				if q.outcome {
+					recordOutcome(q.photo, outcomeError)
+				}
 				continue
 			}
 			updatedCount++
-			log.Printf("Updated photo %s with new title: %s", photo.ID, text)
+			if config.Target == targetDescription {
+				slog.Info("Updated photo description", "photo_id", q.photo.ID, "description", q.title)
+			} else {
+				slog.Info("Updated photo title", "photo_id", q.photo.ID, "title", q.title)
+			}
+			for _, change := range q.extra {
+				slog.Info("Updated photo", "photo_id", q.photo.ID, "field", change.Field, "old", change.OldValue, "new", change.NewValue)
+			}
+			recordChanges(q.photo, updates[i].Changes)
+			if config.HTTPSink.URL != "" {
+				state.SentTitles[q.photo.ID] = q.title
+			}
+			if q.outcome {
+				recordOutcome(q.photo, outcomeUpdated)
+			}
+			if q.sighting {
+				recordSighting(q.photo, q.species, *q.detection)
+			}
+		}
+	}
+	writeTitle := func(q queuedTitle) {
+		queued = append(queued, q)
+		if len(queued) >= max(config.UpdateBatchSize, 1) {
+			flushTitles()
+		}
+	}
+
+	// writeLivePartners gives the video halves of a live photo the title of
+	// its still
+	writeLivePartners := func(still Photo, title, species string) {
+		for _, video := range livePartners[still.ID] {
+			video = locatePhoto(config, video)
+			slog.Info("Identified photo", "photo_id", video.ID, "title", title, "source", "live_photo", "still_id", still.ID)
+			if readOnly(video) {
+				continue
+			}
+			writeTitle(queuedTitle{photo: video, title: title, species: species})
+		}
+	}
+
+	// Stills titled by earlier runs (or by hand) pass their titles on now
+	for _, photo := range photos {
+		if len(livePartners[photo.ID]) == 0 {
+			continue
+		}
+		if config.Target == targetDescription {
+			if photo.Description != "" {
+				writeLivePartners(photo, photo.Description, photo.Description)
+			}
+		} else if !needsTitle(photo.Title) {
+			writeLivePartners(photo, photo.Title, photo.Title)
+		}
+	}
+
+	// Download and OCR photos concurrently, but handle the results (and all
+	// database and state writes) one at a time here
+	runCtx := ctx
+	if opts.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithDeadline(ctx, started.Add(opts.MaxRuntime))
+		defer cancel()
+	}
+
+	// On SIGINT or SIGTERM, stop starting new photos but let the ones in
+	// progress finish, so the run's results are still recorded and reported
+	dispatchCtx, stopDispatch := context.WithCancel(runCtx)
+	defer stopDispatch()
+	var interrupted atomic.Bool
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Info("Finishing photos in progress (send the signal again to quit immediately)", "signal", sig.String())
+			interrupted.Store(true)
+			stopDispatch()
+			signal.Stop(signals)
+		case <-dispatchCtx.Done():
+		}
+	}()
+
+	opts.Progress.Start(photoCount)
+	handledCount := 0
+	ocrRequests := 0
+	var downloadMS, ocrMS int64
+	for outcome := range analyzePhotos(runCtx, dispatchCtx.Done(), config, downloader, ocr, hashes, cache, candidates, opts) {
+		photo, result := outcome.Photo, outcome.Result
+		webLink := photo.WebLink
+
+		// Photos interrupted by the end of the run are left for the next one
+		if result.Error != "" && runCtx.Err() != nil {
+			continue
+		}
+		handledCount++
+		opts.Progress.Update(handledCount, photo.ID)
+
+		if result.Source != sourceBurst && result.Source != sourceDuplicate && !result.Cached {
+			slog.Debug("Processed photo", "photo_id", photo.ID, "bytes", result.Timings.Bytes,
+				"download_ms", result.Timings.DownloadMS, "ocr_ms", result.Timings.OCRMS, "total_ms", result.Timings.TotalMS)
+		}
+
+		if result.Processed {
+			processedCount++
+		}
+		ocrRequests += result.OCRRequests
+		downloadMS += result.Timings.DownloadMS
+		ocrMS += result.Timings.OCRMS
+
+		if result.Error != "" {
+			photoErrors = append(photoErrors, PhotoError{
+				ID:      photo.ID,
+				URL:     photo.ImageURL,
+				Error:   result.Error,
+				WebLink: webLink,
+			})
+			recordOutcome(photo, outcomeError)
+
+			failure := state.Failures[photo.ID]
+			failure.Attempts++
+			failure.LastError = result.Error
+			state.Failures[photo.ID] = failure
+			if config.MaxFailures > 0 && failure.Attempts == config.MaxFailures {
+				slog.Warn("Photo has failed too often; skipping it on future runs", "photo_id", photo.ID, "attempts", failure.Attempts)
+			}
+			if err := saveState(config.StateFile, state); err != nil {
+				slog.Error("Error saving state", "error", err)
+			}
+			continue
+		}
+
+		// Only consecutive failures count toward max_failures
+		if _, ok := state.Failures[photo.ID]; ok {
+			delete(state.Failures, photo.ID)
+			if err := saveState(config.StateFile, state); err != nil {
+				slog.Error("Error saving state", "error", err)
+			}
+		}
+
+		// Keep the text OCR found, so that later runs can reuse it
+		if cache != nil && result.Source == sourceOCR && !result.Cached {
+			state.OCRCache[photo.ID] = CachedOCR{Text: result.Text, Settings: cache.settings}
+			if err := saveState(config.StateFile, state); err != nil {
+				slog.Error("Error saving state", "error", err)
+			}
+		}
+
+		// Turn the text into a title, along with any other changes to make
+		titled := pipeline.apply(photo, &result, !readOnly(photo))
+		text, title, needsReview, extraChanges := titled.Species, titled.Title, titled.NeedsReview, titled.Changes
+		if slices.Contains(targets, "title") && !result.NoText && !needsReview {
+			title = titles.disambiguate(config.Disambiguate, photo, title)
+			titles.add(photo.AlbumID, title)
+		}
+		if result.Source == sourceDuplicate && config.Duplicates.Action == duplicateFlag {
+			slog.Info("Duplicate photo; flagging for review", "photo_id", photo.ID, "duplicate_of", result.DuplicateOf)
+			needsReview = true
 		}
+
+		if result.NoText || needsReview {
+			stateChanged := false
+			if noTextActions(photo)[noTextState] {
+				// Skip the photo on future runs
+				state.NoTextPhotos[photo.ID] = true
+				state.NoTextChecked[photo.ID] = time.Now()
+				stateChanged = true
+			}
+			if noTextActions(photo)[noTextDelete] && result.NoText {
+				if _, ok := state.NoTextSince[photo.ID]; !ok {
+					state.NoTextSince[photo.ID] = time.Now()
+					stateChanged = true
+				}
+			}
+			if stateChanged {
+				if err := saveState(config.StateFile, state); err != nil {
+					slog.Error("Error saving state", "error", err)
+				}
+			}
+
+			if noTextActions(photo)[noTextTag] {
+				tag := config.noTextTag()
+				if readOnly(photo) {
+					slog.Info("Would tag photo", "photo_id", photo.ID, "tag", tag)
+				} else {
+					changes := []fieldChange{
+						{Field: "tags", OldValue: strings.Join(photo.Tags, ","), NewValue: addTag(photo.Tags, tag)},
+					}
+					if err := library.UpdatePhoto(photo.ID, changes); err != nil {
+						slog.Error("Error tagging photo", "photo_id", photo.ID, "error", err)
+					} else {
+						recordChanges(photo, changes)
+					}
+				}
+			}
+			if noTextActions(photo)[noTextQuarantine] && photo.AlbumID != config.QuarantineAlbum {
+				if readOnly(photo) {
+					slog.Info("Would move photo to quarantine album", "photo_id", photo.ID, "album_id", config.QuarantineAlbum)
+				} else if err := library.MovePhoto(photo.ID, photo.AlbumID, config.QuarantineAlbum); err != nil {
+					slog.Error("Error moving photo to quarantine album", "photo_id", photo.ID, "error", err)
+				} else {
+					slog.Info("Moved photo to quarantine album", "photo_id", photo.ID, "album_id", config.QuarantineAlbum)
+					recordChanges(photo, []fieldChange{
+						{Field: "album_id", OldValue: photo.AlbumID, NewValue: config.QuarantineAlbum},
+					})
+				}
+			}
+
+			notes := fmt.Sprintf("Image: %s\nWeb UI: %s", photo.ImageURL, webLink)
+			if result.Source == sourceDuplicate {
+				notes += fmt.Sprintf("\nDuplicate of photo %s, titled: %s", result.DuplicateOf, strings.TrimSpace(text))
+			} else if needsReview {
+				notes += fmt.Sprintf("\nOCR text: %s", strings.TrimSpace(text))
+			}
+			if noTextActions(photo)[noTextTask] {
+				createReviewTask(photo, notes, opts.DryRun)
+				thingsCount++
+			}
+			if noTextActions(photo)[noTextNotify] {
+				if opts.DryRun {
+					slog.Info("Would send notification", "photo_id", photo.ID, "message", strings.ReplaceAll(notes, "\n", "; "))
+				} else {
+					notifyAll(notifiers, fmt.Sprintf("Lychee BB: review photo %s", photo.ID), notes)
+				}
+			}
+			if needsReview {
+				recordOutcome(photo, outcomeReview)
+			} else {
+				recordOutcome(photo, outcomeNoText)
+			}
+			continue
+		}
+
+		// A photo retried with -force, or rechecked, may have text after all
+		if _, ok := state.NoTextSince[photo.ID]; (ok || state.NoTextPhotos[photo.ID]) && !opts.DryRun {
+			delete(state.NoTextPhotos, photo.ID)
+			delete(state.NoTextSince, photo.ID)
+			delete(state.NoTextChecked, photo.ID)
+			if err := saveState(config.StateFile, state); err != nil {
+				slog.Error("Error saving state", "error", err)
+			}
+		}
+
+		switch result.Source {
+		case sourceBirdNET:
+			slog.Info("Identified photo", "photo_id", photo.ID, "title", text, "source", "birdnet", "confidence", result.Confidence)
+		case sourceBurst:
+			slog.Info("Identified photo", "photo_id", photo.ID, "title", text, "source", "burst")
+		case sourceDuplicate:
+			slog.Info("Identified photo", "photo_id", photo.ID, "title", text, "source", "duplicate", "duplicate_of", result.DuplicateOf)
+		default:
+			if result.Cached {
+				slog.Info("Identified photo", "photo_id", photo.ID, "title", text, "source", "ocr_cache")
+			} else {
+				slog.Info("Identified photo", "photo_id", photo.ID, "title", text, "source", "ocr")
+			}
+		}
+
+		if result.Hashed && result.Source != sourceDuplicate {
+			state.PhotoHashes[photo.ID] = hashes.Add(photo.ID, result.Hash, text)
+		}
+
+		// A duplicate is the same sighting as the photo it duplicates
+		detection := newDetectionEvent(runID, photo.AlbumID, photo, text, result)
+		detections[photo.ID] = detection
+		sighting := result.Source != sourceDuplicate
+
+		// Update database if not in dry run mode and the album is writable
+		newTitles[photo.ID] = title
+		if !readOnly(photo) {
+			writeTitle(queuedTitle{photo: photo, title: title, species: text, extra: extraChanges, outcome: true, detection: &detection, sighting: sighting})
+		} else {
+			recordOutcome(photo, outcomeIdentified)
+			if sighting {
+				recordSighting(photo, text, detection)
+			}
+		}
+		writeLivePartners(photo, title, text)
+	}
+	flushTitles()
+	opts.Progress.Finish()
+
+	summary := RunSummary{
+		RunID:       runID,
+		StartedAt:   started,
+		FinishedAt:  time.Now(),
+		DryRun:      opts.DryRun,
+		Found:       photoCount,
+		Processed:   processedCount,
+		Updated:     updatedCount,
+		ReviewTasks: thingsCount,
+		Oversized:   oversizeCount,
+		Errors:      len(photoErrors),
+		Remaining:   photoCount - handledCount,
+		Deleted:     deletedCount,
+	}
+	finished = &summary
+	if runCtx.Err() == context.DeadlineExceeded {
+		slog.Warn("Reached maximum runtime; leaving photos for the next run", "max_runtime", opts.MaxRuntime, "remaining", summary.Remaining)
+	} else if interrupted.Load() {
+		slog.Warn("Interrupted; leaving photos for the next run", "remaining", summary.Remaining)
+	}
+	if summary.Remaining > 0 {
+		slog.Info("Run with -resume to continue where this run left off")
+	} else if err := checkpoint.Remove(); err != nil {
+		slog.Error("Error removing checkpoint", "error", err)
+	}
+	if opts.textOutput() {
+		fmt.Printf("Summary: %s\n", summary)
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Fatalf("Error iterating rows: %v", err)
+	located := make(map[string]Photo, len(candidates))
+	for _, photo := range candidates {
+		located[photo.ID] = photo
+	}
+	if opts.DryRun && opts.textOutput() {
+		printDiffTable(os.Stdout, changeRows(reports, located, config.Target), opts.Color)
+	}
+
+	state.LastRunSummary = &summary
+	if err := saveState(config.StateFile, state); err != nil {
+		slog.Error("Error saving state", "error", err)
+	}
+
+	metrics.Record(summary, ocrRequests, downloadMS, ocrMS)
+	if config.Metrics.PushgatewayURL != "" {
+		if err := pushMetrics(config.Metrics, config.httpClient(30*time.Second)); err != nil {
+			slog.Error("Error pushing metrics", "error", err)
+		}
 	}
 
-	fmt.Printf("Summary: Found %d photos, processed %d photos, updated %d photos, created %d review tasks\n",
-		photoCount, processedCount, updatedCount, thingsCount)
+	// Only notify about runs that had something to do
+	if photoCount > 0 {
+		title := "Lychee BB run complete"
+		if opts.DryRun {
+			title += " (dry run)"
+		}
+		notifyAll(notifiers, title, summary.String())
+	}
 
-	if len(errors) > 0 {
-		fmt.Printf("\nErrors encountered (%d):\n", len(errors))
-		for _, err := range errors {
+	if opts.Output == outputJSON {
+		if err := writeRunReport(opts.OutputFile, RunReport{Summary: summary, Photos: reports, Errors: photoErrors}); err != nil {
+			slog.Error("Error writing JSON report", "error", err)
+		}
+	}
+	if opts.Report != "" {
+		if err := writeChangeReport(opts.Report, runID, summary, changeRows(reports, located, config.Target)); err != nil {
+			slog.Error("Error writing change report", "file", opts.Report, "error", err)
+		}
+	}
+
+	if len(photoErrors) > 0 && opts.textOutput() {
+		fmt.Printf("\nErrors encountered (%d):\n", len(photoErrors))
+		for _, err := range photoErrors {
 			fmt.Printf("\nPhoto ID: %s\n", err.ID)
 			fmt.Printf("\tImage URL: %s\n", err.URL)
 			fmt.Printf("\tWeb UI: %s\n", err.WebLink)
 			fmt.Printf("\tError: %s\n", err.Error)
 		}
 	}
+
+	if interrupted.Load() {
+		return errInterrupted
+	}
+	return nil
+}
+
+// stdin is shared by every confirm, so that input buffered by one isn't lost
+// to the next.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question on the terminal, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := stdin.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
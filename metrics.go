@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsConfig configures pushing run metrics to a Prometheus Pushgateway.
+// In daemon mode, the metrics can instead be scraped from /metrics on
+// status_addr.
+type MetricsConfig struct {
+	// PushgatewayURL is the Pushgateway's base URL, e.g.
+	// http://pushgateway:9091. Metrics are pushed at the end of each run.
+	PushgatewayURL string `json:"pushgateway_url"`
+	// Job is the job label the metrics are pushed under (default
+	// lychee-birb-title).
+	Job string `json:"job"`
+}
+
+const metricsPrefix = "lychee_birb_title_"
+
+// runMetrics accumulates the metrics of every run the process makes.
+type runMetrics struct {
+	mu sync.Mutex
+
+	runs            int
+	found           int
+	processed       int
+	updated         int
+	reviewTasks     int
+	errors          int
+	ocrRequests     int
+	downloadSeconds float64
+	ocrSeconds      float64
+
+	lastRun        time.Time
+	lastRunSummary RunSummary
+	lastRunOCR     int
+	lastDownload   float64
+	lastOCR        float64
+	lastSuccess    time.Time
+}
+
+// metrics holds the process's run metrics.
+var metrics runMetrics
+
+// Record adds a finished run to the metrics. A run without errors is a
+// success.
+func (m *runMetrics) Record(summary RunSummary, ocrRequests int, downloadMS, ocrMS int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs++
+	m.found += summary.Found
+	m.processed += summary.Processed
+	m.updated += summary.Updated
+	m.reviewTasks += summary.ReviewTasks
+	m.errors += summary.Errors
+	m.ocrRequests += ocrRequests
+	m.downloadSeconds += float64(downloadMS) / 1000
+	m.ocrSeconds += float64(ocrMS) / 1000
+
+	m.lastRun = summary.FinishedAt
+	m.lastRunSummary = summary
+	m.lastRunOCR = ocrRequests
+	m.lastDownload = float64(downloadMS) / 1000
+	m.lastOCR = float64(ocrMS) / 1000
+	if summary.Errors == 0 {
+		m.lastSuccess = summary.FinishedAt
+	}
+}
+
+// Write writes the metrics in the Prometheus text exposition format, for
+// /metrics: counters covering every run the process has made, and gauges of
+// the last run.
+func (m *runMetrics) Write(w io.Writer) error {
+	return m.write(w, true)
+}
+
+// write writes the metrics, leaving out the counters unless counters is set.
+// The Pushgateway keeps whatever was pushed last, so a counter pushed by a
+// process making one run (from cron, say) would never count past that run.
+func (m *runMetrics) write(w io.Writer, counters bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s%s %s\n", metricsPrefix, name, help)
+		fmt.Fprintf(&b, "# TYPE %s%s %s\n", metricsPrefix, name, kind)
+		fmt.Fprintf(&b, "%s%s %g\n", metricsPrefix, name, value)
+	}
+	if counters {
+		metric("runs_total", "counter", "Runs completed.", float64(m.runs))
+		metric("photos_found_total", "counter", "Photos found needing a title.", float64(m.found))
+		metric("photos_processed_total", "counter", "Photos sent for OCR.", float64(m.processed))
+		metric("photos_updated_total", "counter", "Photos whose title was updated.", float64(m.updated))
+		metric("review_tasks_total", "counter", "Review tasks created.", float64(m.reviewTasks))
+		metric("errors_total", "counter", "Photos that failed.", float64(m.errors))
+		metric("ocr_requests_total", "counter", "Requests made to the OCR provider.", float64(m.ocrRequests))
+		metric("download_seconds_total", "counter", "Time spent downloading photos.", m.downloadSeconds)
+		metric("ocr_seconds_total", "counter", "Time spent waiting for OCR.", m.ocrSeconds)
+	}
+	if !m.lastRun.IsZero() {
+		last := m.lastRunSummary
+		metric("last_run_timestamp_seconds", "gauge", "When the last run finished.", float64(m.lastRun.Unix()))
+		metric("last_run_duration_seconds", "gauge", "How long the last run took.", last.FinishedAt.Sub(last.StartedAt).Seconds())
+		metric("last_run_photos_found", "gauge", "Photos found needing a title by the last run.", float64(last.Found))
+		metric("last_run_photos_processed", "gauge", "Photos sent for OCR by the last run.", float64(last.Processed))
+		metric("last_run_photos_updated", "gauge", "Photos updated by the last run.", float64(last.Updated))
+		metric("last_run_review_tasks", "gauge", "Review tasks created by the last run.", float64(last.ReviewTasks))
+		metric("last_run_errors", "gauge", "Photos that failed in the last run.", float64(last.Errors))
+		metric("last_run_ocr_requests", "gauge", "Requests made to the OCR provider by the last run.", float64(m.lastRunOCR))
+		metric("last_run_download_seconds", "gauge", "Time the last run spent downloading photos.", m.lastDownload)
+		metric("last_run_ocr_seconds", "gauge", "Time the last run spent waiting for OCR.", m.lastOCR)
+	}
+	if !m.lastSuccess.IsZero() {
+		metric("last_success_timestamp_seconds", "gauge", "When the last run without errors finished.", float64(m.lastSuccess.Unix()))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// pushMetrics pushes the last run's gauges to the Pushgateway. It POSTs them,
+// which replaces only the metrics pushed, so last_success_timestamp_seconds
+// keeps its value from an earlier push after a run with errors.
+func pushMetrics(config MetricsConfig, client *http.Client) error {
+	job := config.Job
+	if job == "" {
+		job = "lychee-birb-title"
+	}
+	var body bytes.Buffer
+	if err := metrics.write(&body, false); err != nil {
+		return fmt.Errorf("error writing metrics: %v", err)
+	}
+
+	pushURL := fmt.Sprintf("%s/metrics/job/%s", strings.TrimRight(config.PushgatewayURL, "/"), url.PathEscape(job))
+	req, err := http.NewRequest(http.MethodPost, pushURL, &body)
+	if err != nil {
+		return fmt.Errorf("error creating Pushgateway request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error pushing metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("error pushing metrics: Pushgateway returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MultiSpeciesConfig sets how OCR text with several lines or comma-separated
+// names is made into a title.
+type MultiSpeciesConfig struct {
+	// Strategy is one of:
+	//   - "" (the default): use the text as it is
+	//   - first: use the first line or name
+	//   - join: join the lines and names with Separator
+	//   - checklist: use the first line or name on the checklist, or the
+	//     first if none are
+	Strategy string `json:"strategy"`
+	// Separator joins names with the join strategy (default ", ").
+	Separator string `json:"separator"`
+}
+
+const (
+	multiSpeciesFirst     = "first"
+	multiSpeciesJoin      = "join"
+	multiSpeciesChecklist = "checklist"
+
+	defaultMultiSpeciesSeparator = ", "
+)
+
+func (c MultiSpeciesConfig) validate(checklist Checklist) error {
+	switch c.Strategy {
+	case "", multiSpeciesFirst, multiSpeciesJoin:
+		return nil
+	case multiSpeciesChecklist:
+		if checklist == nil {
+			return fmt.Errorf("multi_species strategy checklist requires a checklist file")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown multi_species strategy %q (expected first, join, or checklist)", c.Strategy)
+	}
+}
+
+// choose applies the strategy to text. known reports whether a name is on
+// the checklist.
+func (c MultiSpeciesConfig) choose(text string, known func(string) bool) string {
+	if c.Strategy == "" {
+		return text
+	}
+	var parts []string
+	for _, line := range strings.Split(text, "\n") {
+		for _, part := range strings.Split(line, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return text
+	}
+
+	switch c.Strategy {
+	case multiSpeciesJoin:
+		separator := c.Separator
+		if separator == "" {
+			separator = defaultMultiSpeciesSeparator
+		}
+		return strings.Join(parts, separator)
+	case multiSpeciesChecklist:
+		for _, part := range parts {
+			if known(part) {
+				return part
+			}
+		}
+	}
+	return parts[0]
+}
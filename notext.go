@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Actions that can be taken for photos with no text, or whose text is
+// ambiguous (see aliasReview).
+const (
+	noTextIgnore = "ignore" // do nothing; the photo is checked again next run
+	noTextState  = "state"  // record the photo in the state file so it's skipped from now on
+	noTextTag    = "tag"    // add a tag to the photo in Lychee
+	noTextTask   = "task"   // create a Things task to review the photo
+	noTextNotify = "notify" // send a notification about the photo
+	noTextDelete = "delete" // delete the photo once it's been without text for a while
+
+	noTextQuarantine = "quarantine" // move the photo into the quarantine album
+)
+
+// NoTextConfig sets the policy for photos with no text. Its fields appear at
+// the top level of the config file.
+type NoTextConfig struct {
+	NoTextAction []string `json:"no_text_action"`
+	// NoTextTag is the tag added by the tag action (default "needs-review").
+	NoTextTag string `json:"no_text_tag"`
+	// NoTextDeleteAfterDays is how long a photo must have had no text
+	// before the delete action removes it.
+	NoTextDeleteAfterDays int `json:"no_text_delete_after_days"`
+	// NoTextRecheckAfterDays is how long photos recorded by the state action
+	// are skipped before they're OCRed again (0 to skip them forever).
+	NoTextRecheckAfterDays int `json:"no_text_recheck_after_days"`
+	// QuarantineAlbum is the album the quarantine action moves photos into.
+	// Photos in it aren't processed unless it's selected explicitly.
+	QuarantineAlbum string `json:"quarantine_album"`
+}
+
+var noTextActionNames = []string{noTextIgnore, noTextState, noTextTag, noTextTask, noTextNotify, noTextDelete, noTextQuarantine}
+
+const defaultNoTextTag = "needs-review"
+
+// noTextActions returns the set of actions to take for photos with no text
+// in the given album. An album's no_text_action overrides the global one;
+// if neither is set, the -things flag selects state and task, as it always
+// has.
+func (c *Config) noTextActions(albumID string, things bool) (map[string]bool, error) {
+	names := c.NoTextAction
+	if album, ok := c.Albums[albumID]; ok && album.NoTextAction != nil {
+		names = album.NoTextAction
+	}
+	if names == nil && things {
+		names = []string{noTextState, noTextTask}
+	}
+
+	actions := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if !slices.Contains(noTextActionNames, name) {
+			return nil, fmt.Errorf("unknown no_text_action %q (expected one of %s)", name, strings.Join(noTextActionNames, ", "))
+		}
+		if name != noTextIgnore {
+			actions[name] = true
+		}
+	}
+	if actions[noTextDelete] && c.NoTextDeleteAfterDays <= 0 {
+		return nil, fmt.Errorf("no_text_action delete requires no_text_delete_after_days")
+	}
+	if actions[noTextQuarantine] && c.QuarantineAlbum == "" {
+		return nil, fmt.Errorf("no_text_action quarantine requires quarantine_album")
+	}
+	return actions, nil
+}
+
+// noTextRecheckDue reports whether a photo last found to have no text at
+// checked should be OCRed again.
+func (c *Config) noTextRecheckDue(checked, now time.Time) bool {
+	if c.NoTextRecheckAfterDays <= 0 || checked.IsZero() {
+		return false
+	}
+	return !checked.After(now.AddDate(0, 0, -c.NoTextRecheckAfterDays))
+}
+
+func (c *Config) noTextTag() string {
+	if c.NoTextTag != "" {
+		return c.NoTextTag
+	}
+	return defaultNoTextTag
+}
+
+// addTag returns tags with tag added, in Lychee's comma-separated format.
+func addTag(tags []string, tag string) string {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return strings.Join(tags, ",")
+		}
+	}
+	return strings.Join(append(slices.Clone(tags), tag), ",")
+}
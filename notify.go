@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NotifierConfig configures a destination for run summary notifications.
+type NotifierConfig struct {
+	// Type is "gotify", "matrix", "ntfy", or "pushover".
+	Type string `json:"type"`
+	// URL is the Gotify server, Matrix homeserver, or ntfy server base URL
+	// (ntfy defaults to https://ntfy.sh, and Pushover needs none).
+	URL string `json:"url"`
+	// Token is the Gotify or Pushover application token, Matrix access
+	// token, or ntfy access token (optional for public topics).
+	Token string `json:"token"`
+	// RoomID is the Matrix room to post messages to.
+	RoomID string `json:"room_id"`
+	// Topic is the ntfy topic to publish to.
+	Topic string `json:"topic"`
+	// User is the Pushover user or group key to send to.
+	User string `json:"user"`
+	// Priority is the Gotify, ntfy, or Pushover message priority.
+	Priority int `json:"priority"`
+	// Species also sends a notification the first time photos are titled
+	// with each species, as well as the run summary.
+	Species bool `json:"species"`
+}
+
+const (
+	defaultNtfyURL = "https://ntfy.sh"
+	pushoverURL    = "https://api.pushover.net/1/messages.json"
+)
+
+// Notifier sends a short message to a person.
+type Notifier interface {
+	Notify(title, message string) error
+}
+
+func newNotifiers(configs []NotifierConfig, client *http.Client) ([]Notifier, error) {
+	var notifiers []Notifier
+	for _, c := range configs {
+		baseURL := strings.TrimRight(c.URL, "/")
+
+		switch strings.ToLower(c.Type) {
+		case "gotify", "matrix":
+			if c.URL == "" || c.Token == "" {
+				return nil, fmt.Errorf("%s notifier requires a url and token", c.Type)
+			}
+		case "ntfy":
+			if c.Topic == "" {
+				return nil, fmt.Errorf("ntfy notifier requires a topic")
+			}
+			if baseURL == "" {
+				baseURL = defaultNtfyURL
+			}
+		case "pushover":
+			if c.Token == "" || c.User == "" {
+				return nil, fmt.Errorf("pushover notifier requires a token and user")
+			}
+		}
+
+		switch strings.ToLower(c.Type) {
+		case "gotify":
+			notifiers = append(notifiers, &gotifyNotifier{
+				url:      baseURL,
+				token:    c.Token,
+				priority: c.Priority,
+				client:   client,
+			})
+		case "matrix":
+			if c.RoomID == "" {
+				return nil, fmt.Errorf("matrix notifier requires a room_id")
+			}
+			notifiers = append(notifiers, &matrixNotifier{
+				homeserver: baseURL,
+				token:      c.Token,
+				roomID:     c.RoomID,
+				client:     client,
+			})
+		case "ntfy":
+			notifiers = append(notifiers, &ntfyNotifier{
+				url:      baseURL,
+				token:    c.Token,
+				topic:    c.Topic,
+				priority: c.Priority,
+				client:   client,
+			})
+		case "pushover":
+			notifiers = append(notifiers, &pushoverNotifier{
+				token:    c.Token,
+				user:     c.User,
+				priority: c.Priority,
+				client:   client,
+			})
+		default:
+			return nil, fmt.Errorf("unsupported notifier type: %s", c.Type)
+		}
+	}
+	return notifiers, nil
+}
+
+// speciesNotifications returns the notifier configs that also send a
+// notification for each species.
+func speciesNotifications(configs []NotifierConfig) []NotifierConfig {
+	var species []NotifierConfig
+	for _, c := range configs {
+		if c.Species {
+			species = append(species, c)
+		}
+	}
+	return species
+}
+
+// notifyAll sends the message to every notifier, logging any failures.
+func notifyAll(notifiers []Notifier, title, message string) {
+	for _, n := range notifiers {
+		if err := n.Notify(title, message); err != nil {
+			slog.Error("Error sending notification", "error", err)
+		}
+	}
+}
+
+// sendJSON sends payload as a JSON request body and checks for a 2xx response.
+func sendJSON(client *http.Client, method, url string, headers map[string]string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding notification: %v", err)
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating notification request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending notification: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bad status from notification endpoint: %s", resp.Status)
+	}
+	return nil
+}
+
+// gotifyNotifier posts messages to a Gotify server.
+type gotifyNotifier struct {
+	url      string
+	token    string
+	priority int
+	client   *http.Client
+}
+
+func (n *gotifyNotifier) Notify(title, message string) error {
+	return sendJSON(n.client, http.MethodPost, n.url+"/message",
+		map[string]string{"X-Gotify-Key": n.token},
+		map[string]any{
+			"title":    title,
+			"message":  message,
+			"priority": n.priority,
+		})
+}
+
+// matrixNotifier posts messages to a Matrix room.
+type matrixNotifier struct {
+	homeserver string
+	token      string
+	roomID     string
+	client     *http.Client
+}
+
+func (n *matrixNotifier) Notify(title, message string) error {
+	txnID := fmt.Sprintf("lychee-birb-title-%d", time.Now().UnixNano())
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		n.homeserver, url.PathEscape(n.roomID), txnID)
+	return sendJSON(n.client, http.MethodPut, endpoint,
+		map[string]string{"Authorization": "Bearer " + n.token},
+		map[string]string{
+			"msgtype": "m.text",
+			"body":    title + "\n" + message,
+		})
+}
+
+// ntfyNotifier publishes messages to an ntfy topic.
+type ntfyNotifier struct {
+	url      string
+	token    string
+	topic    string
+	priority int
+	client   *http.Client
+}
+
+func (n *ntfyNotifier) Notify(title, message string) error {
+	var headers map[string]string
+	if n.token != "" {
+		headers = map[string]string{"Authorization": "Bearer " + n.token}
+	}
+	payload := map[string]any{
+		"topic":   n.topic,
+		"title":   title,
+		"message": message,
+	}
+	if n.priority != 0 {
+		payload["priority"] = n.priority
+	}
+	return sendJSON(n.client, http.MethodPost, n.url, headers, payload)
+}
+
+// pushoverNotifier sends messages to a Pushover user or group.
+type pushoverNotifier struct {
+	token    string
+	user     string
+	priority int
+	client   *http.Client
+}
+
+func (n *pushoverNotifier) Notify(title, message string) error {
+	return sendJSON(n.client, http.MethodPost, pushoverURL, nil,
+		map[string]any{
+			"token":    n.token,
+			"user":     n.user,
+			"title":    title,
+			"message":  message,
+			"priority": n.priority,
+		})
+}
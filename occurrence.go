@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// OccurrenceConfig describes where and how sightings were recorded, for
+// exporting them as Darwin Core occurrences.
+type OccurrenceConfig struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// CoordinateUncertaintyMeters is how precisely Latitude and Longitude
+	// locate the camera; use a large value to obscure your location.
+	CoordinateUncertaintyMeters float64 `json:"coordinate_uncertainty_meters"`
+	RecordedBy                  string  `json:"recorded_by"`
+	// BasisOfRecord defaults to "MachineObservation".
+	BasisOfRecord string `json:"basis_of_record"`
+}
+
+var darwinCoreColumns = []string{
+	"occurrenceID",
+	"basisOfRecord",
+	"eventDate",
+	"vernacularName",
+	"decimalLatitude",
+	"decimalLongitude",
+	"coordinateUncertaintyInMeters",
+	"geodeticDatum",
+	"recordedBy",
+	"identificationRemarks",
+}
+
+// readSightings reads the detection events in the sightings log, keeping only
+// the latest event for each photo.
+func readSightings(path string) ([]DetectionEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sightings log: %v", err)
+	}
+	defer file.Close()
+
+	var events []DetectionEvent
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event DetectionEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("error decoding sightings log: %v", err)
+		}
+		if i, ok := index[event.PhotoID]; ok {
+			events[i] = event
+			continue
+		}
+		index[event.PhotoID] = len(events)
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading sightings log: %v", err)
+	}
+	return events, nil
+}
+
+// writeOccurrences writes events as Darwin Core occurrence records in CSV
+// format. Photo IDs and URLs are omitted; each occurrence's ID is derived
+// from a hash of the photo ID.
+func writeOccurrences(w io.Writer, events []DetectionEvent, cfg OccurrenceConfig) error {
+	basis := cfg.BasisOfRecord
+	if basis == "" {
+		basis = "MachineObservation"
+	}
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(darwinCoreColumns); err != nil {
+		return err
+	}
+	for _, event := range events {
+		id := sha256.Sum256([]byte(event.PhotoID))
+		uncertainty := ""
+		if cfg.CoordinateUncertaintyMeters > 0 {
+			uncertainty = formatFloat(cfg.CoordinateUncertaintyMeters)
+		}
+		remarks := fmt.Sprintf("identified by %s", event.Provider)
+		if event.Confidence != nil {
+			remarks += fmt.Sprintf(" with confidence %.2f", *event.Confidence)
+		}
+
+		if err := out.Write([]string{
+			"urn:lychee-birb-title:" + hex.EncodeToString(id[:16]),
+			basis,
+			event.ObservedAt.UTC().Format(time.RFC3339),
+			event.Species,
+			formatFloat(cfg.Latitude),
+			formatFloat(cfg.Longitude),
+			uncertainty,
+			"WGS84",
+			cfg.RecordedBy,
+			remarks,
+		}); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// runExportOccurrences implements the "export-occurrences" command.
+func runExportOccurrences(config *Config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: lychee-birb-title [flags] export-occurrences [FILE]")
+	}
+	if config.SightingsLog == "" {
+		return fmt.Errorf("export-occurrences requires a sightings_log in the config file")
+	}
+	if config.Occurrence.Latitude == 0 && config.Occurrence.Longitude == 0 {
+		return fmt.Errorf("export-occurrences requires occurrence.latitude and occurrence.longitude in the config file")
+	}
+
+	events, err := readSightings(config.SightingsLog)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if len(args) == 1 {
+		file, err := os.Create(args[0])
+		if err != nil {
+			return fmt.Errorf("error creating export file: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if err := writeOccurrences(out, events, config.Occurrence); err != nil {
+		return fmt.Errorf("error writing export: %v", err)
+	}
+	return nil
+}
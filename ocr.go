@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+
+	vision "cloud.google.com/go/vision/apiv1"
+)
+
+// OCRProvider detects the overlay text in an image.
+type OCRProvider interface {
+	// DetectText returns the text in the image at imagePath, or an error
+	// containing "no text detected" if there is none.
+	DetectText(ctx context.Context, imagePath string) (string, error)
+}
+
+// visionOCR detects text with the Google Cloud Vision API.
+type visionOCR struct {
+	client *vision.ImageAnnotatorClient
+}
+
+func (v visionOCR) DetectText(ctx context.Context, imagePath string) (string, error) {
+	return performOCR(ctx, imagePath, v.client)
+}
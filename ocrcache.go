@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// CachedOCR is the text OCR found in a photo, kept in the state file with
+// cache_ocr so that later runs can reuse it rather than OCRing the photo
+// again.
+type CachedOCR struct {
+	Text string `json:"text"`
+	// Settings identifies the settings the text was read with; see
+	// ocrSettings.
+	Settings string `json:"settings"`
+}
+
+// ocrSettings returns a fingerprint of the settings that affect what OCR
+// reads from a photo. Cached text read with other settings isn't reused.
+func ocrSettings(config *Config) string {
+	data, _ := json.Marshal(struct {
+		Crop         CropConfig
+		Image        ImageConfig
+		Video        VideoConfig
+		SizeVariants []string
+	}{config.Crop, config.Image, config.Video, config.SizeVariants})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ocrCache looks up cached OCR text during a run. It's read concurrently by
+// the workers, so the run records new text in the state rather than here.
+type ocrCache struct {
+	entries  map[string]CachedOCR
+	settings string
+}
+
+// lookup returns the photo's cached text, if it was read with the current
+// settings. A nil cache has no entries.
+func (c *ocrCache) lookup(photoID string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	entry, ok := c.entries[photoID]
+	if !ok || entry.Settings != c.settings {
+		return "", false
+	}
+	return entry.Text, true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OverlayDataConfig sets how the extra readings some cameras, such as Bird
+// Buddy, show in their overlays alongside the species are handled. They're
+// taken out of the text before it's used as a title, and recorded in the
+// sightings log.
+type OverlayDataConfig struct {
+	Enabled bool `json:"enabled"`
+	// Description also writes the readings to photos' descriptions.
+	Description bool `json:"description"`
+}
+
+// OverlayData holds the readings found in an overlay.
+type OverlayData struct {
+	// Confidence is the camera's identification confidence (0-1), or 0 if
+	// it isn't shown.
+	Confidence float64
+	// Temperature is as shown, normalized to a form like "72°F".
+	Temperature string
+}
+
+var (
+	overlayConfidencePattern  = regexp.MustCompile(`(\d{1,3}(?:\.\d+)?)\s?%`)
+	overlayTemperaturePattern = regexp.MustCompile(`(-?\d{1,3}(?:\.\d+)?)\s?(?:[°º˚]\s?([CFcf])?|([CF])\b)`)
+)
+
+// parseOverlayData finds the readings in OCR text, returning them along with
+// the text without them.
+func parseOverlayData(text string) (OverlayData, string) {
+	var data OverlayData
+	if m := overlayConfidencePattern.FindStringSubmatchIndex(text); m != nil {
+		if percent, err := strconv.ParseFloat(text[m[2]:m[3]], 64); err == nil && percent <= 100 {
+			data.Confidence = percent / 100
+			text = text[:m[0]] + " " + text[m[1]:]
+		}
+	}
+	if m := overlayTemperaturePattern.FindStringSubmatch(text); m != nil {
+		data.Temperature = m[1] + "°" + strings.ToUpper(m[2]+m[3])
+		text = strings.Replace(text, m[0], " ", 1)
+	}
+	return data, cleanOverlayText(text)
+}
+
+func (d OverlayData) empty() bool {
+	return d.Confidence == 0 && d.Temperature == ""
+}
+
+// String describes the readings for a photo's description.
+func (d OverlayData) String() string {
+	var parts []string
+	if d.Confidence > 0 {
+		parts = append(parts, fmt.Sprintf("Confidence: %s%%", strconv.FormatFloat(d.Confidence*100, 'f', -1, 64)))
+	}
+	if d.Temperature != "" {
+		parts = append(parts, "Temperature: "+d.Temperature)
+	}
+	return strings.Join(parts, "\n")
+}
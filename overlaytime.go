@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OverlayTimeConfig sets how the date and time in a feeder camera's overlay
+// are used to correct photos' taken_at dates, for cameras whose clocks
+// can't be trusted.
+type OverlayTimeConfig struct {
+	Enabled bool `json:"enabled"`
+	// Timezone is the IANA name of the time zone the overlay's times are in.
+	Timezone string `json:"timezone"`
+	// DateOrder is the order of the overlay's numeric dates when the year
+	// comes last: "mdy" (the default) or "dmy". Dates with the year first
+	// are always read as year, month, day.
+	DateOrder string `json:"date_order"`
+	// MinDifferenceMinutes is how far taken_at must be from the overlay's
+	// time before it's corrected (default 5).
+	MinDifferenceMinutes int `json:"min_difference_minutes"`
+	// Apply writes the corrected dates; without it, they're only logged.
+	Apply bool `json:"apply"`
+}
+
+const defaultOverlayTimeMinDifference = 5 * time.Minute
+
+// overlayTimestampLayout is the format of taken_at values in field changes.
+const overlayTimestampLayout = "2006-01-02 15:04:05"
+
+func (c OverlayTimeConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Timezone == "" {
+		return fmt.Errorf("overlay_time requires a timezone")
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("error in overlay_time timezone: %v", err)
+	}
+	switch c.DateOrder {
+	case "", "mdy", "dmy":
+		return nil
+	default:
+		return fmt.Errorf("unknown overlay_time date_order %q (expected mdy or dmy)", c.DateOrder)
+	}
+}
+
+func (c OverlayTimeConfig) minDifference() time.Duration {
+	if c.MinDifferenceMinutes > 0 {
+		return time.Duration(c.MinDifferenceMinutes) * time.Minute
+	}
+	return defaultOverlayTimeMinDifference
+}
+
+var overlayTimePattern = regexp.MustCompile(`(\d{1,4})[-/.](\d{1,2})[-/.](\d{2,4})[ T,]+(\d{1,2}):(\d{2})(?::(\d{2}))?(?:\s*([AaPp])\.?[Mm]\.?)?`)
+
+// parseOverlayTime finds a date and time in OCR text, returning it along
+// with the text without it. It reports false if the text has no date and
+// time, or only an impossible one.
+func (c OverlayTimeConfig) parseOverlayTime(text string) (time.Time, string, bool) {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Time{}, text, false
+	}
+	for _, match := range overlayTimePattern.FindAllStringSubmatchIndex(text, -1) {
+		group := func(i int) string {
+			if match[2*i] < 0 {
+				return ""
+			}
+			return text[match[2*i]:match[2*i+1]]
+		}
+		num := func(i int) int {
+			n, _ := strconv.Atoi(group(i))
+			return n
+		}
+
+		// The year comes first (with four digits) or last (with two or four)
+		var year, month, day int
+		switch first, last := len(group(1)), len(group(3)); {
+		case first == 4 && last <= 2:
+			year, month, day = num(1), num(2), num(3)
+		case first > 2 || last == 3:
+			continue
+		case c.DateOrder == "dmy":
+			day, month, year = num(1), num(2), num(3)
+		default:
+			month, day, year = num(1), num(2), num(3)
+		}
+		if year < 100 {
+			year += 2000
+		}
+
+		hour, minute, second := num(4), num(5), num(6)
+		switch strings.ToLower(group(7)) {
+		case "a":
+			if hour == 12 {
+				hour = 0
+			}
+		case "p":
+			if hour < 12 {
+				hour += 12
+			}
+		}
+		if hour > 23 || minute > 59 || second > 59 {
+			continue
+		}
+		t := time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
+		if t.Month() != time.Month(month) || t.Day() != day {
+			continue
+		}
+
+		return t, cleanOverlayText(text[:match[0]] + text[match[1]:]), true
+	}
+	return time.Time{}, text, false
+}
+
+// cleanOverlayText collapses the spaces in each line of text, dropping any
+// lines left empty.
+func cleanOverlayText(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// takenAtChange returns the change correcting the photo's taken_at to the
+// overlay's time, if they differ by more than min_difference_minutes.
+func (c OverlayTimeConfig) takenAtChange(photo Photo, overlay time.Time) (fieldChange, bool) {
+	diff := overlay.Sub(photo.TakenAt)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff < c.minDifference() {
+		return fieldChange{}, false
+	}
+	return fieldChange{
+		Field:    "taken_at",
+		OldValue: photo.TakenAt.UTC().Format(overlayTimestampLayout),
+		NewValue: overlay.UTC().Format(overlayTimestampLayout),
+	}, true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	duplicateReuse = "reuse"
+	duplicateFlag  = "flag"
+
+	defaultDuplicateMaxDistance = 4
+)
+
+// DuplicateConfig controls detecting photos that are copies (or re-uploads)
+// of photos that were already titled, by comparing perceptual hashes.
+type DuplicateConfig struct {
+	Enabled bool `json:"enabled"`
+	// Action is "reuse" (the default) to give a duplicate the title of the
+	// photo it matches without OCRing it, or "flag" to flag it for review.
+	Action string `json:"action"`
+	// MaxDistance is the largest number of differing hash bits for two
+	// photos to match (default 4, out of 64).
+	MaxDistance int `json:"max_distance"`
+}
+
+func (c DuplicateConfig) validate() error {
+	switch c.Action {
+	case "", duplicateReuse, duplicateFlag:
+		return nil
+	default:
+		return fmt.Errorf("unknown duplicates action %q (expected %s or %s)", c.Action, duplicateReuse, duplicateFlag)
+	}
+}
+
+func (c DuplicateConfig) maxDistance() int {
+	if c.MaxDistance > 0 {
+		return c.MaxDistance
+	}
+	return defaultDuplicateMaxDistance
+}
+
+// PhotoHash is the perceptual hash of a titled photo, as stored in the state
+// file.
+type PhotoHash struct {
+	Hash  string `json:"hash"` // 16 hex digits
+	Title string `json:"title"`
+}
+
+// perceptualHash returns the DCT-based perceptual hash (pHash) of img: the
+// image is reduced to 32x32 grayscale, and each bit records whether one of the
+// 63 lowest-frequency DCT coefficients (excluding the DC term) is above their
+// median. ok is false for images too featureless to hash meaningfully, such
+// as blank frames, which would otherwise all match one another.
+func perceptualHash(img image.Image) (hash uint64, ok bool) {
+	const size = 32
+	const low = 8
+
+	var pixels [size][size]float64
+	small := downscaleImage(img, size)
+	bounds := small.Bounds()
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// Stretch images that weren't square to fill the grid
+			sx := bounds.Min.X + x*bounds.Dx()/size
+			sy := bounds.Min.Y + y*bounds.Dy()/size
+			r, g, b, _ := small.At(sx, sy).RGBA()
+			pixels[y][x] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+	}
+
+	var coefficients []float64
+	for v := 0; v < low; v++ {
+		for u := 0; u < low; u++ {
+			if u == 0 && v == 0 {
+				continue
+			}
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += pixels[y][x] *
+						math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*size)) *
+						math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*size))
+				}
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	sorted := append([]float64(nil), coefficients...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	// About half the bits of a real photo's hash are set
+	return hash, bits.OnesCount64(hash) >= 16
+}
+
+// HashIndex finds titled photos whose perceptual hashes are close to a given
+// hash. It's safe for concurrent use.
+type HashIndex struct {
+	maxDistance int
+
+	mu     sync.Mutex
+	hashes map[string]uint64 // by photo ID
+	titles map[string]string
+}
+
+// newHashIndex builds an index of the hashes recorded in the state file.
+func newHashIndex(stored map[string]PhotoHash, maxDistance int) *HashIndex {
+	idx := &HashIndex{
+		maxDistance: maxDistance,
+		hashes:      make(map[string]uint64, len(stored)),
+		titles:      make(map[string]string, len(stored)),
+	}
+	for photoID, h := range stored {
+		hash, err := strconv.ParseUint(h.Hash, 16, 64)
+		if err != nil {
+			continue
+		}
+		idx.hashes[photoID] = hash
+		idx.titles[photoID] = h.Title
+	}
+	return idx
+}
+
+// Match returns the ID and title of the indexed photo closest to hash, if
+// any is within the index's maximum distance.
+func (idx *HashIndex) Match(hash uint64) (string, string, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	bestID, bestDistance := "", idx.maxDistance+1
+	for photoID, h := range idx.hashes {
+		if d := bits.OnesCount64(h ^ hash); d < bestDistance || (d == bestDistance && photoID < bestID) {
+			bestID, bestDistance = photoID, d
+		}
+	}
+	if bestID == "" {
+		return "", "", false
+	}
+	return bestID, idx.titles[bestID], true
+}
+
+// Add indexes the hash of a titled photo and returns it in the form stored
+// in the state file.
+func (idx *HashIndex) Add(photoID string, hash uint64, title string) PhotoHash {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.hashes[photoID] = hash
+	idx.titles[photoID] = title
+	return PhotoHash{Hash: fmt.Sprintf("%016x", hash), Title: title}
+}
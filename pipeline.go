@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// titlePipeline turns the text found in a photo into its title: the
+// overlay's time and readings are taken out, then multi_species,
+// title_rules, reject_patterns, translation, aliases, and the checklist are
+// applied in turn. Runs and the gRPC server share it, so they title photos
+// the same way.
+type titlePipeline struct {
+	config          *Config
+	aliases         AliasMap
+	checklist       Checklist
+	checklistAction string
+	names           NameTable
+	cleanTitle      func(string) string
+	rejected        func(string) (string, bool)
+}
+
+// photoTitle is what the pipeline made of a photo's text.
+type photoTitle struct {
+	// Species is the text once resolved with aliases and the checklist, and
+	// Title is the species with any scientific name appended.
+	Species string
+	Title   string
+	// NeedsReview is set if the text was ambiguous or isn't on the
+	// checklist.
+	NeedsReview bool
+	// Changes are made to the photo along with its title, such as its
+	// taken_at from the overlay or its description.
+	Changes []fieldChange
+}
+
+// newTitlePipeline loads the aliases, checklist, and translation table, and
+// compiles the title_rules and reject_patterns.
+func newTitlePipeline(config *Config) (*titlePipeline, error) {
+	p := &titlePipeline{config: config}
+	var err error
+	if p.aliases, err = loadAliases(config.AliasesFile); err != nil {
+		return nil, err
+	}
+	if p.checklist, err = loadChecklist(config.Checklist.File); err != nil {
+		return nil, err
+	}
+	if p.names, err = loadNameTable(config.Translation); err != nil {
+		return nil, err
+	}
+	if err := config.MultiSpecies.validate(p.checklist); err != nil {
+		return nil, err
+	}
+	if p.checklistAction, err = config.Checklist.action(); err != nil {
+		return nil, err
+	}
+	if err := config.Checklist.validate(); err != nil {
+		return nil, err
+	}
+	if p.cleanTitle, err = compileTitleRules(config.TitleRules); err != nil {
+		return nil, err
+	}
+	if p.rejected, err = compileRejectPatterns(config.RejectPatterns); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// onChecklist reports whether a name, once translated, is on the checklist or
+// close enough to be corrected to a name on it.
+func (p *titlePipeline) onChecklist(name string) bool {
+	if translated, ok := p.names.translate(name); ok {
+		name = translated
+	}
+	if _, ok := p.checklist.lookup(name); ok {
+		return true
+	}
+	_, ok := p.checklist.closest(name, p.config.Checklist.MaxDistance)
+	return ok
+}
+
+// apply titles a photo from the text in result, which is updated as the
+// overlay's readings are taken out and as text is rejected. Changes to
+// taken_at are only made if writable is set; otherwise they're logged.
+func (p *titlePipeline) apply(photo Photo, result *PhotoResult, writable bool) photoTitle {
+	config := p.config
+	var t photoTitle
+
+	// Take the overlay's date and time out of the text, and correct the
+	// photo's taken_at with it (bursts share their first photo's text,
+	// but not its time)
+	if config.OverlayTime.Enabled && !result.NoText {
+		if overlay, rest, ok := config.OverlayTime.parseOverlayTime(result.Text); ok {
+			result.Text, result.NoText = rest, rest == ""
+			change, differs := config.OverlayTime.takenAtChange(photo, overlay)
+			switch {
+			case !differs || result.Source != sourceOCR:
+			case !writable || !config.OverlayTime.Apply:
+				slog.Info("Would change taken_at", "photo_id", photo.ID, "old", change.OldValue, "new", change.NewValue,
+					"overlay_time", overlay.Format(overlayTimestampLayout))
+			default:
+				t.Changes = append(t.Changes, change)
+			}
+		}
+	}
+
+	// Take the overlay's other readings out of the text too
+	if config.OverlayData.Enabled && !result.NoText {
+		data, rest := parseOverlayData(result.Text)
+		result.Text, result.NoText = rest, rest == ""
+		if result.Source == sourceOCR {
+			result.Confidence, result.Temperature = data.Confidence, data.Temperature
+			if config.OverlayData.Description && !data.empty() && data.String() != photo.Description {
+				t.Changes = append(t.Changes, fieldChange{Field: "description", OldValue: photo.Description, NewValue: data.String()})
+			}
+		}
+	}
+
+	// Choose among several lines or names
+	if !result.NoText {
+		result.Text = config.MultiSpecies.choose(result.Text, p.onChecklist)
+	}
+
+	// Clean up the text with title_rules
+	if !result.NoText {
+		result.Text = p.cleanTitle(result.Text)
+		result.NoText = strings.TrimSpace(result.Text) == ""
+	}
+
+	// Treat junk text, such as a camera's watermark, as no text
+	if !result.NoText {
+		if pattern, ok := p.rejected(result.Text); ok {
+			slog.Info("Rejecting text matching a reject pattern", "photo_id", photo.ID, "text", strings.TrimSpace(result.Text), "pattern", pattern)
+			result.NoText = true
+		}
+	}
+
+	// Translate the species name
+	if p.names != nil && !result.NoText {
+		if translated, ok := p.names.translate(result.Text); ok {
+			result.Text = translated
+		}
+	}
+
+	text := result.Text
+	if !result.NoText {
+		text, t.NeedsReview = p.aliases.resolve(text)
+		if t.NeedsReview {
+			slog.Info("Ambiguous text; flagging for review", "photo_id", photo.ID, "text", strings.TrimSpace(text))
+		}
+	}
+	if p.checklist != nil && !result.NoText && !t.NeedsReview {
+		_, known := p.checklist.lookup(text)
+		if !known {
+			if species, ok := p.checklist.closest(text, config.Checklist.MaxDistance); ok {
+				slog.Info("Correcting text from the checklist", "photo_id", photo.ID, "text", strings.TrimSpace(text), "species", species.CommonName)
+				text, known = species.CommonName, true
+			}
+		}
+		switch {
+		case known:
+		case p.checklistAction == checklistReject:
+			slog.Info("Rejecting text that isn't on the checklist", "photo_id", photo.ID, "text", strings.TrimSpace(text))
+			result.NoText = true
+		default:
+			slog.Info("Text isn't on the checklist; flagging for review", "photo_id", photo.ID, "text", strings.TrimSpace(text))
+			t.NeedsReview = true
+		}
+	}
+	t.Species, t.Title = text, text
+
+	// Add the species' scientific name from the checklist
+	if config.Checklist.ScientificName != "" && !result.NoText && !t.NeedsReview {
+		if species, ok := p.checklist.lookup(text); ok && species.ScientificName != "" {
+			if config.Checklist.ScientificName == scientificNameAppend {
+				t.Title = fmt.Sprintf("%s (%s)", strings.TrimSpace(text), species.ScientificName)
+			} else if species.ScientificName != photo.Description {
+				t.Changes = append(t.Changes, fieldChange{Field: "description", OldValue: photo.Description, NewValue: species.ScientificName})
+			}
+		}
+	}
+	return t
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestRebindPostgres(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT id FROM photos WHERE id = ?", "SELECT id FROM photos WHERE id = $1"},
+		{"INSERT INTO audit (a, b, c) VALUES (?, ?, ?)", "INSERT INTO audit (a, b, c) VALUES ($1, $2, $3)"},
+		{"UPDATE photos SET title = ? WHERE title = 'why?' AND id = ?", "UPDATE photos SET title = $1 WHERE title = 'why?' AND id = $2"},
+		{"SELECT 'it''s ?', ?", "SELECT 'it''s ?', $1"},
+		{`SELECT "odd?name" FROM t WHERE x = ?`, `SELECT "odd?name" FROM t WHERE x = $1`},
+	}
+	for _, tt := range tests {
+		if got := rebindPostgres(tt.query); got != tt.want {
+			t.Errorf("rebindPostgres(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
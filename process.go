@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PhotoTimings records how long the stages of processing a photo took, and
+// how much data was downloaded for it.
+type PhotoTimings struct {
+	DownloadMS int64 `json:"download_ms"`
+	OCRMS      int64 `json:"ocr_ms"`
+	TotalMS    int64 `json:"total_ms"`
+	Bytes      int64 `json:"bytes"`
+}
+
+const (
+	sourceOCR     = "ocr"
+	sourceBirdNET = "birdnet"
+	sourceBurst   = "burst" // inferred from a neighboring photo in a burst
+
+	// sourceDuplicate titles are copied from an earlier photo with a matching
+	// perceptual hash
+	sourceDuplicate = "duplicate"
+)
+
+// PhotoResult is the outcome of downloading and OCRing a single photo.
+type PhotoResult struct {
+	Text string
+	// Source identifies how Text was determined (sourceOCR, sourceBirdNET,
+	// sourceBurst, or sourceDuplicate).
+	Source string
+	// Confidence is the identification confidence, if Source reports one
+	// or, with overlay_data, the overlay shows one.
+	Confidence float64
+	// Temperature is the temperature shown in the overlay, with
+	// overlay_data.
+	Temperature string
+	// NoText is set if OCR completed but found no text.
+	NoText bool
+	// Processed is set if the photo got as far as being sent for OCR.
+	Processed bool
+	// Error describes why processing failed, if it did.
+	Error   string
+	Timings PhotoTimings
+
+	// Hash is the photo's perceptual hash, if Hashed is set.
+	Hash   uint64
+	Hashed bool
+	// DuplicateOf is the ID of the photo this one duplicates, for
+	// sourceDuplicate results.
+	DuplicateOf string
+	// Cached is set if Text was read by an earlier run's OCR.
+	Cached bool
+	// OCRRequests counts the requests made to the OCR provider.
+	OCRRequests int
+}
+
+// fetchPhotoFile returns the path of a temporary copy of one of photo's
+// files, read from localPath if it's set and downloaded from url otherwise.
+// Local files are copied so that callers can always remove the returned file.
+func fetchPhotoFile(ctx context.Context, downloader *Downloader, photo Photo, url, localPath string) (string, error) {
+	if localPath != "" {
+		return copyToTemp(localPath, filepath.Ext(localPath))
+	}
+	return downloader.DownloadPhoto(ctx, photo, url)
+}
+
+// analyzePhoto downloads the photo, extracts frames from videos and GIFs,
+// and OCRs the cropped region of each image in turn until text is found.
+// Temporary files are removed before it returns.
+func analyzePhoto(ctx context.Context, config *Config, downloader *Downloader, ocr OCRProvider, hashes *HashIndex, cache *ocrCache, photo Photo) (result PhotoResult) {
+	start := time.Now()
+	ctx, photoSpan := startSpan(ctx, "photo", slog.String("photo.id", photo.ID))
+	defer func() {
+		result.Timings.TotalMS = time.Since(start).Milliseconds()
+		photoSpan.SetAttributes(slog.String("source", result.Source), slog.Bool("cached", result.Cached), slog.Bool("no_text", result.NoText))
+		if result.Error != "" {
+			photoSpan.SetError(result.Error)
+		}
+		photoSpan.End()
+	}()
+
+	// Reuse text found by an earlier run, without downloading the photo
+	if text, ok := cache.lookup(photo.ID); ok {
+		result.Text = text
+		result.Source = sourceOCR
+		result.Cached = true
+		return result
+	}
+
+	// Download and process the file
+	_, downloadSpan := startSpan(ctx, "download", slog.String("url", photo.ImageURL))
+	filePath, err := fetchPhotoFile(ctx, downloader, photo, photo.ImageURL, photo.ImageFile)
+	result.Timings.DownloadMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = fmt.Sprintf("Error downloading file: %v", err)
+		downloadSpan.SetError(result.Error)
+		downloadSpan.End()
+		return result
+	}
+	defer func() { _ = os.Remove(filePath) }()
+	if info, err := os.Stat(filePath); err == nil {
+		result.Timings.Bytes = info.Size()
+	}
+	downloadSpan.SetAttributes(slog.Int64("bytes", result.Timings.Bytes))
+	downloadSpan.End()
+	slog.Debug("Downloaded photo", "photo_id", photo.ID, "url", photo.ImageURL, "bytes", result.Timings.Bytes,
+		"download_ms", result.Timings.DownloadMS)
+
+	// If it's a video or GIF, extract the frame(s) to OCR
+	var imagePaths []string
+	if isVideoFile(photo.ImageURL) {
+		_, frameSpan := startSpan(ctx, "extract_frames")
+		imagePaths, err = extractFrames(filePath, config.Video)
+		if err != nil {
+			result.Error = fmt.Sprintf("Error extracting frame from video: %v", err)
+			frameSpan.SetError(result.Error)
+			frameSpan.End()
+			return result
+		}
+		frameSpan.SetAttributes(slog.Int("frames", len(imagePaths)))
+		frameSpan.End()
+		for _, framePath := range imagePaths {
+			defer func() { _ = os.Remove(framePath) }()
+		}
+	} else if isGIFFile(photo.ImageURL) {
+		_, frameSpan := startSpan(ctx, "extract_frames")
+		imagePath, err := extractGIFFrame(filePath, config.Image.maxPixels())
+		if err != nil {
+			result.Error = fmt.Sprintf("Error extracting frame from GIF: %v", err)
+			frameSpan.SetError(result.Error)
+			frameSpan.End()
+			return result
+		}
+		frameSpan.End()
+		defer func() { _ = os.Remove(imagePath) }()
+		imagePaths = []string{imagePath}
+	} else {
+		imagePaths = []string{filePath}
+	}
+
+	// Skip OCR for copies of photos that have already been titled
+	if hashes != nil {
+		img, err := decodeJPEG(imagePaths[0], config.Image.maxPixels())
+		if err != nil {
+			result.Error = fmt.Sprintf("Error hashing image: %v", err)
+			return result
+		}
+		result.Hash, result.Hashed = perceptualHash(img)
+		if result.Hashed {
+			if photoID, title, ok := hashes.Match(result.Hash); ok {
+				result.Text = title
+				result.Source = sourceDuplicate
+				result.DuplicateOf = photoID
+				return result
+			}
+		}
+	}
+
+	// Crop and OCR each image (or extracted frame) in turn until text is found
+	var ocrErr error
+	for _, imagePath := range imagePaths {
+		_, cropSpan := startSpan(ctx, "crop")
+		croppedPath, err := cropImage(imagePath, config.Crop, config.Image)
+		if err != nil {
+			result.Error = fmt.Sprintf("Error cropping image: %v", err)
+			cropSpan.SetError(result.Error)
+			cropSpan.End()
+			return result
+		}
+		cropSpan.End()
+		defer func() { _ = os.Remove(croppedPath) }()
+		slog.Debug("Cropped image", "photo_id", photo.ID, "path", croppedPath)
+
+		result.Processed = true
+		result.OCRRequests++
+		ocrCtx, ocrSpan := startSpan(ctx, "ocr")
+		ocrStart := time.Now()
+		result.Text, ocrErr = ocr.DetectText(ocrCtx, croppedPath)
+		result.Timings.OCRMS += time.Since(ocrStart).Milliseconds()
+		if ocrErr != nil && !strings.Contains(ocrErr.Error(), "no text detected") {
+			ocrSpan.SetError(ocrErr.Error())
+		}
+		ocrSpan.End()
+		if ocrErr == nil {
+			slog.Debug("Read text", "photo_id", photo.ID, "text", result.Text, "ocr_ms", time.Since(ocrStart).Milliseconds())
+		} else {
+			slog.Debug("OCR found no text", "photo_id", photo.ID, "error", ocrErr)
+		}
+		if ocrErr == nil || !strings.Contains(ocrErr.Error(), "no text detected") {
+			break
+		}
+	}
+
+	if ocrErr == nil {
+		result.Source = sourceOCR
+		return result
+	}
+	if !strings.Contains(ocrErr.Error(), "no text detected") {
+		result.Error = fmt.Sprintf("OCR error: %v", ocrErr)
+		return result
+	}
+	result.NoText = true
+
+	// With no overlay text, fall back to identifying the bird by its call
+	if photo.IsVideo() && config.BirdNET.enabled() {
+		videoPath := filePath
+		if !isVideoFile(photo.ImageURL) {
+			if photo.OriginalURL == "" {
+				return result
+			}
+			videoPath, err = fetchPhotoFile(ctx, downloader, photo, photo.OriginalURL, photo.OriginalFile)
+			if err != nil {
+				result.Error = fmt.Sprintf("Error downloading video for audio analysis: %v", err)
+				return result
+			}
+			defer func() { _ = os.Remove(videoPath) }()
+		}
+
+		_, birdnetSpan := startSpan(ctx, "birdnet")
+		detection, err := identifyByAudio(videoPath, config.BirdNET, config.Video, config.httpClient(birdnetTimeout))
+		if err != nil {
+			birdnetSpan.SetError(err.Error())
+		}
+		birdnetSpan.End()
+		if err != nil {
+			result.Error = fmt.Sprintf("BirdNET error: %v", err)
+			return result
+		}
+		if detection != nil {
+			result.NoText = false
+			result.Text = detection.CommonName
+			result.Source = sourceBirdNET
+			result.Confidence = detection.Confidence
+		}
+	}
+
+	return result
+}
+
+// photoOutcome pairs a photo with the result of analyzing it.
+type photoOutcome struct {
+	Photo  Photo
+	Result PhotoResult
+}
+
+// analyzePhotos analyzes photos with opts.Workers concurrent workers, sending
+// each outcome on the returned channel, which is closed once every photo has
+// been handled. Once stop is closed, no more photos are started, and the
+// channel is closed when those in progress are done. Photos in the same burst
+// are handled in order by a single worker, so that the burst's first title can
+// be reused. If hashes is non-nil, photos matching an indexed photo aren't
+// OCRed, and neither are photos with text in the cache.
+func analyzePhotos(ctx context.Context, stop <-chan struct{}, config *Config, downloader *Downloader, ocr OCRProvider, hashes *HashIndex, cache *ocrCache, photos []Photo, opts runOptions) <-chan photoOutcome {
+	workers := max(opts.Workers, 1)
+	groups := make(chan []Photo)
+	outcomes := make(chan photoOutcome)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groups {
+				burstTitle := ""
+				for _, photo := range group {
+					if burstTitle != "" {
+						// Reuse the title of the earlier photo in this burst
+						// rather than spending an OCR call
+						outcomes <- photoOutcome{photo, PhotoResult{Text: burstTitle, Source: sourceBurst}}
+						continue
+					}
+
+					photoCtx, cancel := ctx, context.CancelFunc(func() {})
+					if opts.PhotoTimeout > 0 {
+						photoCtx, cancel = context.WithTimeout(ctx, opts.PhotoTimeout)
+					}
+					result := analyzePhoto(photoCtx, config, downloader, ocr, hashes, cache, photo)
+					cancel()
+					if result.Error == "" && !result.NoText {
+						burstTitle = result.Text
+					}
+					outcomes <- photoOutcome{photo, result}
+				}
+			}
+		}()
+	}
+
+	go func() {
+	dispatch:
+		for _, group := range groupBursts(photos, config.Burst) {
+			select {
+			case groups <- group:
+			case <-stop:
+				break dispatch
+			}
+		}
+		close(groups)
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	return outcomes
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const progressBarWidth = 30
+
+// progressBar draws a run's progress on a single, redrawn line of a
+// terminal: the photos handled, the estimated time left, and the photo just
+// handled. A nil progressBar draws nothing.
+type progressBar struct {
+	out io.Writer
+
+	mu      sync.Mutex
+	total   int
+	started time.Time
+	line    string // as last drawn, or empty if the bar isn't showing
+}
+
+func newProgressBar(out io.Writer) *progressBar {
+	return &progressBar{out: out}
+}
+
+// isTerminal reports whether f is a terminal, rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start begins a run of total photos.
+func (b *progressBar) Start(total int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total = total
+	b.started = time.Now()
+	b.draw(b.render(0, ""))
+}
+
+// Update redraws the bar with done photos handled, the last being photoID.
+func (b *progressBar) Update(done int, photoID string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw(b.render(done, photoID))
+}
+
+// Finish clears the bar, leaving the line free for the run summary.
+func (b *progressBar) Finish() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw("")
+}
+
+func (b *progressBar) render(done int, photoID string) string {
+	total := max(b.total, 1)
+	filled := min(done*progressBarWidth/total, progressBarWidth)
+	line := fmt.Sprintf("[%s%s] %d/%d %3d%%",
+		strings.Repeat("#", filled), strings.Repeat("-", progressBarWidth-filled),
+		done, b.total, done*100/total)
+	if done > 0 && done < b.total {
+		elapsed := time.Since(b.started)
+		eta := elapsed / time.Duration(done) * time.Duration(b.total-done)
+		line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+	}
+	if photoID != "" {
+		line += "  " + photoID
+	}
+	return line
+}
+
+func (b *progressBar) draw(line string) {
+	fmt.Fprint(b.out, "\r\x1b[K"+line)
+	b.line = line
+}
+
+// LogWriter returns a writer for log messages going to the same terminal,
+// which clears the bar before each message and redraws it after.
+func (b *progressBar) LogWriter(w io.Writer) io.Writer {
+	return progressLogWriter{bar: b, w: w}
+}
+
+type progressLogWriter struct {
+	bar *progressBar
+	w   io.Writer
+}
+
+func (p progressLogWriter) Write(data []byte) (int, error) {
+	p.bar.mu.Lock()
+	defer p.bar.mu.Unlock()
+	line := p.bar.line
+	if line != "" {
+		p.bar.draw("")
+	}
+	n, err := p.w.Write(data)
+	if line != "" {
+		p.bar.draw(line)
+	}
+	return n, err
+}
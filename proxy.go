@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// parseProxyURL parses the proxy config setting, which may be an http:// or
+// socks5:// URL. An empty setting yields nil, meaning the HTTP_PROXY,
+// HTTPS_PROXY, and NO_PROXY environment variables are used instead.
+func parseProxyURL(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing proxy URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+		return u, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+}
+
+// proxyTransport returns a transport that connects through proxyURL, or if
+// it's nil, the proxy set by the environment variables.
+func proxyTransport(proxyURL *url.URL) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return transport
+}
+
+// httpClient returns a client with the given timeout for requests to
+// services other than Lychee, such as notifications, healthchecks, and the
+// HTTP sink, which go through the configured proxy like downloads do.
+func (c *Config) httpClient(timeout time.Duration) *http.Client {
+	// loadConfig has checked the proxy setting
+	proxyURL, _ := parseProxyURL(c.Proxy)
+	return &http.Client{Transport: proxyTransport(proxyURL), Timeout: timeout}
+}
+
+// proxyDialer returns a dial function that connects to addr through the given
+// proxy, for clients (like the Vision API's gRPC client) that don't take an
+// *http.Transport.
+func proxyDialer(proxyURL *url.URL) (func(ctx context.Context, addr string) (net.Conn, error), error) {
+	if proxyURL.Scheme != "http" {
+		dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring proxy: %v", err)
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("proxy scheme %s doesn't support contexts", proxyURL.Scheme)
+		}
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return contextDialer.DialContext(ctx, "tcp", addr)
+		}, nil
+	}
+
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", proxyURL.Host)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to proxy: %v", err)
+		}
+
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		if user := proxyURL.User; user != nil {
+			password, _ := user.Password()
+			credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+			req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+		}
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error sending CONNECT to proxy: %v", err)
+		}
+
+		// The server won't send anything more until the client starts its TLS
+		// handshake, so nothing past the response is lost to buffering
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error reading CONNECT response from proxy: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", addr, resp.Status)
+		}
+		return conn, nil
+	}, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPClientProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Method+" "+r.URL.String())
+	}))
+	defer proxy.Close()
+
+	config := &Config{Proxy: proxy.URL}
+	client := config.httpClient(healthcheckTimeout)
+	pingHealthcheck(HealthcheckConfig{URL: "http://hc-ping.invalid/uuid"}, client, healthcheckStart, "")
+	notifiers, err := newNotifiers([]NotifierConfig{{Type: "ntfy", URL: "http://ntfy.invalid", Topic: "birds"}}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifyAll(notifiers, "title", "message")
+
+	want := []string{"POST http://hc-ping.invalid/uuid/start", "POST http://ntfy.invalid"}
+	if len(proxied) != len(want) {
+		t.Fatalf("proxied requests = %q, want %q", proxied, want)
+	}
+	for i := range want {
+		if proxied[i] != want[i] && proxied[i] != want[i]+"/" {
+			t.Errorf("proxied request %d = %q, want %q", i, proxied[i], want[i])
+		}
+	}
+}
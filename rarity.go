@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RarityConfig controls real-time notifications for rarely seen species.
+type RarityConfig struct {
+	// MaxSightings is the number of previous sightings below which a species
+	// is considered rare and a notification is sent as soon as it's
+	// identified (0 disables these notifications).
+	MaxSightings int `json:"max_sightings"`
+	// Species overrides MaxSightings for individual species, keyed by name;
+	// set a species to 0 to never be notified about it.
+	Species map[string]int `json:"species"`
+}
+
+func (c RarityConfig) enabled() bool {
+	if c.MaxSightings > 0 {
+		return true
+	}
+	for _, n := range c.Species {
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// threshold returns the number of sightings below which species is rare.
+func (c RarityConfig) threshold(species string) int {
+	for name, n := range c.Species {
+		if strings.EqualFold(name, species) {
+			return n
+		}
+	}
+	return c.MaxSightings
+}
+
+// SightingCounts tracks how many times each species has been seen, from the
+// sightings log plus the identifications made during the current run.
+type SightingCounts struct {
+	counts map[string]int
+	total  int
+}
+
+func speciesKey(species string) string {
+	return normalizeTitle(species)
+}
+
+// loadSightingCounts counts the species in the sightings log at path. A
+// missing log counts as no sightings.
+func loadSightingCounts(path string) (*SightingCounts, error) {
+	c := &SightingCounts{counts: make(map[string]int)}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	events, err := readSightings(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		c.Add(event.Species)
+	}
+	return c, nil
+}
+
+// Add records a sighting of species.
+func (c *SightingCounts) Add(species string) {
+	c.counts[speciesKey(species)]++
+	c.total++
+}
+
+// Count returns the number of sightings of species.
+func (c *SightingCounts) Count(species string) int {
+	return c.counts[speciesKey(species)]
+}
+
+// Rarity returns a score between 0 (every sighting has been this species)
+// and 1 (never seen before).
+func (c *SightingCounts) Rarity(species string) float64 {
+	if c.total == 0 {
+		return 1
+	}
+	return 1 - float64(c.Count(species))/float64(c.total)
+}
+
+// rareSightingMessage describes a sighting for a rare species notification.
+func rareSightingMessage(species string, previous int, rarity float64, photo Photo) string {
+	seen := "first sighting"
+	if previous == 1 {
+		seen = "seen once before"
+	} else if previous > 1 {
+		seen = fmt.Sprintf("seen %d times before", previous)
+	}
+	return fmt.Sprintf("%s (%s, rarity %.2f)\nImage: %s\nWeb UI: %s",
+		strings.TrimSpace(species), seen, rarity, photo.ImageURL, photo.WebLink)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Values of the -output flag.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// RunReport is the machine-readable report of a run, written with -output
+// json.
+type RunReport struct {
+	Summary RunSummary `json:"summary"`
+	// Photos holds each photo's outcome, along with its species if it was
+	// identified.
+	Photos []DetectionEvent `json:"photos"`
+	Errors []PhotoError     `json:"errors"`
+}
+
+// writeRunReport writes the report as a line of JSON to the file at path,
+// replacing it, or to stdout if path is empty.
+func writeRunReport(path string, report RunReport) error {
+	if report.Photos == nil {
+		report.Photos = []DetectionEvent{}
+	}
+	if report.Errors == nil {
+		report.Errors = []PhotoError{}
+	}
+
+	out := os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("error creating report file: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := json.NewEncoder(out).Encode(report); err != nil {
+		return fmt.Errorf("error writing report: %v", err)
+	}
+	return nil
+}
+
+// eventStream writes DetectionEvents as JSON lines to a file, or to stdout,
+// as each photo's outcome is known.
+type eventStream struct {
+	mu      sync.Mutex
+	out     io.Writer
+	encoder *json.Encoder
+	file    *os.File
+}
+
+// openEventStream opens the -events stream at path, appending to the file,
+// or writing to stdout if path is "-".
+func openEventStream(path string) (*eventStream, error) {
+	if path == "-" {
+		return &eventStream{out: os.Stdout, encoder: json.NewEncoder(os.Stdout)}, nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening events file: %v", err)
+	}
+	return &eventStream{out: file, encoder: json.NewEncoder(file), file: file}, nil
+}
+
+// Emit writes an event. A nil stream discards it.
+func (s *eventStream) Emit(event DetectionEvent) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.encoder.Encode(event); err != nil {
+		return fmt.Errorf("error writing event: %v", err)
+	}
+	return nil
+}
+
+func (s *eventStream) Close() error {
+	if s == nil || s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
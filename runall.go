@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// configRun is one config file's run within run-all.
+type configRun struct {
+	Path    string
+	Config  *Config
+	Summary *RunSummary
+	Err     error
+}
+
+// runAll implements the "run-all" command, which runs every config file in a
+// directory and prints a combined report.
+func runAll(ctx context.Context, args []string, opts runOptions) error {
+	fs := flag.NewFlagSet("run-all", flag.ContinueOnError)
+	dir := fs.String("dir", "", "Directory containing the config files (*.json) to run")
+	parallel := fs.Int("parallel", 1, "Number of configs to run at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || fs.NArg() > 0 {
+		return fmt.Errorf("usage: lychee-birb-title [flags] run-all -dir DIR [-parallel N]")
+	}
+	if opts.Output == outputJSON && opts.OutputFile == "" {
+		return fmt.Errorf("run-all can't write -output json to stdout; use -output-file, which is written for each config")
+	}
+	if opts.Confirm && *parallel > 1 {
+		return fmt.Errorf("-confirm can't be used with run-all -parallel")
+	}
+
+	runs, err := loadConfigRuns(*dir)
+	if err != nil {
+		return err
+	}
+	slog.Info("Running configs", "count", len(runs), "dir", *dir)
+
+	started := time.Now()
+	sem := make(chan struct{}, max(*parallel, 1))
+	var interrupted atomic.Bool
+	var wg sync.WaitGroup
+	for _, r := range runs {
+		sem <- struct{}{}
+		if interrupted.Load() {
+			<-sem
+			r.Err = errInterrupted
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			slog.Info("Starting run", "config", r.Path)
+			r.Err = runConfig(ctx, r.Config, configRunOptions(opts, r.Path, *parallel))
+			if errors.Is(r.Err, errInterrupted) {
+				interrupted.Store(true)
+			}
+			if state, err := loadState(r.Config.StateFile); err == nil && state.LastRunSummary != nil &&
+				!state.LastRunSummary.StartedAt.Before(started) {
+				r.Summary = state.LastRunSummary
+			}
+		}()
+	}
+	wg.Wait()
+
+	failed := printRunAllReport(runs)
+	if interrupted.Load() {
+		return errInterrupted
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d configs failed", failed, len(runs))
+	}
+	return nil
+}
+
+// configRunOptions returns the options for running the config at path. Each
+// config's -output-file, -events file, and -report are written separately, by
+// adding the config's name to the file name (report.html becomes
+// report-home.html for home.json), and runs in parallel don't share the
+// progress bar.
+func configRunOptions(opts runOptions, path string, parallel int) runOptions {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	perConfig := func(file string) string {
+		if file == "" || file == "-" {
+			return file
+		}
+		ext := filepath.Ext(file)
+		return strings.TrimSuffix(file, ext) + "-" + name + ext
+	}
+	opts.OutputFile = perConfig(opts.OutputFile)
+	opts.Events = perConfig(opts.Events)
+	opts.Report = perConfig(opts.Report)
+	if parallel > 1 {
+		opts.Progress = nil
+	}
+	return opts
+}
+
+// loadConfigRuns loads the config files in dir. Each config's state must be
+// kept separately, so a config without a statefile is given one next to its
+// config file, and two configs may not share a state file.
+func loadConfigRuns(dir string) ([]*configRun, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing config files: %v", err)
+	}
+
+	var runs []*configRun
+	stateFiles := make(map[string]string)
+	for _, path := range paths {
+		config, err := loadConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s: %v", path, err)
+		}
+		if config.StateFile == "" {
+			config.StateFile = strings.TrimSuffix(path, ".json") + ".state"
+		}
+		abs, err := filepath.Abs(config.StateFile)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving state file for %s: %v", path, err)
+		}
+		if other, ok := stateFiles[abs]; ok {
+			return nil, fmt.Errorf("%s and %s use the same state file, %s", other, path, config.StateFile)
+		}
+		stateFiles[abs] = path
+		runs = append(runs, &configRun{Path: path, Config: config})
+	}
+
+	// State files kept in the same directory aren't configs
+	configs := runs[:0]
+	for _, r := range runs {
+		abs, err := filepath.Abs(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving %s: %v", r.Path, err)
+		}
+		if _, ok := stateFiles[abs]; !ok {
+			configs = append(configs, r)
+		}
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no config files found in %s", dir)
+	}
+	return configs, nil
+}
+
+// runConfig sets up the OCR provider and downloader for config and runs it
+// once, holding its lock.
+func runConfig(ctx context.Context, config *Config, opts runOptions) error {
+	unlock, err := acquireLock(lockPath(config))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	proxyURL, err := parseProxyURL(config.Proxy)
+	if err != nil {
+		return fmt.Errorf("error in proxy config: %v", err)
+	}
+	client, err := newVisionClient(ctx, config, proxyURL)
+	if err != nil {
+		return fmt.Errorf("error creating Vision client: %v", err)
+	}
+	defer client.Close()
+
+	downloader, err := newDownloader(config.Download, config.BaseURL, proxyURL)
+	if err != nil {
+		return fmt.Errorf("error configuring downloads: %v", err)
+	}
+
+	if config.LowResource {
+		opts.Workers = 1
+	}
+	return run(ctx, config, visionOCR{client}, downloader, opts)
+}
+
+// printRunAllReport prints each config's run summary and returns the number
+// of runs that failed.
+func printRunAllReport(runs []*configRun) int {
+	failed := 0
+	var total RunSummary
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nCONFIG\tFOUND\tPROCESSED\tUPDATED\tREVIEW\tERRORS\tRESULT")
+	for _, r := range runs {
+		result := "ok"
+		switch {
+		case errors.Is(r.Err, errInterrupted):
+			result = "interrupted"
+		case r.Err != nil:
+			result = "failed: " + r.Err.Error()
+			failed++
+		}
+
+		s := r.Summary
+		if s == nil {
+			s = &RunSummary{}
+		}
+		total.Found += s.Found
+		total.Processed += s.Processed
+		total.Updated += s.Updated
+		total.ReviewTasks += s.ReviewTasks
+		total.Errors += s.Errors
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			r.Path, s.Found, s.Processed, s.Updated, s.ReviewTasks, s.Errors, result)
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t%d\t%d\t%d\t%d of %d failed\n",
+		total.Found, total.Processed, total.Updated, total.ReviewTasks, total.Errors, failed, len(runs))
+	_ = tw.Flush()
+	return failed
+}
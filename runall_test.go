@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestConfigRunOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         runOptions
+		parallel     int
+		want         runOptions
+		wantProgress bool
+	}{
+		{
+			name:     "files per config",
+			opts:     runOptions{OutputFile: "out/summary.json", Events: "events.jsonl", Report: "changes.html"},
+			parallel: 1,
+			want:     runOptions{OutputFile: "out/summary-home.json", Events: "events-home.jsonl", Report: "changes-home.html"},
+		},
+		{
+			name:     "no extension",
+			opts:     runOptions{Report: "report"},
+			parallel: 1,
+			want:     runOptions{Report: "report-home"},
+		},
+		{
+			name:     "stdout",
+			opts:     runOptions{Events: "-"},
+			parallel: 1,
+			want:     runOptions{Events: "-"},
+		},
+		{
+			name:         "sequential keeps the progress bar",
+			opts:         runOptions{Progress: newProgressBar(os.Stdout)},
+			parallel:     1,
+			wantProgress: true,
+		},
+		{
+			name:     "parallel drops the progress bar",
+			opts:     runOptions{Progress: newProgressBar(os.Stdout)},
+			parallel: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := configRunOptions(tt.opts, "configs/home.json", tt.parallel)
+			if got.OutputFile != tt.want.OutputFile || got.Events != tt.want.Events || got.Report != tt.want.Report {
+				t.Errorf("files = %q, %q, %q, want %q, %q, %q",
+					got.OutputFile, got.Events, got.Report, tt.want.OutputFile, tt.want.Events, tt.want.Report)
+			}
+			if (got.Progress != nil) != tt.wantProgress {
+				t.Errorf("progress bar = %v, want %v", got.Progress != nil, tt.wantProgress)
+			}
+		})
+	}
+}
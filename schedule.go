@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression
+// (minute, hour, day of month, month, day of week).
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // bitsets of allowed values
+	domStar, dowStar              bool
+}
+
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseSchedule parses a standard five-field cron expression, or one of the
+// descriptors @yearly, @monthly, @weekly, @daily, and @hourly.
+func parseSchedule(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := scheduleDescriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields", expr)
+	}
+
+	var s Schedule
+	var err error
+	if s.minute, err = parseScheduleField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute in schedule %q: %v", expr, err)
+	}
+	if s.hour, err = parseScheduleField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour in schedule %q: %v", expr, err)
+	}
+	if s.dom, err = parseScheduleField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month in schedule %q: %v", expr, err)
+	}
+	if s.month, err = parseScheduleField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month in schedule %q: %v", expr, err)
+	}
+	if s.dow, err = parseScheduleField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week in schedule %q: %v", expr, err)
+	}
+	// Both 0 and 7 mean Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+
+	return &s, nil
+}
+
+// parseScheduleField parses a comma-separated list of values, ranges (a-b),
+// and steps (*/n or a-b/n) into a bitset.
+func parseScheduleField(field string, lo, hi int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		start, end := lo, hi
+		if rangePart != "*" {
+			a, b, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if hasStep {
+				end = hi
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return 0, fmt.Errorf("value out of range %d-%d: %q", lo, hi, part)
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	// As in cron, if both day fields are restricted, either may match
+	if !s.domStar && !s.dowStar {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// Next returns the first time matching the schedule that is strictly after t.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = startOf(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = startOf(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			// Not t.Truncate(time.Hour), which truncates in UTC and so
+			// misses the top of the hour in zones with half-hour offsets
+			t = startOf(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	// Unsatisfiable schedule (e.g. February 31st)
+	return time.Time{}
+}
+
+// startOf returns next, the start of a later month, day, or hour than t. If
+// that local time was skipped by a DST change, time.Date resolves it to a
+// time before the change, possibly before t, so step past the change instead.
+func startOf(t, next time.Time) time.Time {
+	if !next.After(t) {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
+
+// scheduleTarget is what the daemon runs on one schedule: an album with its
+// own schedule, or the other configured albums on the global schedule.
+type scheduleTarget struct {
+	expr     string
+	schedule *Schedule
+	album    string   // the album with its own schedule, or "" for the global schedule
+	skip     []string // for the global schedule, the albums with their own
+	next     time.Time
+}
+
+// scheduleTargets returns the targets for the global schedule, if set, and
+// each album with its own schedule, in album ID order.
+func scheduleTargets(config *Config) ([]*scheduleTarget, error) {
+	var albums []*scheduleTarget
+	for _, albumID := range slices.Sorted(maps.Keys(config.Albums)) {
+		expr := config.Albums[albumID].Schedule
+		if expr == "" {
+			continue
+		}
+		schedule, err := parseSchedule(expr)
+		if err != nil {
+			return nil, fmt.Errorf("error in schedule for album %s: %v", albumID, err)
+		}
+		albums = append(albums, &scheduleTarget{expr: expr, schedule: schedule, album: albumID})
+	}
+
+	if config.Schedule == "" {
+		if len(albums) == 0 {
+			return nil, fmt.Errorf("daemon mode requires a schedule in the config file")
+		}
+		return albums, nil
+	}
+	schedule, err := parseSchedule(config.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	global := &scheduleTarget{expr: config.Schedule, schedule: schedule}
+	for _, target := range albums {
+		global.skip = append(global.skip, target.album)
+	}
+	return append([]*scheduleTarget{global}, albums...), nil
+}
+
+// lastRun returns when the target's last run started.
+func (t *scheduleTarget) lastRun(state *State) time.Time {
+	if t.album == "" {
+		return state.LastRun
+	}
+	return state.AlbumLastRun[t.album]
+}
+
+// setLastRun records when the target's last run started.
+func (t *scheduleTarget) setLastRun(state *State, started time.Time) {
+	if t.album == "" {
+		state.LastRun = started
+	} else {
+		state.AlbumLastRun[t.album] = started
+	}
+}
+
+// logAttrs describes the target in log messages.
+func (t *scheduleTarget) logAttrs() []any {
+	if t.album == "" {
+		return nil
+	}
+	return []any{"album_id", t.album}
+}
+
+// nextTargets returns the earliest time any of targets is next due, and the
+// targets due then, in order.
+func nextTargets(targets []*scheduleTarget) (time.Time, []*scheduleTarget) {
+	var next time.Time
+	var due []*scheduleTarget
+	for _, target := range targets {
+		switch {
+		case next.IsZero() || target.next.Before(next):
+			next, due = target.next, []*scheduleTarget{target}
+		case target.next.Equal(next):
+			due = append(due, target)
+		}
+	}
+	return next, due
+}
+
+// runDaemon runs the configured albums on the configured schedules until the
+// process is interrupted: each album with its own schedule alone on that
+// schedule, and the others on the global schedule. Runs never overlap; runs
+// due at the same time run one after another. If a scheduled run was missed
+// while the daemon was not running, it starts immediately.
+func runDaemon(ctx context.Context, config *Config, ocr OCRProvider, downloader *Downloader, opts runOptions) error {
+	targets, err := scheduleTargets(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return fmt.Errorf("error loading state: %v", err)
+	}
+
+	// Hold album leases for as long as the daemon runs, so that another
+	// replica doesn't take them between runs
+	if config.Lease.Enabled {
+		leases, err := openLeases(config)
+		if err != nil {
+			return err
+		}
+		defer leases.Close()
+		opts.Leases = leases
+	}
+
+	var nextRun atomic.Value // time.Time
+	if config.StatusAddr != "" {
+		serveStatus(ctx, config, &nextRun)
+	}
+	if config.GRPCAddr != "" {
+		if err := serveGRPC(ctx, config, ocr, &nextRun); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for _, target := range targets {
+		target.next = target.schedule.Next(now)
+		if last := target.lastRun(state); !last.IsZero() {
+			if missed := target.schedule.Next(last); !missed.IsZero() && !missed.After(now) {
+				slog.Info("Missed scheduled run; running now", append(target.logAttrs(), "scheduled", missed.Format(time.RFC3339))...)
+				target.next = now
+			}
+		}
+	}
+
+	for {
+		for _, target := range targets {
+			if target.next.IsZero() {
+				return fmt.Errorf("schedule %q never matches", target.expr)
+			}
+		}
+		next, due := nextTargets(targets)
+
+		for _, target := range due {
+			slog.Info("Next run scheduled", append(target.logAttrs(), "at", next.Format(time.RFC3339))...)
+		}
+		nextRun.Store(next)
+
+		// Signals are only handled here between runs; during a run, run
+		// handles them itself so that photos in progress can finish
+		waitCtx, stopWaiting := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-waitCtx.Done():
+			stopWaiting()
+			timer.Stop()
+			slog.Info("Shutting down")
+			return nil
+		case <-timer.C:
+		}
+		stopWaiting()
+
+		for _, target := range due {
+			targetOpts := opts
+			targetOpts.Album, targetOpts.SkipAlbums = target.album, target.skip
+
+			started := time.Now()
+			err := run(ctx, config, ocr, downloader, targetOpts)
+			if err != nil && !errors.Is(err, errInterrupted) {
+				slog.Error("Run failed", append(target.logAttrs(), "error", err)...)
+			}
+
+			// Record the run so missed slots can be detected after a
+			// restart
+			if state, err := loadState(config.StateFile); err != nil {
+				slog.Error("Error loading state", "error", err)
+			} else {
+				target.setLastRun(state, started)
+				if err := saveState(config.StateFile, state); err != nil {
+					slog.Error("Error saving state", "error", err)
+				}
+			}
+
+			if errors.Is(err, errInterrupted) {
+				slog.Info("Shutting down")
+				return nil
+			}
+			target.next = target.schedule.Next(time.Now())
+		}
+	}
+}
+
+// serveStatus serves the last run's summary and the next scheduled run time
+// as JSON at /status, and the run metrics for Prometheus at /metrics, on
+// config.StatusAddr until ctx is canceled.
+func serveStatus(ctx context.Context, config *Config, nextRun *atomic.Value) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		state, err := loadState(config.StateFile)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error loading state: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		status := struct {
+			LastRun *RunSummary `json:"last_run"`
+			NextRun time.Time   `json:"next_run"`
+		}{LastRun: state.LastRunSummary}
+		if next, ok := nextRun.Load().(time.Time); ok {
+			status.NextRun = next
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			slog.Error("Error writing status response", "error", err)
+		}
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := metrics.Write(w); err != nil {
+			slog.Error("Error writing metrics response", "error", err)
+		}
+	})
+
+	server := &http.Server{Addr: config.StatusAddr, Handler: mux}
+	go func() {
+		slog.Info("Serving status", "url", "http://"+config.StatusAddr+"/status")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Error serving status", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+}
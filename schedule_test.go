@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"0 3 * * *", false},
+		{"*/15 9-17 * * 1-5", false},
+		{"0,30 * 1-15/2 1,6,12 *", false},
+		{"0 12 * * 7", false},
+		{"@daily", false},
+		{" @Hourly ", false},
+		{"0 3 * *", true},
+		{"0 3 * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"a * * * *", true},
+		{"@fortnightly", true},
+	}
+	for _, tt := range tests {
+		_, err := parseSchedule(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSchedule(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		location string
+		from     string
+		want     string // empty if the schedule never matches
+	}{
+		{"daily", "0 3 * * *", "UTC", "2026-10-16T12:00:00Z", "2026-10-17T03:00:00Z"},
+		{"strictly after", "0 3 * * *", "UTC", "2026-10-16T03:00:00Z", "2026-10-17T03:00:00Z"},
+		{"seconds", "* * * * *", "UTC", "2026-10-16T03:00:30Z", "2026-10-16T03:01:00Z"},
+		{"half-hour offset", "0 3 * * *", "Asia/Kolkata", "2026-03-10T12:00:00+05:30", "2026-03-11T03:00:00+05:30"},
+		{"half-hour offset in summer time", "0 3 * * *", "Australia/Adelaide", "2026-01-10T12:00:00+10:30", "2026-01-11T03:00:00+10:30"},
+		{"negative half-hour offset", "0 3 * * *", "America/St_Johns", "2026-06-01T12:00:00-02:30", "2026-06-02T03:00:00-02:30"},
+		{"hourly with half-hour offset", "@hourly", "Asia/Kolkata", "2026-03-10T12:10:00+05:30", "2026-03-10T13:00:00+05:30"},
+		{"skipped hour at DST start", "30 2 * * *", "America/New_York", "2026-03-07T12:00:00-05:00", "2026-03-09T02:30:00-04:00"},
+		{"across DST start", "0 3 * * *", "America/New_York", "2026-03-07T12:00:00-05:00", "2026-03-08T03:00:00-04:00"},
+		{"repeated hour at DST end", "0 * * * *", "America/New_York", "2026-11-01T01:30:00-04:00", "2026-11-01T01:00:00-05:00"},
+		{"across DST end", "0 3 * * *", "America/New_York", "2026-10-31T12:00:00-04:00", "2026-11-01T03:00:00-05:00"},
+		{"DST start at midnight", "0 * * * *", "America/Santiago", "2026-09-05T23:30:00-04:00", "2026-09-06T01:00:00-03:00"},
+		{"weekdays", "*/15 9-17 * * 1-5", "UTC", "2026-10-16T17:50:00Z", "2026-10-19T09:00:00Z"},
+		{"day of month or week", "0 0 13 * 5", "UTC", "2026-10-16T00:30:00Z", "2026-10-23T00:00:00Z"},
+		{"Sunday as 7", "0 12 * * 7", "UTC", "2026-10-16T00:00:00Z", "2026-10-18T12:00:00Z"},
+		{"next year", "@yearly", "UTC", "2026-10-16T00:00:00Z", "2027-01-01T00:00:00Z"},
+		{"never", "0 0 31 2 *", "UTC", "2026-10-16T00:00:00Z", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("parseSchedule(%q): %v", tt.expr, err)
+			}
+			loc, err := time.LoadLocation(tt.location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := time.Parse(time.RFC3339, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := schedule.Next(from.In(loc))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next(%s) = %s, want zero", tt.from, got.Format(time.RFC3339))
+				}
+				return
+			}
+			want, err := time.Parse(time.RFC3339, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got.Format(time.RFC3339), tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		wantAlbum []string // each target's album, "" for the global schedule
+		wantSkip  []string // the global schedule's skipped albums
+		wantErr   bool
+	}{
+		{
+			name:      "global only",
+			config:    Config{Schedule: "@daily", Albums: map[string]AlbumConfig{"a": {ReadOnly: true}}},
+			wantAlbum: []string{""},
+		},
+		{
+			name: "global and albums",
+			config: Config{Schedule: "0 6 * * *", Albums: map[string]AlbumConfig{
+				"feeder": {Schedule: "*/15 * * * *"},
+				"bath":   {Schedule: "@hourly"},
+				"other":  {},
+			}},
+			wantAlbum: []string{"", "bath", "feeder"},
+			wantSkip:  []string{"bath", "feeder"},
+		},
+		{
+			name:      "albums only",
+			config:    Config{Albums: map[string]AlbumConfig{"feeder": {Schedule: "@hourly"}}},
+			wantAlbum: []string{"feeder"},
+		},
+		{
+			name:    "no schedule",
+			config:  Config{Albums: map[string]AlbumConfig{"feeder": {}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid album schedule",
+			config:  Config{Schedule: "@daily", Albums: map[string]AlbumConfig{"feeder": {Schedule: "0 25 * * *"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := scheduleTargets(&tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scheduleTargets error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var albums, skip []string
+			for _, target := range targets {
+				albums = append(albums, target.album)
+				if target.album == "" {
+					skip = target.skip
+				}
+			}
+			if !slices.Equal(albums, tt.wantAlbum) || !slices.Equal(skip, tt.wantSkip) {
+				t.Errorf("albums = %q, skip = %q, want %q, %q", albums, skip, tt.wantAlbum, tt.wantSkip)
+			}
+		})
+	}
+}
+
+func TestNextTargets(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2026, 10, 16, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name    string
+		next    []time.Time // each target's next run, in order
+		want    time.Time
+		wantDue []int
+	}{
+		{"one", []time.Time{at(6)}, at(6), []int{0}},
+		{"earliest", []time.Time{at(6), at(3), at(9)}, at(3), []int{1}},
+		{"ties run in order", []time.Time{at(6), at(3), at(3)}, at(3), []int{1, 2}},
+		{"overdue", []time.Time{at(6), at(1)}, at(1), []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var targets []*scheduleTarget
+			for _, next := range tt.next {
+				targets = append(targets, &scheduleTarget{next: next})
+			}
+			next, due := nextTargets(targets)
+			if !next.Equal(tt.want) {
+				t.Errorf("next = %s, want %s", next, tt.want)
+			}
+			var got []int
+			for _, target := range due {
+				got = append(got, slices.Index(targets, target))
+			}
+			if !slices.Equal(got, tt.wantDue) {
+				t.Errorf("due = %v, want %v", got, tt.wantDue)
+			}
+		})
+	}
+}
+
+func TestRunScheduledAlbums(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      runOptions
+		wantFound bool
+	}{
+		{"configured albums", runOptions{}, true},
+		{"album with its own schedule", runOptions{Album: simulatedAlbumID}, true},
+		{"skipping it on the global schedule", runOptions{SkipAlbums: []string{simulatedAlbumID}}, false},
+		{"another album", runOptions{Album: "elsewhere"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{StateFile: filepath.Join(t.TempDir(), "state.json")}
+			sim, err := startSimulation(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sim.Close()
+			downloader, err := newDownloader(config.Download, config.BaseURL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.opts.DryRun, tt.opts.Output, tt.opts.OutputFile = true, outputJSON, filepath.Join(t.TempDir(), "report.json")
+			if err := run(context.Background(), config, simulatedOCR{}, downloader, tt.opts); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			state, err := loadState(config.StateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.LastRunSummary == nil || (state.LastRunSummary.Found > 0) != tt.wantFound {
+				t.Errorf("last run = %+v, want photos found %v", state.LastRunSummary, tt.wantFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// simulatedPhoto is a photo in the simulated album. Text is what the canned
+// OCR provider "reads" from it; an empty Text means no text is detected.
+type simulatedPhoto struct {
+	ID      string
+	Title   string
+	Type    string
+	Variant string
+	Starred bool
+	Tags    string
+	Text    string
+}
+
+var simulatedPhotos = []simulatedPhoto{
+	{ID: "sim000000000000000000001", Title: "5f1c9a2e-8d3b-4c1e-9a7f-1b2c3d4e5f60", Type: "image/jpeg", Variant: "medium2x", Text: "Northern Cardinal"},
+	{ID: "sim000000000000000000002", Title: "6a2d0b3f-9e4c-4d2f-8b80-2c3d4e5f6071.jpg", Type: "image/jpeg", Variant: "medium2x", Text: "Blue Jay"},
+	{ID: "sim000000000000000000003", Title: "7b3e1c40-af5d-4e30-9c91-3d4e5f607182", Type: "image/jpeg", Variant: "medium2x"},
+	{ID: "sim000000000000000000004", Title: "8c4f2d51-b06e-4f41-8da2-4e5f60718293", Type: "video/mp4", Variant: "small2x", Text: "American Robin"},
+	{ID: "sim000000000000000000005", Title: "Mourning Dove", Type: "image/jpeg", Variant: "medium2x", Text: "Mourning Dove"},
+	{ID: "sim000000000000000000006", Title: "9d503e62-c17f-4052-9eb3-5f60718293a4", Type: "image/jpeg", Variant: "medium2x", Starred: true, Tags: "feeder", Text: "Black-capped Chickadee"},
+	{ID: "sim000000000000000000007", Title: "ae614f73-d280-4163-8fc4-60718293a4b5", Type: "image/jpeg", Variant: "medium", Text: "Downy Woodpecker"},
+}
+
+const simulatedAlbumID = "simulate"
+
+// simulatedGray returns the gray level of the simulated photo at index i.
+// The canned OCR provider identifies which photo it was given by its average
+// brightness, which survives cropping, resizing, and JPEG re-encoding.
+func simulatedGray(i int) uint8 {
+	return uint8(16 + i*32)
+}
+
+// Simulation is a self-contained stand-in for the infrastructure a run
+// needs: a Lychee database seeded with a sample album, a media server
+// serving its photos, and a canned OCR provider.
+type Simulation struct {
+	db     *sql.DB
+	server *httptest.Server
+	dir    string
+}
+
+// startSimulation starts the simulated infrastructure and points config at
+// it. Settings that would reach real infrastructure (notifications, journals,
+// logs, and the state file) are disabled or redirected to a temporary
+// directory; everything else, such as filters and per-album settings, is
+// left as configured.
+func startSimulation(config *Config) (*Simulation, error) {
+	dir, err := os.MkdirTemp("", "lychee-birb-title-simulate-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating simulation directory: %v", err)
+	}
+	s := &Simulation{dir: dir}
+
+	// The database lives in memory for as long as s.db keeps it open
+	dsn := fmt.Sprintf("file:lychee-birb-title-simulate-%d?mode=memory&cache=shared", os.Getpid())
+	s.db, err = sql.Open("sqlite3", dsn)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error opening simulated database: %v", err)
+	}
+	albumID, albumTitle := simulatedAlbumID, "Simulation"
+	if len(config.AlbumIDs) > 0 {
+		albumID = config.AlbumIDs[0]
+	} else if len(config.AlbumTitles) > 0 {
+		albumTitle = config.AlbumTitles[0]
+	} else if !config.AllAlbums {
+		config.AlbumIDs = albumList{albumID}
+	}
+	if err := seedSimulatedDatabase(s.db, albumID, albumTitle); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(serveSimulatedPhoto))
+
+	config.Database.Type = "sqlite"
+	config.Database.Database = dsn
+	config.Database.Schema = ""
+	config.BaseURL = s.server.URL
+	config.UploadsPath = ""
+	config.API = APIConfig{}
+	config.Proxy = ""
+	config.Download = DownloadConfig{
+		MaxBytes:      config.Download.MaxBytes,
+		MaxVideoBytes: config.Download.MaxVideoBytes,
+		Retry:         config.Download.Retry,
+	}
+	config.BirdNET = BirdNETConfig{}
+	config.Notifications = nil
+	config.Metrics = MetricsConfig{}
+	config.Tracing = TracingConfig{}
+	config.Healthcheck = HealthcheckConfig{}
+	config.HTTPSink = HTTPSinkConfig{}
+	config.RareSpecies = RarityConfig{}
+	config.Journal.Type = ""
+	config.SightingsLog = ""
+	config.StateFile = filepath.Join(dir, "state.json")
+	config.LockFile = ""
+	return s, nil
+}
+
+func seedSimulatedDatabase(db *sql.DB, albumID, albumTitle string) error {
+	for _, stmt := range []string{
+		`CREATE TABLE photos (
+			id TEXT PRIMARY KEY, title TEXT, type TEXT, taken_at DATETIME, created_at DATETIME,
+			checksum TEXT, is_starred BOOLEAN, tags TEXT, live_photo_content_id TEXT, description TEXT
+		)`,
+		`CREATE TABLE size_variants (
+			id INTEGER PRIMARY KEY, photo_id TEXT, type INTEGER, short_path TEXT,
+			filesize INTEGER, width INTEGER, height INTEGER
+		)`,
+		`CREATE TABLE photo_album (album_id TEXT, photo_id TEXT)`,
+		`CREATE TABLE albums (id TEXT PRIMARY KEY, _lft INTEGER, _rgt INTEGER)`,
+		`CREATE TABLE base_albums (id TEXT PRIMARY KEY, title TEXT)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("error creating simulated database: %v", err)
+		}
+	}
+
+	// Photos in a smart album aren't filed in a regular one
+	smart := isSmartAlbum(albumID)
+	if !smart {
+		if _, err := db.Exec("INSERT INTO albums VALUES (?, 1, 2)", albumID); err != nil {
+			return fmt.Errorf("error seeding simulated database: %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO base_albums VALUES (?, ?)", albumID, albumTitle); err != nil {
+			return fmt.Errorf("error seeding simulated database: %v", err)
+		}
+	}
+
+	taken := time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)
+	for i, p := range simulatedPhotos {
+		origExt := ".jpg"
+		if strings.HasPrefix(p.Type, "video/") {
+			origExt = ".mp4"
+		}
+		for _, query := range []struct {
+			sql  string
+			args []any
+		}{
+			{"INSERT INTO photos VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULL, NULL)",
+				[]any{p.ID, p.Title, p.Type, taken.Add(time.Duration(i) * time.Hour), taken, fmt.Sprintf("%040d", i), p.Starred, p.Tags}},
+			{"INSERT INTO size_variants (photo_id, type, short_path, filesize, width, height) VALUES (?, ?, ?, ?, ?, ?)",
+				[]any{p.ID, sizeVariantTypes[p.Variant], p.Variant + "/" + p.ID + ".jpg", 100_000, 1440, 1080}},
+			{"INSERT INTO size_variants (photo_id, type, short_path, filesize, width, height) VALUES (?, 0, ?, ?, ?, ?)",
+				[]any{p.ID, "original/" + p.ID + origExt, 4_000_000, 4032, 3024}},
+			{"INSERT INTO photo_album VALUES (?, ?)", []any{albumID, p.ID}},
+		} {
+			if smart && strings.Contains(query.sql, "photo_album") {
+				continue
+			}
+			if _, err := db.Exec(query.sql, query.args...); err != nil {
+				return fmt.Errorf("error seeding simulated database: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// serveSimulatedPhoto serves a JPEG for any size variant of a simulated photo.
+func serveSimulatedPhoto(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(path.Base(r.URL.Path), path.Ext(r.URL.Path))
+	for i, p := range simulatedPhotos {
+		if p.ID != id || path.Ext(r.URL.Path) != ".jpg" {
+			continue
+		}
+		img := image.NewGray(image.Rect(0, 0, 1440, 1080))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.Gray{Y: simulatedGray(i)}}, image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(buf.Bytes())
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// simulatedOCR is a canned OCR provider for the simulated album.
+type simulatedOCR struct{}
+
+func (simulatedOCR) DetectText(ctx context.Context, imagePath string) (string, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("error opening image: %v", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("error decoding image: %v", err)
+	}
+
+	var sum, n float64
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			n++
+		}
+	}
+
+	i := int(math.Round((sum/n - float64(simulatedGray(0))) / float64(simulatedGray(1)-simulatedGray(0))))
+	if i < 0 || i >= len(simulatedPhotos) || simulatedPhotos[i].Text == "" {
+		return "", fmt.Errorf("no text detected")
+	}
+	return simulatedPhotos[i].Text, nil
+}
+
+// PrintAlbum writes the simulated photos' current titles, descriptions, and
+// tags to w.
+func (s *Simulation) PrintAlbum(w io.Writer) error {
+	rows, err := s.db.Query("SELECT id, title, COALESCE(description, ''), COALESCE(tags, '') FROM photos ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("error querying simulated database: %v", err)
+	}
+	defer rows.Close()
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tDESCRIPTION\tTAGS")
+	for rows.Next() {
+		var id, title, description, tags string
+		if err := rows.Scan(&id, &title, &description, &tags); err != nil {
+			return fmt.Errorf("error scanning row: %v", err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", id, title, description, tags)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %v", err)
+	}
+	return tw.Flush()
+}
+
+func (s *Simulation) Close() {
+	if s.server != nil {
+		s.server.Close()
+	}
+	if s.db != nil {
+		_ = s.db.Close()
+	}
+	if err := os.RemoveAll(s.dir); err != nil {
+		slog.Error("Error removing simulation directory", "error", err)
+	}
+}
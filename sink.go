@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// HTTPSinkConfig configures sending photo changes to an HTTP endpoint instead
+// of writing them to Lychee, for galleries or middleware that consume title
+// updates themselves. Photos are still read from the configured database or
+// API.
+type HTTPSinkConfig struct {
+	// Method is the HTTP method (default POST).
+	Method string `json:"method"`
+	// URL is a template for each photo's request URL.
+	URL string `json:"url"`
+	// Headers are added to every request.
+	Headers map[string]string `json:"headers"`
+	// Body is a template for each photo's request body. If it's empty, a
+	// JSON object with the photo ID and its changes is sent.
+	Body string `json:"body"`
+	// TimeoutSeconds limits each request (default 30).
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+const defaultSinkTimeout = 30 * time.Second
+
+// sinkChange is the data the URL and body templates are executed with.
+type sinkChange struct {
+	PhotoID string
+	// New and Old map each changed field ("title", "description", "tags", or
+	// "taken_at") to its new and previous value.
+	New map[string]string
+	Old map[string]string
+	// Detection is the identification that gave the photo its title, or nil
+	// if the title wasn't identified in the photo itself (as with the video
+	// half of a live photo).
+	Detection *DetectionEvent
+}
+
+var sinkTemplateFuncs = template.FuncMap{
+	// json encodes a value as JSON, for use in body templates
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// httpSinkLibrary is a Library whose photo updates are sent to an HTTP
+// endpoint; everything else is handled by the wrapped Library.
+type httpSinkLibrary struct {
+	Library
+
+	client  *http.Client
+	method  string
+	url     *template.Template
+	body    *template.Template
+	headers map[string]string
+}
+
+func newHTTPSinkLibrary(library Library, config *Config) (*httpSinkLibrary, error) {
+	c := config.HTTPSink
+	urlTemplate, err := template.New("url").Funcs(sinkTemplateFuncs).Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing http_sink url template: %v", err)
+	}
+	var bodyTemplate *template.Template
+	if c.Body != "" {
+		bodyTemplate, err = template.New("body").Funcs(sinkTemplateFuncs).Parse(c.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing http_sink body template: %v", err)
+		}
+	}
+
+	method := http.MethodPost
+	if c.Method != "" {
+		method = strings.ToUpper(c.Method)
+	}
+	timeout := defaultSinkTimeout
+	if c.TimeoutSeconds > 0 {
+		timeout = time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	return &httpSinkLibrary{
+		Library: library,
+		client:  config.httpClient(timeout),
+		method:  method,
+		url:     urlTemplate,
+		body:    bodyTemplate,
+		headers: c.Headers,
+	}, nil
+}
+
+// UpdatePhotos sends a request for each photo in turn, stopping at the first
+// failure.
+func (l *httpSinkLibrary) UpdatePhotos(updates []photoUpdate) (int, error) {
+	for i, update := range updates {
+		if err := l.send(update); err != nil {
+			return i, fmt.Errorf("error updating photo %s: %v", update.PhotoID, err)
+		}
+	}
+	return len(updates), nil
+}
+
+// UpdatePhoto sends all of a photo's changes in a single request.
+func (l *httpSinkLibrary) UpdatePhoto(photoID string, changes []fieldChange) error {
+	return l.send(photoUpdate{PhotoID: photoID, Changes: changes})
+}
+
+func (l *httpSinkLibrary) send(update photoUpdate) error {
+	if len(update.Changes) == 0 {
+		return nil
+	}
+	change := sinkChange{
+		PhotoID:   update.PhotoID,
+		New:       make(map[string]string, len(update.Changes)),
+		Old:       make(map[string]string, len(update.Changes)),
+		Detection: update.Detection,
+	}
+	for _, c := range update.Changes {
+		change.New[c.Field] = c.NewValue
+		change.Old[c.Field] = c.OldValue
+	}
+
+	var endpoint strings.Builder
+	if err := l.url.Execute(&endpoint, change); err != nil {
+		return fmt.Errorf("error rendering http_sink url: %v", err)
+	}
+	var body bytes.Buffer
+	if l.body != nil {
+		if err := l.body.Execute(&body, change); err != nil {
+			return fmt.Errorf("error rendering http_sink body: %v", err)
+		}
+	} else {
+		payload := map[string]any{
+			"photo_id": change.PhotoID,
+			"changes":  change.New,
+		}
+		if change.Detection != nil {
+			payload["detection"] = change.Detection
+		}
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return fmt.Errorf("error encoding http_sink body: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, l.method, strings.TrimSpace(endpoint.String()), &body)
+	if err != nil {
+		return fmt.Errorf("error creating http_sink request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range l.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending to http_sink: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("http_sink %s %s returned %s: %s", l.method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// currentStateVersion is the schema version of the state file written by this
+// version of the program. Bump it, and teach migrateState how to upgrade from
+// the previous version, whenever the State struct changes incompatibly.
+const currentStateVersion = 1
+
+type State struct {
+	Version      int             `json:"version"`
+	NoTextPhotos map[string]bool `json:"no_text_photos"`
+	// NoTextSince records when photos were first found to have no text, for
+	// the delete no_text_action.
+	NoTextSince map[string]time.Time `json:"no_text_since,omitempty"`
+	// NoTextChecked records when photos in NoTextPhotos were last OCRed, for
+	// no_text_recheck_after_days.
+	NoTextChecked map[string]time.Time `json:"no_text_checked,omitempty"`
+	// Failures records the photos whose last attempts failed, for
+	// max_failures.
+	Failures map[string]PhotoFailure `json:"failures,omitempty"`
+	// OCRCache holds the text OCR found in photos, by photo ID, with
+	// cache_ocr.
+	OCRCache map[string]CachedOCR `json:"ocr_cache,omitempty"`
+	// PhotoHashes holds the perceptual hashes of titled photos, by photo ID,
+	// for duplicate detection.
+	PhotoHashes map[string]PhotoHash `json:"photo_hashes,omitempty"`
+	// SentTitles records the titles sent to the HTTP sink, by photo ID, since
+	// the photos' titles in Lychee don't change.
+	SentTitles map[string]string `json:"sent_titles,omitempty"`
+	// NotifiedSpecies records when a species notification was first sent
+	// for each species, so each is only sent once.
+	NotifiedSpecies map[string]time.Time `json:"notified_species,omitempty"`
+	// LastRun is when daemon mode last started a scheduled run, and
+	// AlbumLastRun when it last started each album with its own schedule.
+	LastRun      time.Time            `json:"last_run"`
+	AlbumLastRun map[string]time.Time `json:"album_last_run,omitempty"`
+	// LastRunSummary describes the most recent run that completed.
+	LastRunSummary *RunSummary `json:"last_run_summary,omitempty"`
+
+	// saved holds the photos' rows as last loaded from or saved to a SQLite
+	// state file, so that only changed rows are written.
+	saved map[string]stateRow
+}
+
+// PhotoFailure counts a photo's consecutive failed attempts.
+type PhotoFailure struct {
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+}
+
+// RunSummary records what a run did.
+type RunSummary struct {
+	RunID       string    `json:"run_id"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	DryRun      bool      `json:"dry_run"`
+	Found       int       `json:"found"`
+	Processed   int       `json:"processed"`
+	Updated     int       `json:"updated"`
+	ReviewTasks int       `json:"review_tasks"`
+	Oversized   int       `json:"oversized"`
+	Errors      int       `json:"errors"`
+	// Remaining counts photos left unprocessed because the run was cut short.
+	Remaining int `json:"remaining"`
+	Deleted   int `json:"deleted"`
+}
+
+func (s RunSummary) String() string {
+	summary := fmt.Sprintf("Found %d photos, processed %d photos, updated %d photos, created %d review tasks",
+		s.Found, s.Processed, s.Updated, s.ReviewTasks)
+	if s.Oversized > 0 {
+		summary += fmt.Sprintf(", skipped %d oversized photos", s.Oversized)
+	}
+	if s.Errors > 0 {
+		summary += fmt.Sprintf(", %d errors", s.Errors)
+	}
+	if s.Deleted > 0 {
+		summary += fmt.Sprintf(", deleted %d photos without text", s.Deleted)
+	}
+	if s.Remaining > 0 {
+		summary += fmt.Sprintf(", stopped early with %d photos remaining", s.Remaining)
+	}
+	return summary
+}
+
+func newState() *State {
+	return &State{
+		Version:      currentStateVersion,
+		NoTextPhotos: make(map[string]bool),
+		NoTextSince:  make(map[string]time.Time),
+		PhotoHashes:  make(map[string]PhotoHash),
+		SentTitles:   make(map[string]string),
+
+		NoTextChecked:   make(map[string]time.Time),
+		Failures:        make(map[string]PhotoFailure),
+		OCRCache:        make(map[string]CachedOCR),
+		NotifiedSpecies: make(map[string]time.Time),
+		AlbumLastRun:    make(map[string]time.Time),
+	}
+}
+
+// photoIDs returns the IDs of all the photos the state has entries for, in
+// no particular order.
+func (s *State) photoIDs() []string {
+	seen := make(map[string]bool)
+	var ids []string
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for id := range s.NoTextPhotos {
+		add(id)
+	}
+	for id := range s.NoTextSince {
+		add(id)
+	}
+	for id := range s.NoTextChecked {
+		add(id)
+	}
+	for id := range s.Failures {
+		add(id)
+	}
+	for id := range s.OCRCache {
+		add(id)
+	}
+	for id := range s.PhotoHashes {
+		add(id)
+	}
+	for id := range s.SentTitles {
+		add(id)
+	}
+	return ids
+}
+
+// forgetPhoto removes all of a photo's entries from the state.
+func (s *State) forgetPhoto(id string) {
+	delete(s.NoTextPhotos, id)
+	delete(s.NoTextSince, id)
+	delete(s.NoTextChecked, id)
+	delete(s.Failures, id)
+	delete(s.OCRCache, id)
+	delete(s.PhotoHashes, id)
+	delete(s.SentTitles, id)
+}
+
+// migrateState upgrades state decoded from an older schema version in place.
+func migrateState(state *State) error {
+	if state.Version > currentStateVersion {
+		return fmt.Errorf("state schema version %d is newer than this program supports (%d)",
+			state.Version, currentStateVersion)
+	}
+
+	// Version 0 is the original unversioned format, which is otherwise
+	// identical to version 1
+	if state.Version < 1 {
+		state.Version = 1
+	}
+
+	if state.NoTextPhotos == nil {
+		state.NoTextPhotos = make(map[string]bool)
+	}
+	if state.NoTextSince == nil {
+		state.NoTextSince = make(map[string]time.Time)
+	}
+	if state.NoTextChecked == nil {
+		state.NoTextChecked = make(map[string]time.Time)
+	}
+	if state.Failures == nil {
+		state.Failures = make(map[string]PhotoFailure)
+	}
+	if state.OCRCache == nil {
+		state.OCRCache = make(map[string]CachedOCR)
+	}
+	if state.PhotoHashes == nil {
+		state.PhotoHashes = make(map[string]PhotoHash)
+	}
+	if state.SentTitles == nil {
+		state.SentTitles = make(map[string]string)
+	}
+	if state.NotifiedSpecies == nil {
+		state.NotifiedSpecies = make(map[string]time.Time)
+	}
+	if state.AlbumLastRun == nil {
+		state.AlbumLastRun = make(map[string]time.Time)
+	}
+	return nil
+}
+
+func decodeState(r io.Reader) (*State, error) {
+	var state State
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, err
+	}
+	if err := migrateState(&state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func loadState(path string) (*State, error) {
+	if err := checkStatePath(path); err != nil {
+		return nil, err
+	}
+	if isSQLiteStatePath(path) {
+		return loadSQLiteState(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Return empty state if file doesn't exist
+			return newState(), nil
+		}
+		return nil, fmt.Errorf("error opening state file: %v", err)
+	}
+	defer file.Close()
+
+	state, err := decodeState(file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding state file: %v", err)
+	}
+
+	return state, nil
+}
+
+// saveState writes the state to a temporary file and renames it into place,
+// so that a crash while saving leaves the previous state file intact. Saves
+// are serialized by a lock on a file next to the state file.
+func saveState(path string, state *State) error {
+	if isSQLiteStatePath(path) {
+		return saveSQLiteState(path, state)
+	}
+
+	unlock, err := waitForLock(path + ".save-lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating state file: %v", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+	defer file.Close()
+
+	// Keep the existing file's permissions
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := file.Chmod(mode); err != nil {
+		return fmt.Errorf("error setting state file permissions: %v", err)
+	}
+
+	if err := json.NewEncoder(file).Encode(state); err != nil {
+		return fmt.Errorf("error encoding state file: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("error replacing state file: %v", err)
+	}
+
+	return nil
+}
+
+// readStateExport reads a state export, or any state file: a SQLite
+// database if path has a SQLite extension, and JSON otherwise.
+func readStateExport(path string) (*State, error) {
+	if err := checkStatePath(path); err != nil {
+		return nil, err
+	}
+	if isSQLiteStatePath(path) {
+		// Opening a missing database would create it
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("error opening import file: %v", err)
+		}
+		return loadSQLiteState(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening import file: %v", err)
+	}
+	defer file.Close()
+
+	state, err := decodeState(file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding import file: %v", err)
+	}
+	return state, nil
+}
+
+// runStateCommand implements the "state" command:
+//
+//	state export [FILE]   write the state to FILE (or stdout) as JSON
+//	state import FILE     merge the state exported to FILE into the state file
+//	state prune           remove entries for photos no longer in Lychee
+func runStateCommand(config *Config, args []string, dryRun bool) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: lychee-birb-title [flags] state export [FILE] | state import FILE | state prune")
+	}
+
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return fmt.Errorf("error loading state: %v", err)
+	}
+
+	switch args[0] {
+	case "export":
+		if len(args) > 1 {
+			if err := checkStatePath(args[1]); err != nil {
+				return err
+			}
+		}
+		// A SQLite export is a new state database, which can be used as a
+		// statefile or imported elsewhere
+		if len(args) > 1 && isSQLiteStatePath(args[1]) {
+			if _, err := os.Stat(args[1]); err == nil {
+				return fmt.Errorf("export file %s already exists; import into it instead", args[1])
+			}
+			state.saved = nil
+			if err := saveSQLiteState(args[1], state); err != nil {
+				return fmt.Errorf("error writing export: %v", err)
+			}
+			return nil
+		}
+
+		out := os.Stdout
+		if len(args) > 1 {
+			file, err := os.Create(args[1])
+			if err != nil {
+				return fmt.Errorf("error creating export file: %v", err)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(state); err != nil {
+			return fmt.Errorf("error writing export: %v", err)
+		}
+		return nil
+
+	case "import":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: lychee-birb-title [flags] state import FILE")
+		}
+
+		imported, err := readStateExport(args[1])
+		if err != nil {
+			return err
+		}
+
+		added := 0
+		for id, noText := range imported.NoTextPhotos {
+			if noText && !state.NoTextPhotos[id] {
+				state.NoTextPhotos[id] = true
+				added++
+			}
+		}
+		// Other per-photo state is merged too, so that importing moves
+		// everything to a new state file
+		for id, since := range imported.NoTextSince {
+			if _, ok := state.NoTextSince[id]; !ok {
+				state.NoTextSince[id] = since
+			}
+		}
+		for id, checked := range imported.NoTextChecked {
+			if _, ok := state.NoTextChecked[id]; !ok {
+				state.NoTextChecked[id] = checked
+			}
+		}
+		for id, failure := range imported.Failures {
+			if _, ok := state.Failures[id]; !ok {
+				state.Failures[id] = failure
+			}
+		}
+		for id, cached := range imported.OCRCache {
+			if _, ok := state.OCRCache[id]; !ok {
+				state.OCRCache[id] = cached
+			}
+		}
+		for id, hash := range imported.PhotoHashes {
+			if _, ok := state.PhotoHashes[id]; !ok {
+				state.PhotoHashes[id] = hash
+			}
+		}
+		for id, title := range imported.SentTitles {
+			if _, ok := state.SentTitles[id]; !ok {
+				state.SentTitles[id] = title
+			}
+		}
+		for species, notified := range imported.NotifiedSpecies {
+			if _, ok := state.NotifiedSpecies[species]; !ok {
+				state.NotifiedSpecies[species] = notified
+			}
+		}
+
+		if dryRun {
+			slog.Info("Dry run: would import no-text photos", "count", added, "statefile", config.StateFile)
+			return nil
+		}
+		if err := saveState(config.StateFile, state); err != nil {
+			return err
+		}
+		slog.Info("Imported no-text photos", "count", added, "statefile", config.StateFile)
+		return nil
+
+	case "prune":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: lychee-birb-title [flags] state prune")
+		}
+		return pruneState(config, state, dryRun)
+
+	default:
+		return fmt.Errorf("unknown state command: %s", args[0])
+	}
+}
+
+// pruneState removes the entries of photos that have been deleted from
+// Lychee.
+func pruneState(config *Config, state *State, dryRun bool) error {
+	proxyURL, err := parseProxyURL(config.Proxy)
+	if err != nil {
+		return fmt.Errorf("error in proxy config: %v", err)
+	}
+	downloader, err := newDownloader(config.Download, config.BaseURL, proxyURL)
+	if err != nil {
+		return fmt.Errorf("error configuring downloads: %v", err)
+	}
+	library, err := openLibrary(config, downloader)
+	if err != nil {
+		return err
+	}
+	defer library.Close()
+
+	ids := state.photoIDs()
+	existing, err := library.ExistingPhotos(ids)
+	if err != nil {
+		return err
+	}
+	pruned := 0
+	for _, id := range ids {
+		if !existing[id] {
+			state.forgetPhoto(id)
+			pruned++
+		}
+	}
+
+	if dryRun {
+		slog.Info("Dry run: would prune photos", "pruned", pruned, "total", len(ids), "statefile", config.StateFile)
+		return nil
+	}
+	if pruned > 0 {
+		if err := saveState(config.StateFile, state); err != nil {
+			return err
+		}
+	}
+	slog.Info("Pruned photos", "pruned", pruned, "total", len(ids), "statefile", config.StateFile)
+	return nil
+}
+
+// printLastRun implements the -last-run flag, printing the last run's summary
+// from the state file as JSON.
+func printLastRun(config *Config) error {
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return fmt.Errorf("error loading state: %v", err)
+	}
+	if state.LastRunSummary == nil {
+		return fmt.Errorf("no completed runs recorded in %s", config.StateFile)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state.LastRunSummary)
+}
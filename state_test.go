@@ -0,0 +1,127 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeState(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{"unversioned", `{"no_text_photos": {"a": true}}`, false},
+		{"version 1", `{"version": 1, "no_text_photos": {"a": true}, "sent_titles": {"b": "Blue Jay"}}`, false},
+		{"newer version", `{"version": 99, "no_text_photos": {}}`, true},
+		{"invalid", `{"version": `, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := decodeState(strings.NewReader(tt.json))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeState error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if state.Version != currentStateVersion {
+				t.Errorf("Version = %d, want %d", state.Version, currentStateVersion)
+			}
+			if !state.NoTextPhotos["a"] {
+				t.Errorf("NoTextPhotos = %v, want a", state.NoTextPhotos)
+			}
+			// Migration leaves every map ready to write to
+			if state.NoTextSince == nil || state.NoTextChecked == nil || state.Failures == nil || state.OCRCache == nil ||
+				state.PhotoHashes == nil || state.SentTitles == nil || state.NotifiedSpecies == nil {
+				t.Errorf("decodeState left a nil map: %+v", state)
+			}
+		})
+	}
+}
+
+func TestSQLiteStateRoundTrip(t *testing.T) {
+	now := time.Date(2026, 5, 1, 6, 30, 0, 0, time.UTC)
+	state := newState()
+	state.NoTextPhotos["none"] = true
+	state.NoTextSince["none"] = now
+	state.NoTextChecked["none"] = now.Add(time.Hour)
+	state.Failures["failed"] = PhotoFailure{Attempts: 2, LastError: "timeout"}
+	state.OCRCache["titled"] = CachedOCR{Text: "Blue Jay", Settings: "v1"}
+	state.PhotoHashes["titled"] = PhotoHash{Hash: "00ff00ff00ff00ff", Title: "Blue Jay"}
+	state.SentTitles["titled"] = "Blue Jay"
+	state.NotifiedSpecies["Blue Jay"] = now
+	state.LastRun = now
+	state.LastRunSummary = &RunSummary{RunID: "run", Found: 3, Processed: 3, Updated: 1}
+
+	path := filepath.Join(t.TempDir(), "state.db")
+	if err := saveSQLiteState(path, state); err != nil {
+		t.Fatalf("saveSQLiteState: %v", err)
+	}
+	for _, load := range []struct {
+		name string
+		f    func(string) (*State, error)
+	}{
+		{"loadSQLiteState", loadSQLiteState},
+		{"readStateExport", readStateExport},
+	} {
+		loaded, err := load.f(path)
+		if err != nil {
+			t.Fatalf("%s: %v", load.name, err)
+		}
+		loaded.saved, state.saved = nil, nil
+		if !reflect.DeepEqual(loaded, state) {
+			t.Errorf("%s = %+v, want %+v", load.name, loaded, state)
+		}
+	}
+
+	// Saving again writes only the changed rows, and removes forgotten ones
+	loaded, err := loadSQLiteState(path)
+	if err != nil {
+		t.Fatalf("loadSQLiteState: %v", err)
+	}
+	loaded.forgetPhoto("failed")
+	loaded.SentTitles["other"] = "House Finch"
+	if err := saveSQLiteState(path, loaded); err != nil {
+		t.Fatalf("saveSQLiteState: %v", err)
+	}
+	reloaded, err := loadSQLiteState(path)
+	if err != nil {
+		t.Fatalf("loadSQLiteState: %v", err)
+	}
+	if _, ok := reloaded.Failures["failed"]; ok {
+		t.Errorf("Failures = %v, want the forgotten photo removed", reloaded.Failures)
+	}
+	if reloaded.SentTitles["other"] != "House Finch" || reloaded.SentTitles["titled"] != "Blue Jay" {
+		t.Errorf("SentTitles = %v", reloaded.SentTitles)
+	}
+
+	if _, err := readStateExport(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("readStateExport of a missing database succeeded, want an error")
+	}
+}
+
+func TestCheckStatePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"state.json", false},
+		{"/var/lib/lychee-birb-title/state.db", false},
+		{"db:state", true},
+		{"mysql://lychee@localhost/lychee", true},
+		{"Postgres://lychee@localhost/lychee", true},
+		{"postgresql://lychee@localhost/lychee", true},
+	}
+	for _, tt := range tests {
+		if err := checkStatePath(tt.path); (err != nil) != tt.wantErr {
+			t.Errorf("checkStatePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+	}
+	if _, err := loadState("db:state"); err == nil {
+		t.Error("loadState of a database table succeeded, want an error")
+	}
+}
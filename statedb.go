@@ -0,0 +1,338 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A state file whose name ends in one of these extensions is a SQLite
+// database with a row per photo, rather than a JSON file. Saving it writes
+// only the photos whose state changed, so it stays fast with large libraries.
+var sqliteStateExtensions = []string{".db", ".sqlite", ".sqlite3"}
+
+// databaseStatePrefixes mark a state path naming a table in a database
+// server, which isn't supported: state is kept in a JSON or SQLite file.
+var databaseStatePrefixes = []string{"db:", "mysql:", "postgres:", "postgresql:"}
+
+// checkStatePath returns an error if path names a database table rather than
+// a state file.
+func checkStatePath(path string) error {
+	lower := strings.ToLower(path)
+	for _, prefix := range databaseStatePrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return fmt.Errorf("state can't be kept in a database table (%s); use a JSON or SQLite state file", path)
+		}
+	}
+	return nil
+}
+
+func isSQLiteStatePath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range sqliteStateExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Photo statuses in the SQLite state store.
+const (
+	photoStatusNoText = "no_text"
+	photoStatusTitled = "titled"
+	photoStatusFailed = "failed"
+)
+
+// stateRow is one photo's row in the SQLite state store.
+type stateRow struct {
+	Status      string
+	Attempts    int
+	LastError   string
+	OCRText     string
+	Title       string // the title a duplicate of the photo is given
+	Hash        string
+	SentTitle   string
+	NoTextSince time.Time
+
+	NoTextChecked time.Time
+	OCRSettings   string
+}
+
+// stateRows flattens the per-photo maps of state into rows.
+func stateRows(state *State) map[string]stateRow {
+	rows := make(map[string]stateRow)
+	update := func(id string, f func(*stateRow)) {
+		row := rows[id]
+		f(&row)
+		rows[id] = row
+	}
+	for id, noText := range state.NoTextPhotos {
+		if noText {
+			update(id, func(r *stateRow) { r.Status = photoStatusNoText })
+		}
+	}
+	for id, since := range state.NoTextSince {
+		update(id, func(r *stateRow) { r.NoTextSince = since })
+	}
+	for id, checked := range state.NoTextChecked {
+		update(id, func(r *stateRow) { r.NoTextChecked = checked })
+	}
+	for id, failure := range state.Failures {
+		update(id, func(r *stateRow) { r.Attempts, r.LastError = failure.Attempts, failure.LastError })
+	}
+	for id, cached := range state.OCRCache {
+		update(id, func(r *stateRow) { r.OCRText, r.OCRSettings = cached.Text, cached.Settings })
+	}
+	for id, hash := range state.PhotoHashes {
+		update(id, func(r *stateRow) { r.Hash, r.Title = hash.Hash, hash.Title })
+	}
+	for id, title := range state.SentTitles {
+		update(id, func(r *stateRow) { r.SentTitle = title })
+	}
+	for id, row := range rows {
+		switch {
+		case row.Status != "":
+		case row.Title != "" || row.SentTitle != "":
+			row.Status = photoStatusTitled
+		case row.Attempts > 0:
+			row.Status = photoStatusFailed
+		}
+		rows[id] = row
+	}
+	return rows
+}
+
+func openSQLiteState(path string) (*sql.DB, error) {
+	// Wait for another process's save to finish rather than failing
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=10000")
+	if err != nil {
+		return nil, fmt.Errorf("error opening state database: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS photos (
+			photo_id TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			ocr_text TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			hash TEXT NOT NULL DEFAULT '',
+			sent_title TEXT NOT NULL DEFAULT '',
+			no_text_since TEXT NOT NULL DEFAULT '',
+			no_text_checked TEXT NOT NULL DEFAULT '',
+			ocr_settings TEXT NOT NULL DEFAULT '',
+			updated_at TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS meta (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating state tables: %v", err)
+	}
+	for _, column := range addedStateColumns {
+		_, err := db.Exec("ALTER TABLE photos ADD COLUMN " + column)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			db.Close()
+			return nil, fmt.Errorf("error adding state column: %v", err)
+		}
+	}
+	return db, nil
+}
+
+// addedStateColumns are the columns added to the photos table since it was
+// first created, which are added to older state databases when they're
+// opened.
+var addedStateColumns = []string{
+	"no_text_checked TEXT NOT NULL DEFAULT ''",
+	"ocr_settings TEXT NOT NULL DEFAULT ''",
+}
+
+func loadSQLiteState(path string) (*State, error) {
+	db, err := openSQLiteState(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	state := newState()
+	meta, err := db.Query("SELECT key, value FROM meta")
+	if err != nil {
+		return nil, fmt.Errorf("error querying state: %v", err)
+	}
+	defer meta.Close()
+	for meta.Next() {
+		var key, value string
+		if err := meta.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("error scanning state: %v", err)
+		}
+		switch key {
+		case "version":
+			state.Version, err = strconv.Atoi(value)
+		case "last_run":
+			state.LastRun, err = time.Parse(time.RFC3339Nano, value)
+		case "last_run_summary":
+			err = json.Unmarshal([]byte(value), &state.LastRunSummary)
+		case "notified_species":
+			err = json.Unmarshal([]byte(value), &state.NotifiedSpecies)
+		case "album_last_run":
+			err = json.Unmarshal([]byte(value), &state.AlbumLastRun)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding state %s: %v", key, err)
+		}
+	}
+	if err := meta.Err(); err != nil {
+		return nil, fmt.Errorf("error querying state: %v", err)
+	}
+	if err := migrateState(state); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT photo_id, status, attempts, last_error, ocr_text, title, hash, sent_title, no_text_since, no_text_checked, ocr_settings FROM photos")
+	if err != nil {
+		return nil, fmt.Errorf("error querying state: %v", err)
+	}
+	defer rows.Close()
+	state.saved = make(map[string]stateRow)
+	for rows.Next() {
+		var id, since, checked string
+		var row stateRow
+		if err := rows.Scan(&id, &row.Status, &row.Attempts, &row.LastError, &row.OCRText,
+			&row.Title, &row.Hash, &row.SentTitle, &since, &checked, &row.OCRSettings); err != nil {
+			return nil, fmt.Errorf("error scanning state: %v", err)
+		}
+		row.NoTextSince = parseStateTime(since)
+		row.NoTextChecked = parseStateTime(checked)
+		state.saved[id] = row
+
+		if row.Status == photoStatusNoText {
+			state.NoTextPhotos[id] = true
+		}
+		if !row.NoTextSince.IsZero() {
+			state.NoTextSince[id] = row.NoTextSince
+		}
+		if !row.NoTextChecked.IsZero() {
+			state.NoTextChecked[id] = row.NoTextChecked
+		}
+		if row.Attempts > 0 {
+			state.Failures[id] = PhotoFailure{Attempts: row.Attempts, LastError: row.LastError}
+		}
+		if row.OCRSettings != "" {
+			state.OCRCache[id] = CachedOCR{Text: row.OCRText, Settings: row.OCRSettings}
+		}
+		if row.Hash != "" {
+			state.PhotoHashes[id] = PhotoHash{Hash: row.Hash, Title: row.Title}
+		}
+		if row.SentTitle != "" {
+			state.SentTitles[id] = row.SentTitle
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying state: %v", err)
+	}
+	return state, nil
+}
+
+// saveSQLiteState writes the photos whose rows differ from those last loaded
+// or saved, in a single transaction.
+func saveSQLiteState(path string, state *State) error {
+	db, err := openSQLiteState(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting state transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	meta := map[string]string{"version": strconv.Itoa(state.Version)}
+	if !state.LastRun.IsZero() {
+		meta["last_run"] = state.LastRun.Format(time.RFC3339Nano)
+	}
+	if state.LastRunSummary != nil {
+		summary, err := json.Marshal(state.LastRunSummary)
+		if err != nil {
+			return fmt.Errorf("error encoding last run summary: %v", err)
+		}
+		meta["last_run_summary"] = string(summary)
+	}
+	if len(state.NotifiedSpecies) > 0 {
+		notified, err := json.Marshal(state.NotifiedSpecies)
+		if err != nil {
+			return fmt.Errorf("error encoding notified species: %v", err)
+		}
+		meta["notified_species"] = string(notified)
+	}
+	if len(state.AlbumLastRun) > 0 {
+		lastRuns, err := json.Marshal(state.AlbumLastRun)
+		if err != nil {
+			return fmt.Errorf("error encoding album last runs: %v", err)
+		}
+		meta["album_last_run"] = string(lastRuns)
+	}
+	for key, value := range meta {
+		if _, err := tx.Exec("INSERT INTO meta (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value); err != nil {
+			return fmt.Errorf("error writing state: %v", err)
+		}
+	}
+
+	rows := stateRows(state)
+	now := time.Now().UTC().Format(time.RFC3339)
+	for id, row := range rows {
+		if saved, ok := state.saved[id]; ok && saved == row {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO photos (photo_id, status, attempts, last_error, ocr_text, ocr_settings, title, hash, sent_title, no_text_since, no_text_checked, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(photo_id) DO UPDATE SET status = excluded.status, attempts = excluded.attempts,
+				last_error = excluded.last_error, ocr_text = excluded.ocr_text, ocr_settings = excluded.ocr_settings,
+				title = excluded.title, hash = excluded.hash, sent_title = excluded.sent_title,
+				no_text_since = excluded.no_text_since, no_text_checked = excluded.no_text_checked,
+				updated_at = excluded.updated_at`,
+			id, row.Status, row.Attempts, row.LastError, row.OCRText, row.OCRSettings, row.Title, row.Hash, row.SentTitle,
+			formatStateTime(row.NoTextSince), formatStateTime(row.NoTextChecked), now)
+		if err != nil {
+			return fmt.Errorf("error writing state for photo %s: %v", id, err)
+		}
+	}
+	for id := range state.saved {
+		if _, ok := rows[id]; ok {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM photos WHERE photo_id = ?", id); err != nil {
+			return fmt.Errorf("error removing state for photo %s: %v", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing state: %v", err)
+	}
+	state.saved = rows
+	return nil
+}
+
+// formatStateTime and parseStateTime store times as RFC 3339 text, with the
+// zero time stored as "".
+func formatStateTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseStateTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
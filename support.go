@@ -0,0 +1,354 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sensitiveConfigKeys are substrings of config keys whose values are redacted
+// from support bundles.
+var sensitiveConfigKeys = []string{"password", "secret", "token", "key", "authorization", "cookie", "proxy", "latitude", "longitude"}
+
+// sensitiveConfigPaths are config values that are credentials despite their
+// names: a Healthchecks.io ping URL's UUID, URLs that may embed tokens, a
+// Pushover user key, and an ntfy topic, which anyone who knows it can read.
+var sensitiveConfigPaths = []string{"healthcheck.url", "http_sink.url", "journal.url", "notifications[].user", "notifications[].topic"}
+
+// supportLogBytes is how much of the end of the log file a support bundle
+// includes.
+const supportLogBytes = 256 << 10
+
+// requiredColumns returns the Lychee tables and columns this program queries
+// with the given database schema, including the albums table if albumTree is
+// set and album titles if albumTitles is set. Tags are in the photos table
+// unless tagPivot names the table linking photos to the tags table.
+func requiredColumns(schema string, albumTree, albumTitles bool, tagPivot string) map[string][]string {
+	columns := map[string][]string{
+		"photos":        {"id", "title", "type", "taken_at", "created_at", "checksum", "is_starred", "live_photo_content_id"},
+		"size_variants": {"photo_id", "type", "short_path", "filesize", "width", "height"},
+		"photo_album":   {"photo_id", "album_id"},
+	}
+	if tagPivot != "" {
+		columns["tags"] = []string{"id", "name"}
+		columns[tagPivot] = []string{"photo_id", "tag_id"}
+	} else {
+		columns["photos"] = append(columns["photos"], "tags")
+	}
+	if schema == schemaV4 {
+		delete(columns, "photo_album")
+		columns["photos"] = append(columns["photos"], "album_id")
+	}
+	if albumTree || albumTitles {
+		columns["albums"] = []string{"id", "_lft", "_rgt"}
+	}
+	if albumTitles {
+		if schema == schemaV4 {
+			columns["albums"] = append(columns["albums"], "title")
+		} else {
+			columns["base_albums"] = []string{"id", "title"}
+		}
+	}
+	return columns
+}
+
+// runSupportBundle implements the "support-bundle" command, which collects
+// information useful for diagnosing problems into a gzipped tarball. logFile
+// is the -log-file, if any, whose most recent messages are included.
+func runSupportBundle(config *Config, configFile, logFile string, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: lychee-birb-title [flags] support-bundle [FILE]")
+	}
+	outPath := fmt.Sprintf("lychee-birb-title-support-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	if len(args) == 1 {
+		outPath = args[0]
+	}
+
+	configJSON, err := redactedConfig(configFile)
+	if err != nil {
+		configJSON = []byte(fmt.Sprintf("error reading config: %v\n", err))
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"versions.txt", []byte(versionReport())},
+		{"config.json", configJSON},
+		{"state.txt", []byte(stateReport(config))},
+		{"errors.txt", []byte(errorsReport(config))},
+		{"database.txt", []byte(databaseReport(config))},
+	}
+	if logFile != "" {
+		logTail, err := tailFile(logFile, supportLogBytes)
+		if err != nil {
+			logTail = []byte(fmt.Sprintf("error reading log file: %v\n", err))
+		}
+		files = append(files, struct {
+			name string
+			data []byte
+		}{"log.txt", logTail})
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("error creating support bundle: %v", err)
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    "lychee-birb-title-support/" + f.name,
+			Mode:    0o644,
+			Size:    int64(len(f.data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error writing support bundle: %v", err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return fmt.Errorf("error writing support bundle: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error writing support bundle: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("error writing support bundle: %v", err)
+	}
+
+	slog.Info("Wrote support bundle; please review its contents before sharing it", "path", outPath)
+	return nil
+}
+
+// redactedConfig returns the config file's contents with the values of
+// sensitive keys replaced.
+func redactedConfig(configFile string) ([]byte, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(redact(raw, ""), "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// redact replaces sensitive values in v, the config value at path (such as
+// "notifications[].user").
+func redact(v any, path string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			lower := strings.ToLower(k)
+			childPath := lower
+			if path != "" {
+				childPath = path + "." + lower
+			}
+			sensitive := slices.Contains(sensitiveConfigPaths, childPath)
+			for _, s := range sensitiveConfigKeys {
+				if strings.Contains(lower, s) {
+					sensitive = true
+					break
+				}
+			}
+			if sensitive && child != "" && child != nil {
+				v[k] = "REDACTED"
+			} else if lower == "headers" {
+				// Header values are often credentials
+				if headers, ok := child.(map[string]any); ok {
+					for name := range headers {
+						headers[name] = "REDACTED"
+					}
+				}
+			} else {
+				v[k] = redact(child, childPath)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = redact(child, path+"[]")
+		}
+	}
+	return v
+}
+
+func versionReport() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "lychee-birb-title: %s\n", Version)
+	fmt.Fprintf(&b, "go: %s\n", runtime.Version())
+	fmt.Fprintf(&b, "platform: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	return b.String()
+}
+
+func stateReport(config *Config) string {
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return fmt.Sprintf("error loading state: %v\n", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "state file: %s\n", config.StateFile)
+	fmt.Fprintf(&b, "schema version: %d\n", state.Version)
+	fmt.Fprintf(&b, "no-text photos: %d\n", len(state.NoTextPhotos))
+	fmt.Fprintf(&b, "last scheduled run: %s\n", state.LastRun.Format(time.RFC3339))
+	return b.String()
+}
+
+// errorsReport lists the last run's summary and the photos whose last
+// attempts failed, with their errors.
+func errorsReport(config *Config) string {
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		return fmt.Sprintf("error loading state: %v\n", err)
+	}
+
+	var b strings.Builder
+	if s := state.LastRunSummary; s != nil {
+		fmt.Fprintf(&b, "last run: %s (%s to %s)\n", s.RunID, s.StartedAt.Format(time.RFC3339), s.FinishedAt.Format(time.RFC3339))
+		fmt.Fprintf(&b, "%s\n", s)
+	} else {
+		fmt.Fprintf(&b, "last run: none recorded\n")
+	}
+
+	fmt.Fprintf(&b, "\nfailing photos: %d\n", len(state.Failures))
+	for _, id := range slices.Sorted(maps.Keys(state.Failures)) {
+		failure := state.Failures[id]
+		fmt.Fprintf(&b, "%s: %d attempts: %s\n", id, failure.Attempts, failure.LastError)
+	}
+	return b.String()
+}
+
+// tailFile returns up to the last n bytes of the file at path, starting at a
+// line boundary.
+func tailFile(path string, n int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() <= n {
+		return io.ReadAll(file)
+	}
+
+	// Read the byte before the last n too, to tell whether they start a line
+	data := make([]byte, n+1)
+	if _, err := file.ReadAt(data, info.Size()-n-1); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return data, nil
+}
+
+// databaseReport checks that the Lychee schema has the tables and columns
+// this program uses, and summarizes what a run would find in the album.
+func databaseReport(config *Config) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "database type: %s\n", config.Database.Type)
+
+	db, err := openDatabase(config)
+	if err != nil {
+		fmt.Fprintf(&b, "error: %v\n", err)
+		return b.String()
+	}
+	defer db.Close()
+
+	versionQuery := "SELECT version()"
+	if strings.HasPrefix(strings.ToLower(config.Database.Type), "sqlite") {
+		versionQuery = "SELECT sqlite_version()"
+	}
+	var version string
+	if err := db.QueryRow(versionQuery).Scan(&version); err != nil {
+		fmt.Fprintf(&b, "server version: error: %v\n", err)
+	} else {
+		fmt.Fprintf(&b, "server version: %s\n", version)
+	}
+
+	fmt.Fprintf(&b, "\nschema:\n")
+	required := requiredColumns(config.Database.Schema, config.IncludeSubAlbums || config.AllAlbums, len(config.AlbumTitles) > 0, tagPivotTable(db))
+	tables := make([]string, 0, len(required))
+	for table := range required {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		for _, column := range required[table] {
+			status := "ok"
+			rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", column, table))
+			if err != nil {
+				status = fmt.Sprintf("missing (%v)", err)
+			} else {
+				rows.Close()
+			}
+			fmt.Fprintf(&b, "\t%s.%s: %s\n", table, column, status)
+		}
+	}
+
+	imageVariants, err := sizeVariantPreference(config.SizeVariants, defaultSizeVariants)
+	if err != nil {
+		fmt.Fprintf(&b, "\nerror in size_variants config: %v\n", err)
+		return b.String()
+	}
+	videoVariants, err := sizeVariantPreference(config.VideoSizeVariants, defaultVideoSizeVariants)
+	if err != nil {
+		fmt.Fprintf(&b, "\nerror in video_size_variants config: %v\n", err)
+		return b.String()
+	}
+	needsTitle, err := config.TitleMatch.matcher()
+	if err != nil {
+		fmt.Fprintf(&b, "\nerror: %v\n", err)
+		return b.String()
+	}
+	albumIDs, err := selectAlbums(config, sqlLibrary{db: db, schema: config.Database.Schema})
+	if err != nil {
+		fmt.Fprintf(&b, "\nerror: %v\n", err)
+		return b.String()
+	}
+	for _, albumID := range albumIDs {
+		fmt.Fprintf(&b, "\nalbum %s:\n", albumID)
+		photos, err := queryPhotos(db, config.Database.Schema, albumID, config.IncludeSubAlbums, dateRange{}, imageVariants, videoVariants)
+		if err != nil {
+			fmt.Fprintf(&b, "\terror: %v\n", err)
+			continue
+		}
+
+		untitled, videos := 0, 0
+		for _, photo := range photos {
+			if needsTitle(photo.Title) {
+				untitled++
+			}
+			if photo.IsVideo() {
+				videos++
+			}
+		}
+		fmt.Fprintf(&b, "\tphotos with a usable size variant: %d (%d videos)\n", len(photos), videos)
+		fmt.Fprintf(&b, "\tphotos needing a title: %d\n", untitled)
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	config := `{
+		"base_url": "https://lychee.example.com",
+		"database": {"user": "lychee", "password": "hunter2"},
+		"download": {"cf_access_client_secret": "cf-secret", "headers": {"X-Bypass": "shared"}},
+		"healthcheck": {"url": "https://hc-ping.com/1f9b7f1c-uuid"},
+		"http_sink": {"url": "https://sink.example.com/hook?token=abc"},
+		"journal": {"type": "http", "url": "https://journal.example.com/abc"},
+		"notifications": [
+			{"type": "pushover", "token": "app-token", "user": "user-key"},
+			{"type": "ntfy", "topic": "secret-topic"}
+		],
+		"album_id": ["abc"]
+	}`
+	var raw any
+	if err := json.Unmarshal([]byte(config), &raw); err != nil {
+		t.Fatal(err)
+	}
+	out, err := json.Marshal(redact(raw, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"hunter2", "cf-secret", "shared", "1f9b7f1c", "token=abc", "journal.example.com", "app-token", "user-key", "secret-topic"} {
+		if strings.Contains(string(out), `"`+secret) || strings.Contains(string(out), secret+`"`) {
+			t.Errorf("redacted config contains %q: %s", secret, out)
+		}
+	}
+	for _, kept := range []string{"https://lychee.example.com", `"user":"lychee"`, `"album_id":["abc"]`, `"type":"pushover"`} {
+		if !strings.Contains(string(out), kept) {
+			t.Errorf("redacted config is missing %s: %s", kept, out)
+		}
+	}
+}
+
+func TestTailFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	if err := os.WriteFile(path, []byte("first line\nsecond line\nthird line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{1000, "first line\nsecond line\nthird line\n"},
+		{34, "first line\nsecond line\nthird line\n"},
+		{33, "second line\nthird line\n"},
+		{15, "third line\n"},
+		{22, "third line\n"},
+		{23, "second line\nthird line\n"},
+	}
+	for _, tt := range tests {
+		got, err := tailFile(path, tt.n)
+		if err != nil {
+			t.Fatalf("tailFile: %v", err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("tailFile(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
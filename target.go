@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Values of the target config setting, which chooses the photo fields that
+// the identified species is written to.
+const (
+	targetTitle       = "title"
+	targetDescription = "description"
+	targetBoth        = "both"
+)
+
+// targetFields returns the photos table columns that identified species are
+// written to. With tag_species, the species is also added to the photo's
+// tags.
+func (c *Config) targetFields() ([]string, error) {
+	var fields []string
+	switch c.Target {
+	case "", targetTitle:
+		fields = []string{"title"}
+	case targetDescription:
+		fields = []string{"description"}
+	case targetBoth:
+		fields = []string{"title", "description"}
+	default:
+		return nil, fmt.Errorf("unknown target %q (expected title, description, or both)", c.Target)
+	}
+	if c.TagSpecies {
+		fields = append(fields, "tags")
+	}
+	return fields, nil
+}
+
+// targetChanges returns the changes that write text to each of the photo's
+// target fields and add species to its tags, leaving out fields that already
+// hold them.
+func targetChanges(fields []string, photo Photo, text, species string) []fieldChange {
+	var changes []fieldChange
+	for _, field := range fields {
+		old, value := photo.Title, text
+		switch field {
+		case "description":
+			old = photo.Description
+		case "tags":
+			// OCR text can have stray spaces and line breaks in it
+			tag := strings.Join(strings.Fields(species), " ")
+			if tag == "" {
+				continue
+			}
+			old, value = strings.Join(photo.Tags, ","), addTag(photo.Tags, tag)
+		}
+		if old == value {
+			continue
+		}
+		changes = append(changes, fieldChange{Field: field, OldValue: old, NewValue: value})
+	}
+	return changes
+}
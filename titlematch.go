@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TitleMatchConfig chooses which photos need a title. By default, only
+// photos titled with a UUID, as Lychee titles uploads from some clients, do.
+type TitleMatchConfig struct {
+	// Patterns are regular expressions matched against each title, without
+	// its file extension. Photos whose titles match any of them need a title
+	// too.
+	Patterns []string `json:"patterns"`
+	// CameraFilenames matches the file names cameras and phones give photos,
+	// such as IMG_1234, DSCN0042, or PXL_20240501_123456789.
+	CameraFilenames bool `json:"camera_filenames"`
+	// All retitles every photo, whatever its title.
+	All bool `json:"all"`
+}
+
+var cameraFilenamePattern = regexp.MustCompile(`(?i)^(img|dsc[fn]?|_dsc|imgp|pict|p|gopr|gp|dji|pxl|sam|mvi|vid)[_-]?\d{3,}([_-]\d+)*$`)
+
+// matcher returns a function reporting whether a photo with the given title
+// needs a title.
+func (c TitleMatchConfig) matcher() (func(title string) bool, error) {
+	if c.All {
+		return func(string) bool { return true }, nil
+	}
+	var patterns []*regexp.Regexp
+	for _, p := range c.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error in title_match pattern %q: %v", p, err)
+		}
+		patterns = append(patterns, re)
+	}
+	if c.CameraFilenames {
+		patterns = append(patterns, cameraFilenamePattern)
+	}
+
+	return func(title string) bool {
+		if isUUID(title) {
+			return true
+		}
+		title = stripMediaExtension(title)
+		for _, re := range patterns {
+			if re.MatchString(title) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// stripMediaExtension removes a common image or video file extension (in any
+// case) from a title.
+func stripMediaExtension(title string) string {
+	lower := strings.ToLower(title)
+	for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".heic", ".mp4", ".mov", ".avi"} {
+		if strings.HasSuffix(lower, ext) {
+			return title[:len(title)-len(ext)]
+		}
+	}
+	return title
+}
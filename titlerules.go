@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TitleRule is one step in the title_rules pipeline, which cleans up OCR text
+// before it's used as a title. Type selects the transform; the other fields
+// are its settings.
+type TitleRule struct {
+	// Type is one of:
+	//   - replace: replace matches of the regular expression Pattern with
+	//     With, which may refer to submatches as $1, ${name}, etc.
+	//   - collapse_whitespace: trim the text and replace each run of
+	//     whitespace, including line breaks, with a single space
+	//   - case: convert to Case, which is lower, upper, or title (each
+	//     word capitalized)
+	//   - strip_prefix, strip_suffix: remove Value from the start or end
+	//   - max_length: truncate to Length characters
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+	With    string `json:"with"`
+	Case    string `json:"case"`
+	Value   string `json:"value"`
+	Length  int    `json:"length"`
+}
+
+// titleTransform is a compiled TitleRule.
+type titleTransform func(string) string
+
+// compileTitleRules returns a function applying the rules in order.
+func compileTitleRules(rules []TitleRule) (func(string) string, error) {
+	var transforms []titleTransform
+	for i, rule := range rules {
+		transform, err := rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("error in title_rules[%d]: %v", i, err)
+		}
+		transforms = append(transforms, transform)
+	}
+	return func(text string) string {
+		for _, transform := range transforms {
+			text = transform(text)
+		}
+		return text
+	}, nil
+}
+
+func (r TitleRule) compile() (titleTransform, error) {
+	switch r.Type {
+	case "replace":
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", r.Pattern, err)
+		}
+		return func(s string) string { return re.ReplaceAllString(s, r.With) }, nil
+	case "collapse_whitespace":
+		return func(s string) string { return strings.Join(strings.Fields(s), " ") }, nil
+	case "case":
+		switch r.Case {
+		case "lower":
+			return strings.ToLower, nil
+		case "upper":
+			return strings.ToUpper, nil
+		case "title":
+			return titleCase, nil
+		default:
+			return nil, fmt.Errorf("unknown case %q (expected lower, upper, or title)", r.Case)
+		}
+	case "strip_prefix":
+		return func(s string) string { return strings.TrimPrefix(s, r.Value) }, nil
+	case "strip_suffix":
+		return func(s string) string { return strings.TrimSuffix(s, r.Value) }, nil
+	case "max_length":
+		if r.Length <= 0 {
+			return nil, fmt.Errorf("max_length requires a positive length")
+		}
+		return func(s string) string {
+			if utf8.RuneCountInString(s) <= r.Length {
+				return s
+			}
+			return strings.TrimSpace(string([]rune(s)[:r.Length]))
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown rule type %q", r.Type)
+	}
+}
+
+// titleCase capitalizes the first letter of each space-separated word and
+// lowercases the rest, so "BLACK-CAPPED CHICKADEE" becomes "Black-capped
+// Chickadee".
+func titleCase(s string) string {
+	runes := []rune(strings.ToLower(s))
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
+
+// compileRejectPatterns returns a function reporting the first of the
+// reject_patterns that text matches, if any.
+func compileRejectPatterns(patterns []string) (func(text string) (string, bool), error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error in reject_patterns pattern %q: %v", p, err)
+		}
+		res = append(res, re)
+	}
+	return func(text string) (string, bool) {
+		for _, re := range res {
+			if re.MatchString(text) {
+				return re.String(), true
+			}
+		}
+		return "", false
+	}, nil
+}
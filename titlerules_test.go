@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestCompileTitleRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []TitleRule
+		text  string
+		want  string
+	}{
+		{"no rules", nil, " Blue Jay\n", " Blue Jay\n"},
+		{"replace", []TitleRule{{Type: "replace", Pattern: `\s*\d+%$`, With: ""}}, "Blue Jay 87%", "Blue Jay"},
+		{"replace submatch", []TitleRule{{Type: "replace", Pattern: `^(\w+), (\w+)$`, With: "$2 $1"}}, "Jay, Blue", "Blue Jay"},
+		{"collapse whitespace", []TitleRule{{Type: "collapse_whitespace"}}, "  Blue \n Jay\t", "Blue Jay"},
+		{"lower", []TitleRule{{Type: "case", Case: "lower"}}, "Blue Jay", "blue jay"},
+		{"upper", []TitleRule{{Type: "case", Case: "upper"}}, "Blue Jay", "BLUE JAY"},
+		{"title", []TitleRule{{Type: "case", Case: "title"}}, "BLACK-CAPPED CHICKADEE", "Black-capped Chickadee"},
+		{"strip prefix", []TitleRule{{Type: "strip_prefix", Value: "Bird: "}}, "Bird: Blue Jay", "Blue Jay"},
+		{"strip prefix absent", []TitleRule{{Type: "strip_prefix", Value: "Bird: "}}, "Blue Jay", "Blue Jay"},
+		{"strip suffix", []TitleRule{{Type: "strip_suffix", Value: " (male)"}}, "Northern Cardinal (male)", "Northern Cardinal"},
+		{"max length", []TitleRule{{Type: "max_length", Length: 5}}, "Blue Jay", "Blue"},
+		{"max length short", []TitleRule{{Type: "max_length", Length: 20}}, "Blue Jay", "Blue Jay"},
+		{"max length runes", []TitleRule{{Type: "max_length", Length: 3}}, "Élan", "Éla"},
+		{"in order", []TitleRule{
+			{Type: "collapse_whitespace"},
+			{Type: "strip_prefix", Value: "cam1 "},
+			{Type: "case", Case: "title"},
+		}, " cam1  BLUE\nJAY ", "Blue Jay"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clean, err := compileTitleRules(tt.rules)
+			if err != nil {
+				t.Fatalf("compileTitleRules: %v", err)
+			}
+			if got := clean(tt.text); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileTitleRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule TitleRule
+	}{
+		{"unknown type", TitleRule{Type: "reverse"}},
+		{"invalid pattern", TitleRule{Type: "replace", Pattern: "("}},
+		{"unknown case", TitleRule{Type: "case", Case: "sentence"}},
+		{"zero max length", TitleRule{Type: "max_length"}},
+		{"negative max length", TitleRule{Type: "max_length", Length: -1}},
+	}
+	for _, tt := range tests {
+		if _, err := compileTitleRules([]TitleRule{{Type: "collapse_whitespace"}, tt.rule}); err == nil {
+			t.Errorf("%s: compileTitleRules succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestCompileRejectPatterns(t *testing.T) {
+	rejected, err := compileRejectPatterns([]string{`(?i)^wyze`, `^\d+$`, `(?i)^wyze cam`})
+	if err != nil {
+		t.Fatalf("compileRejectPatterns: %v", err)
+	}
+	tests := []struct {
+		text    string
+		pattern string
+		wantOK  bool
+	}{
+		{"WYZE CAM v3", `(?i)^wyze`, true},
+		{"12345", `^\d+$`, true},
+		{"Blue Jay", "", false},
+		{"Blue Jay 2", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		pattern, ok := rejected(tt.text)
+		if ok != tt.wantOK || pattern != tt.pattern {
+			t.Errorf("rejected(%q) = %q, %v, want %q, %v", tt.text, pattern, ok, tt.pattern, tt.wantOK)
+		}
+	}
+
+	if _, err := compileRejectPatterns([]string{`ok`, `[`}); err == nil {
+		t.Error("compileRejectPatterns with an invalid pattern succeeded, want an error")
+	}
+	none, err := compileRejectPatterns(nil)
+	if err != nil {
+		t.Fatalf("compileRejectPatterns(nil): %v", err)
+	}
+	if _, ok := none("anything"); ok {
+		t.Error("no reject patterns rejected text")
+	}
+}
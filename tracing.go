@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracingConfig configures exporting traces of each run to an
+// OpenTelemetry collector, over OTLP/HTTP with JSON encoding.
+type TracingConfig struct {
+	// Endpoint is the collector's OTLP/HTTP base URL, e.g.
+	// http://localhost:4318; spans are sent to its /v1/traces.
+	Endpoint string `json:"endpoint"`
+	// ServiceName is reported as the service.name resource attribute
+	// (default lychee-birb-title).
+	ServiceName string `json:"service_name"`
+	// Headers are added to each export request, e.g. for authentication.
+	Headers map[string]string `json:"headers"`
+}
+
+// spanBatchSize is how many ended spans are buffered before they're
+// exported.
+const spanBatchSize = 512
+
+// tracer collects the spans of a run and exports them in batches.
+type tracer struct {
+	config TracingConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []*span
+	sending sync.WaitGroup
+}
+
+func newTracer(config TracingConfig, client *http.Client) *tracer {
+	if config.ServiceName == "" {
+		config.ServiceName = "lychee-birb-title"
+	}
+	return &tracer{config: config, client: client}
+}
+
+// span is a timed operation in a trace. A nil span records nothing, so
+// code can be instrumented whether or not tracing is configured.
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for a trace's root span
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    []slog.Attr
+	err      string
+}
+
+type spanKey struct{}
+
+// Start begins a new trace with a root span, returned along with a context
+// carrying it. A nil tracer returns a nil span.
+func (t *tracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, name: name, start: time.Now(), attrs: attrs}
+	_, _ = rand.Read(s.traceID[:])
+	_, _ = rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// startSpan begins a child of the span carried by ctx, returned along with
+// a context carrying it. If ctx carries no span, the returned span is nil.
+func startSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, *span) {
+	parent, _ := ctx.Value(spanKey{}).(*span)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: parent.tracer, traceID: parent.traceID, parentID: parent.spanID, name: name, start: time.Now(), attrs: attrs}
+	_, _ = rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) SetAttributes(attrs ...slog.Attr) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks the span as failed, with msg as its status message.
+func (s *span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.err = msg
+}
+
+// End finishes the span and queues it for export.
+func (s *span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, s)
+	if len(t.pending) >= spanBatchSize {
+		batch := t.pending
+		t.pending = nil
+		t.sending.Add(1)
+		go func() {
+			defer t.sending.Done()
+			if err := t.export(batch); err != nil {
+				slog.Error("Error exporting spans", "error", err)
+			}
+		}()
+	}
+}
+
+// Flush exports the spans that have ended and waits for earlier exports to
+// finish.
+func (t *tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	var err error
+	if len(batch) > 0 {
+		err = t.export(batch)
+	}
+	t.sending.Wait()
+	return err
+}
+
+// export sends spans to the collector as an OTLP ExportTraceServiceRequest.
+func (t *tracer) export(spans []*span) error {
+	type otlpSpan struct {
+		TraceID      string           `json:"traceId"`
+		SpanID       string           `json:"spanId"`
+		ParentSpanID string           `json:"parentSpanId,omitempty"`
+		Name         string           `json:"name"`
+		Kind         int              `json:"kind"`
+		Start        string           `json:"startTimeUnixNano"`
+		End          string           `json:"endTimeUnixNano"`
+		Attributes   []map[string]any `json:"attributes,omitempty"`
+		Status       map[string]any   `json:"status,omitempty"`
+	}
+
+	var otlpSpans []otlpSpan
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.spanID[:]),
+			Name:    s.name,
+			Kind:    1, // internal
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != ([8]byte{}) {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, attr := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpAttribute(attr))
+		}
+		if s.err != "" {
+			o.Status = map[string]any{"code": 2, "message": s.err} // error
+		}
+		otlpSpans = append(otlpSpans, o)
+	}
+
+	request := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []any{otlpAttribute(slog.String("service.name", t.config.ServiceName))},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "lychee-birb-title", "version": Version},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error encoding spans: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(t.config.Endpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating trace export request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error exporting spans: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("error exporting spans: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpAttribute encodes attr as an OTLP KeyValue.
+func otlpAttribute(attr slog.Attr) map[string]any {
+	var value map[string]any
+	switch v := attr.Value.Resolve(); v.Kind() {
+	case slog.KindBool:
+		value = map[string]any{"boolValue": v.Bool()}
+	case slog.KindInt64:
+		value = map[string]any{"intValue": strconv.FormatInt(v.Int64(), 10)}
+	case slog.KindUint64:
+		value = map[string]any{"intValue": strconv.FormatUint(v.Uint64(), 10)}
+	case slog.KindFloat64:
+		value = map[string]any{"doubleValue": v.Float64()}
+	default:
+		value = map[string]any{"stringValue": v.String()}
+	}
+	return map[string]any{"key": attr.Key, "value": value}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TranslationConfig translates species names between languages, such as
+// from a camera's Dutch overlay to English titles, using a name table.
+type TranslationConfig struct {
+	// File is a CSV file with a header row of language codes (such as "en",
+	// "nl", or "de") and a row of names for each species.
+	File string `json:"file"`
+	// From is the language of the overlay's names; if it's empty, names in
+	// any of the table's languages are translated.
+	From string `json:"from"`
+	// To is the language titles are written in.
+	To string `json:"to"`
+}
+
+// NameTable maps species names (normalized with normalizeTitle) to their
+// translations.
+type NameTable map[string]string
+
+// loadNameTable reads the translation config's name table. A config without
+// a file yields a nil table.
+func loadNameTable(c TranslationConfig) (NameTable, error) {
+	if c.File == "" {
+		return nil, nil
+	}
+	if c.To == "" {
+		return nil, fmt.Errorf("translation requires a target language (to)")
+	}
+
+	file, err := os.Open(c.File)
+	if err != nil {
+		return nil, fmt.Errorf("error opening name table: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading name table: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("name table %s is empty", c.File)
+	}
+
+	toCol, fromCol := -1, -1
+	for i, lang := range records[0] {
+		lang = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(lang, "\ufeff")))
+		if lang == strings.ToLower(c.To) {
+			toCol = i
+		}
+		if lang == strings.ToLower(c.From) {
+			fromCol = i
+		}
+	}
+	if toCol < 0 {
+		return nil, fmt.Errorf("name table has no %q column", c.To)
+	}
+	if c.From != "" && fromCol < 0 {
+		return nil, fmt.Errorf("name table has no %q column", c.From)
+	}
+
+	table := make(NameTable)
+	for _, record := range records[1:] {
+		if toCol >= len(record) || strings.TrimSpace(record[toCol]) == "" {
+			continue
+		}
+		to := strings.TrimSpace(record[toCol])
+		for i, name := range record {
+			if i == toCol || (fromCol >= 0 && i != fromCol) || strings.TrimSpace(name) == "" {
+				continue
+			}
+			table[normalizeTitle(name)] = to
+		}
+	}
+	return table, nil
+}
+
+// translate returns the translation of name, if the table has one.
+func (t NameTable) translate(name string) (string, bool) {
+	to, ok := t[normalizeTitle(name)]
+	return to, ok
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// runUndo implements the "undo" command, which restores the titles that the
+// given run changed, as recorded in the journal. Photos whose titles have
+// changed again since the run are left alone. The restored titles are
+// recorded in the journal as a run of their own, so an undo can be undone.
+func runUndo(config *Config, runID string, dryRun bool) error {
+	if config.Database.Type == "api" {
+		return fmt.Errorf("undo requires direct database access")
+	}
+
+	journal, err := newJournal(config)
+	if err != nil {
+		return fmt.Errorf("error opening journal: %v", err)
+	}
+	defer journal.Close()
+
+	reader, ok := journal.(JournalReader)
+	if !ok {
+		return fmt.Errorf("journal type %q can't be read back; undo requires a file, sqlite, or database journal", config.Journal.Type)
+	}
+	entries, err := reader.Entries()
+	if err != nil {
+		return err
+	}
+
+	// A photo's first change in the run has the title it had before the
+	// run, and its last the title the run left it with
+	type titleChange struct {
+		entry    JournalEntry
+		newTitle string
+	}
+	changes := make(map[string]*titleChange)
+	var order []string
+	other := 0
+	for _, entry := range entries {
+		if entry.RunID != runID {
+			continue
+		}
+		if entry.Field != "title" {
+			other++
+			continue
+		}
+		if change, ok := changes[entry.PhotoID]; ok {
+			change.newTitle = entry.NewValue
+			continue
+		}
+		changes[entry.PhotoID] = &titleChange{entry: entry, newTitle: entry.NewValue}
+		order = append(order, entry.PhotoID)
+	}
+	if len(order) == 0 && other == 0 {
+		return fmt.Errorf("no changes recorded for run %s", runID)
+	}
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	undoRunID := time.Now().UTC().Format("20060102T150405Z")
+	restored, drifted, missing := 0, 0, 0
+	for _, photoID := range order {
+		change := changes[photoID]
+		oldTitle := change.entry.OldValue
+
+		var title string
+		err := db.QueryRow("SELECT title FROM photos WHERE id = ?", photoID).Scan(&title)
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.Info("Photo deleted since run", "photo_id", photoID, "run_id", runID)
+			missing++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error querying photo %s: %v", photoID, err)
+		}
+		if title != change.newTitle {
+			slog.Info("Leaving title, which has changed since the run set it", "photo_id", photoID, "title", title, "run_id", runID, "run_title", change.newTitle)
+			drifted++
+			continue
+		}
+
+		if dryRun {
+			slog.Info("Would restore title", "photo_id", photoID, "title", oldTitle, "old_title", title)
+			restored++
+			continue
+		}
+		if err := updatePhoto(db, photoID, []fieldChange{{Field: "title", OldValue: title, NewValue: oldTitle}}); err != nil {
+			return fmt.Errorf("error restoring title of photo %s: %v", photoID, err)
+		}
+		slog.Info("Restored title", "photo_id", photoID, "title", oldTitle, "old_title", title)
+		restored++
+
+		if err := journal.Record(JournalEntry{
+			RunID:    undoRunID,
+			Time:     time.Now(),
+			PhotoID:  photoID,
+			AlbumID:  change.entry.AlbumID,
+			Field:    "title",
+			OldValue: title,
+			NewValue: oldTitle,
+		}); err != nil {
+			slog.Error("Error recording journal entry", "photo_id", photoID, "error", err)
+		}
+	}
+
+	verb := "Restored"
+	if dryRun {
+		verb = "Dry run: would restore"
+	}
+	slog.Info(verb+" titles", "run_id", runID, "restored", restored, "changed_since", drifted, "deleted", missing)
+	if other > 0 {
+		slog.Warn("Run also made changes other than titles (descriptions, tags, moves, or deletions), which weren't undone", "run_id", runID, "changes", other)
+	}
+	if !dryRun && restored > 0 {
+		slog.Info("Recorded as a new run; undo that run to reapply the titles", "run_id", undoRunID)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// runVerify implements the "verify" command, which checks that the titles
+// recorded in the change journal are still present in the database.
+func runVerify(config *Config) error {
+	journal, err := newJournal(config)
+	if err != nil {
+		return fmt.Errorf("error opening journal: %v", err)
+	}
+	defer journal.Close()
+
+	reader, ok := journal.(JournalReader)
+	if !ok {
+		return fmt.Errorf("journal type %q can't be read back; verify requires a file, sqlite, or database journal", config.Journal.Type)
+	}
+
+	entries, err := reader.Entries()
+	if err != nil {
+		return err
+	}
+
+	// Only the most recent change to each photo's title matters
+	latest := make(map[string]JournalEntry)
+	var order []string
+	for _, entry := range entries {
+		if entry.Field != "title" {
+			continue
+		}
+		if _, seen := latest[entry.PhotoID]; !seen {
+			order = append(order, entry.PhotoID)
+		}
+		latest[entry.PhotoID] = entry
+	}
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	matched, drifted, missing := 0, 0, 0
+	for _, photoID := range order {
+		entry := latest[photoID]
+
+		var title string
+		err := db.QueryRow("SELECT title FROM photos WHERE id = ?", photoID).Scan(&title)
+		if errors.Is(err, sql.ErrNoRows) {
+			fmt.Printf("Photo %s: deleted (we set its title to %q in run %s)\n", photoID, entry.NewValue, entry.RunID)
+			missing++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error querying photo %s: %v", photoID, err)
+		}
+
+		if title != entry.NewValue {
+			fmt.Printf("Photo %s: title is %q, but we set it to %q in run %s\n", photoID, title, entry.NewValue, entry.RunID)
+			drifted++
+			continue
+		}
+		matched++
+	}
+
+	fmt.Printf("Verified %d photos: %d unchanged, %d changed since we wrote them, %d deleted\n",
+		len(order), matched, drifted, missing)
+
+	if drifted > 0 {
+		return fmt.Errorf("%d photos have drifted from the journal", drifted)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VideoConfig controls which frames of a video are OCRed.
+type VideoConfig struct {
+	// FrameOffsets lists the timestamps (in seconds) of frames to try, in
+	// order, until one yields text.
+	FrameOffsets []float64 `json:"frame_offsets"`
+	// SampleFrames, if FrameOffsets is empty, samples this many frames spread
+	// evenly across the clip.
+	SampleFrames int `json:"sample_frames"`
+
+	// FFmpegPath and FFprobePath locate the ffmpeg and ffprobe binaries
+	// (default: looked up in PATH).
+	FFmpegPath  string `json:"ffmpeg_path"`
+	FFprobePath string `json:"ffprobe_path"`
+	// TimeoutSeconds limits how long a single ffmpeg or ffprobe invocation
+	// may run (default 60).
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+const defaultFFmpegTimeout = 60 * time.Second
+
+func (c VideoConfig) ffmpegPath() string {
+	if c.FFmpegPath != "" {
+		return c.FFmpegPath
+	}
+	return "ffmpeg"
+}
+
+func (c VideoConfig) ffprobePath() string {
+	if c.FFprobePath != "" {
+		return c.FFprobePath
+	}
+	return "ffprobe"
+}
+
+func (c VideoConfig) timeout() time.Duration {
+	if c.TimeoutSeconds > 0 {
+		return time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	return defaultFFmpegTimeout
+}
+
+// frameOffsets returns the timestamps of the frames to extract from a video
+// with the given duration in seconds.
+func (c VideoConfig) frameOffsets(duration float64) []float64 {
+	if len(c.FrameOffsets) > 0 {
+		return c.FrameOffsets
+	}
+	if c.SampleFrames > 0 && duration > 0 {
+		// Space samples evenly, skipping the very start and end of the clip
+		offsets := make([]float64, c.SampleFrames)
+		for i := range offsets {
+			offsets[i] = duration * float64(i+1) / float64(c.SampleFrames+1)
+		}
+		return offsets
+	}
+	return []float64{0}
+}
+
+// videoDuration returns the duration of the video in seconds, using ffprobe.
+func videoDuration(videoPath string, config VideoConfig) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, config.ffprobePath(),
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		videoPath)
+
+	output, err := cmd.Output()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return 0, fmt.Errorf("ffprobe timed out after %s", config.timeout())
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error probing video duration: %v", err)
+	}
+
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing video duration %q: %v", strings.TrimSpace(string(output)), err)
+	}
+	return duration, nil
+}
+
+// extractFrames extracts the frames selected by config from the video,
+// returning the paths of the extracted JPEGs in the order they should be
+// tried. Frames that can't be extracted (e.g. offsets past the end of the
+// clip) are skipped; an error is returned only if no frame could be extracted.
+func extractFrames(videoPath string, config VideoConfig) ([]string, error) {
+	var duration float64
+	if len(config.FrameOffsets) == 0 && config.SampleFrames > 0 {
+		var err error
+		duration, err = videoDuration(videoPath, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var frames []string
+	var lastErr error
+	for _, offset := range config.frameOffsets(duration) {
+		framePath, err := extractFrame(videoPath, offset, config)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		frames = append(frames, framePath)
+	}
+
+	if len(frames) == 0 {
+		return nil, lastErr
+	}
+	return frames, nil
+}
+
+// extractFrame extracts the frame at the given offset (in seconds) from the
+// video to a temporary JPEG file and returns its path.
+func extractFrame(videoPath string, offset float64, config VideoConfig) (string, error) {
+	// Create a temporary file for the output frame
+	tmpFile, err := os.CreateTemp("", "frame-*.jpg")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file: %v", err)
+	}
+	defer tmpFile.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout())
+	defer cancel()
+
+	// Use ffmpeg to extract a single frame with specific quality settings
+	cmd := exec.CommandContext(ctx, config.ffmpegPath(),
+		"-ss", strconv.FormatFloat(offset, 'f', 3, 64), // Seek to the frame
+		"-i", videoPath, // Input video
+		"-vframes", "1", // Extract only one frame
+		"-q:v", "2", // High quality
+		"-y",           // Overwrite output file if it exists
+		tmpFile.Name()) // Output file
+
+	// Capture both stdout and stderr for better error reporting
+	output, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("ffmpeg timed out after %s", config.timeout())
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("error extracting frame: %v (ffmpeg output: %s)", err, string(output))
+	}
+
+	// ffmpeg exits successfully without writing a frame when seeking past
+	// the end of the video
+	if info, err := os.Stat(tmpFile.Name()); err != nil || info.Size() == 0 {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("no frame found at %.3fs", offset)
+	}
+
+	return tmpFile.Name(), nil
+}